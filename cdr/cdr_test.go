@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cdr
+
+import (
+	"bytes"
+	"context"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryWriter - Keeps every record written
+type memoryWriter struct {
+	lock    sync.Mutex
+	records []Record
+}
+
+func (w *memoryWriter) Write(record *Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.records = append(w.records, *record)
+	return nil
+}
+
+func (w *memoryWriter) Close() error {
+	return nil
+}
+
+func (w *memoryWriter) written() []Record {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]Record(nil), w.records...)
+}
+
+// Replays the recorded calls into a collector attached to the replayed connection
+func replayCalls(t *testing.T, writer Writer, count func() int) *Collector {
+	recording, err := os.Open("testdata/calls.rec")
+	require.NoError(t, err)
+	defer recording.Close()
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	conn, replay, err := opts.Replay(recording)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	collector, err := NewCollector(Options{Writer: writer, Variables: []string{"sip_user_agent", "accountcode"}})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, collector.Attach(ctx, conn))
+
+	<-replay.Done()
+	require.NoError(t, replay.Err())
+	assert.Eventually(t, func() bool { return count() == 3 }, 5*time.Second, 5*time.Millisecond)
+	return collector
+}
+
+func TestCollector_RecordedCalls(t *testing.T) {
+	writer := &memoryWriter{}
+	collector := replayCalls(t, writer, func() int { return len(writer.written()) })
+	assert.NoError(t, collector.Close())
+	records := writer.written()
+
+	// The bridged call is written once both legs hung up
+	bridged := records[0]
+	assert.Equal(t, "a1a1a1a1-0000-4000-8000-000000000001", bridged.A.UUID)
+	assert.Equal(t, "inbound", bridged.A.Direction)
+	assert.Equal(t, "Alice", bridged.A.CallerIDName)
+	assert.Equal(t, "1001", bridged.A.DestinationNumber)
+	assert.Equal(t, 65*time.Second, bridged.A.Duration)
+	assert.Equal(t, 60*time.Second, bridged.A.BillSec)
+	assert.Equal(t, time.Date(2020, 6, 1, 12, 0, 5, 0, time.UTC), bridged.A.Answer)
+	assert.Equal(t, call.CauseNormalClearing, bridged.A.HangupCause)
+	assert.Equal(t, "PCMU", bridged.A.ReadCodec)
+	assert.Equal(t, map[string]string{"sip_user_agent": "Phone A", "accountcode": "sales"}, bridged.A.Variables)
+	assert.Len(t, bridged.B, 1)
+	assert.Equal(t, "b1b1b1b1-0000-4000-8000-000000000001", bridged.Other().UUID)
+	assert.Equal(t, "Phone B", bridged.Other().Var("sip_user_agent"))
+	assert.True(t, bridged.Other().IsBLeg())
+	// Headers and variables not selected with Options.Variables can still be read
+	assert.Equal(t, "a1a1a1a1-0000-4000-8000-000000000001", bridged.A.Header("Unique-ID"))
+	assert.Equal(t, "NORMAL_CLEARING", bridged.A.Var("hangup_cause"))
+	assert.Equal(t, "65", bridged.A.Header("variable_duration"))
+
+	// The unanswered call has no B legs
+	unanswered := records[1]
+	assert.Equal(t, "a2a2a2a2-0000-4000-8000-000000000002", unanswered.A.UUID)
+	assert.False(t, unanswered.A.Answered())
+	assert.Equal(t, time.Duration(0), unanswered.A.BillSec)
+	assert.Equal(t, call.CauseNoAnswer, unanswered.A.HangupCause)
+	assert.True(t, unanswered.A.HangupCause.IsNoAnswer())
+	assert.Equal(t, Leg{}, unanswered.Other())
+
+	// The failover call waits for the bridged B leg that hung up after the A leg and keeps the failed attempt
+	failover := records[2]
+	assert.Equal(t, "a3a3a3a3-0000-4000-8000-000000000003", failover.A.UUID)
+	assert.Len(t, failover.B, 2)
+	assert.Equal(t, call.CauseNoAnswer, failover.B[0].HangupCause)
+	assert.Equal(t, "3002", failover.Other().DestinationNumber)
+	assert.Equal(t, 80*time.Second, failover.Other().BillSec)
+}
+
+func TestCollector_Writers(t *testing.T) {
+	var csvOutput, jsonOutput bytes.Buffer
+	template := MustTemplate(
+		Field{Name: "uuid", Template: "{{.A.UUID}}"},
+		Field{Name: "caller", Template: "{{.A.CallerIDName}} <{{.A.CallerIDNumber}}>"},
+		Field{Name: "answered", Template: "{{unix .A.Answer}}"},
+		Field{Name: "billsec", Template: "{{seconds .A.BillSec}}"},
+		Field{Name: "agent", Template: `{{.A.Var "sip_user_agent"}}`},
+		Field{Name: "b_cause", Template: "{{.Other.HangupCause}}"},
+	)
+	csvWriter := NewCSVWriter(&csvOutput, template)
+	jsonWriter := NewJSONWriter(&jsonOutput, template)
+	writer := &memoryWriter{}
+
+	collector := replayCalls(t, writer, func() int { return len(writer.written()) })
+	assert.NoError(t, collector.Close())
+	for _, record := range writer.written() {
+		record := record
+		assert.NoError(t, csvWriter.Write(&record))
+		assert.NoError(t, jsonWriter.Write(&record))
+	}
+	assert.NoError(t, csvWriter.Close())
+	assert.NoError(t, jsonWriter.Close())
+
+	assert.Equal(t, "uuid,caller,answered,billsec,agent,b_cause\n"+
+		"a1a1a1a1-0000-4000-8000-000000000001,Alice <1000>,1591012805,60,Phone A,NORMAL_CLEARING\n"+
+		"a2a2a2a2-0000-4000-8000-000000000002,Bob <2000>,,0,Phone C,\n"+
+		"a3a3a3a3-0000-4000-8000-000000000003,Carol <3000>,1591013021,79,,NORMAL_CLEARING\n", csvOutput.String())
+	lines := strings.Split(strings.TrimSpace(jsonOutput.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, `{"uuid":"a2a2a2a2-0000-4000-8000-000000000002","caller":"Bob <2000>","answered":"","billsec":"0","agent":"Phone C","b_cause":""}`, lines[1])
+}
+
+func TestLeg_Header(t *testing.T) {
+	leg := NewLeg(&eslgo.Event{Headers: textproto.MIMEHeader{
+		"Event-Name":              {"CHANNEL_HANGUP_COMPLETE"},
+		"Unique-Id":               {"abc"},
+		"Variable_sip_user_agent": {"Phone%20A"},
+	}}, nil)
+	assert.Equal(t, "abc", leg.Header("Unique-ID"))
+	assert.Equal(t, "abc", leg.Header("unique-id"))
+	assert.Equal(t, "Phone A", leg.Var("sip_user_agent"))
+	assert.Equal(t, "", leg.Var("accountcode"))
+	assert.Empty(t, leg.Variables)
+}
+
+func TestCollector_Timeout(t *testing.T) {
+	writer := &memoryWriter{}
+	collector, err := NewCollector(Options{Writer: writer, Timeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	// The A leg was bridged to a B leg whose hangup never arrives
+	collector.HandleEvent(&eslgo.Event{Headers: textproto.MIMEHeader{
+		"Event-Name":          {"CHANNEL_HANGUP_COMPLETE"},
+		"Unique-Id":           {"a"},
+		"Other-Leg-Unique-Id": {"b"},
+	}})
+	collector.HandleEvent(&eslgo.Event{Headers: textproto.MIMEHeader{"Event-Name": {"CHANNEL_HANGUP"}, "Unique-Id": {"c"}}})
+	assert.Empty(t, writer.written())
+	assert.Eventually(t, func() bool { return len(writer.written()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, "a", writer.written()[0].A.UUID)
+	assert.Empty(t, writer.written()[0].B)
+	assert.NoError(t, collector.Close())
+
+	// A B leg whose A leg never arrives is written after the timeout, or on Close
+	collector, err = NewCollector(Options{Writer: writer, Timeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+	collector.HandleEvent(&eslgo.Event{Headers: textproto.MIMEHeader{
+		"Event-Name":          {"CHANNEL_HANGUP_COMPLETE"},
+		"Unique-Id":           {"d"},
+		"Variable_originator": {"e"},
+	}})
+	assert.Eventually(t, func() bool { return len(writer.written()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, "e", writer.written()[1].A.UUID)
+	assert.Equal(t, "d", writer.written()[1].B[0].UUID)
+	collector, err = NewCollector(Options{Writer: writer, Timeout: time.Hour})
+	require.NoError(t, err)
+	collector.HandleEvent(&eslgo.Event{Headers: textproto.MIMEHeader{
+		"Event-Name":          {"CHANNEL_HANGUP_COMPLETE"},
+		"Unique-Id":           {"f"},
+		"Variable_originator": {"g"},
+	}})
+	assert.NoError(t, collector.Close())
+	assert.Len(t, writer.written(), 3)
+	assert.Equal(t, "g", writer.written()[2].A.UUID)
+
+	_, err = NewCollector(Options{})
+	assert.Error(t, err)
+	_, err = NewTemplate(Field{Name: "broken", Template: "{{.A.UUID"})
+	assert.Error(t, err)
+}
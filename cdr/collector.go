@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cdr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+// Options - Used to configure a Collector
+type Options struct {
+	Writer    Writer          // Where complete records are written, required
+	Variables []string        // Channel variables copied into Leg.Variables, without the variable_ prefix, e.g. "sip_user_agent"
+	Timeout   time.Duration   // How long a hung up leg waits for the other legs of its call before its record is written anyway
+	OnError   func(err error) // An optional function called with errors returned by the Writer
+}
+
+// DefaultOptions - The default options used for fields left zero
+var DefaultOptions = Options{
+	Timeout: 30 * time.Second,
+}
+
+// Collector - Assembles call detail records from CHANNEL_HANGUP_COMPLETE events. A record is written once the A leg
+// and every B leg known to it have hung up, or once Options.Timeout passed since the first of them hung up. B legs whose
+// A leg never arrives, e.g. when the collector attached mid-call, are written after the timeout or by Flush and Close
+// in a record whose A leg only has its UUID set.
+type Collector struct {
+	opts     Options
+	lock     sync.Mutex
+	pending  map[string]*pendingRecord // By A leg UUID
+	closed   bool
+	attached []attachment
+}
+
+// attachment - A connection the collector receives events from
+type attachment struct {
+	stop func()
+	done chan struct{}
+}
+
+type pendingRecord struct {
+	record   Record
+	complete bool // The A leg hung up
+	timer    *time.Timer
+}
+
+// NewCollector - Creates a collector writing records to opts.Writer
+func NewCollector(opts Options) (*Collector, error) {
+	if opts.Writer == nil {
+		return nil, errors.New("cdr: a Writer is required")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+	return &Collector{
+		opts:    opts,
+		pending: make(map[string]*pendingRecord),
+	}, nil
+}
+
+// Attach - Subscribes to CHANNEL_HANGUP_COMPLETE events on the connection and collects records from them until Close
+func (c *Collector) Attach(ctx context.Context, conn *eslgo.Conn) error {
+	sub := eslgo.EventSubscription{Events: []string{"CHANNEL_HANGUP_COMPLETE"}}
+	events, stop, err := conn.SubscribeEvents(ctx, sub, eslgo.SubscribeOptions{Buffer: 1024})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			c.HandleEvent(event)
+		}
+	}()
+	c.lock.Lock()
+	c.attached = append(c.attached, attachment{stop: stop, done: done})
+	c.lock.Unlock()
+	return nil
+}
+
+// HandleEvent - Adds the leg from a CHANNEL_HANGUP_COMPLETE event, other events are ignored. Used by Attach and to build records from recorded events.
+func (c *Collector) HandleEvent(event *eslgo.Event) {
+	if event.GetName() != "CHANNEL_HANGUP_COMPLETE" {
+		return
+	}
+	leg := NewLeg(event, c.opts.Variables)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+
+	key := leg.UUID
+	if leg.IsBLeg() {
+		key = leg.OriginatorUUID
+	}
+	pending := c.pendingFor(key)
+	if leg.IsBLeg() {
+		pending.record.B = append(pending.record.B, leg)
+	} else {
+		pending.record.A = leg
+		pending.complete = true
+	}
+	if pending.ready() {
+		c.finish(key, pending)
+	}
+}
+
+// Flush - Writes every record still waiting for legs to hang up
+func (c *Collector) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, pending := range c.pending {
+		c.finish(key, pending)
+	}
+}
+
+// Close - Stops collecting, writes every pending record and closes the Writer
+func (c *Collector) Close() error {
+	c.lock.Lock()
+	attached := c.attached
+	c.attached = nil
+	c.lock.Unlock()
+	for _, attachment := range attached {
+		attachment.stop()
+		<-attachment.done
+	}
+
+	// Flushed under the same lock that marks the collector closed, no leg can start a record that is written after the Writer closed
+	c.lock.Lock()
+	for key, pending := range c.pending {
+		c.finish(key, pending)
+	}
+	c.closed = true
+	c.lock.Unlock()
+	return c.opts.Writer.Close()
+}
+
+// Returns the pending record for the A leg UUID, creating it and starting its timeout if needed. Caller must hold lock
+func (c *Collector) pendingFor(key string) *pendingRecord {
+	if pending, ok := c.pending[key]; ok {
+		return pending
+	}
+	pending := &pendingRecord{record: Record{A: Leg{UUID: key}}}
+	pending.timer = time.AfterFunc(c.opts.Timeout, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.pending[key] == pending {
+			c.finish(key, pending)
+		}
+	})
+	c.pending[key] = pending
+	return pending
+}
+
+// Writes the record and forgets it. Caller must hold lock
+func (c *Collector) finish(key string, pending *pendingRecord) {
+	pending.timer.Stop()
+	delete(c.pending, key)
+	record := pending.record
+	if err := c.opts.Writer.Write(&record); err != nil && c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
+
+// A record is ready once the A leg hung up along with the leg it was bridged to
+func (p *pendingRecord) ready() bool {
+	if !p.complete {
+		return false
+	}
+	if p.record.A.OtherLegUUID == "" {
+		return true
+	}
+	for _, leg := range p.record.B {
+		if leg.UUID == p.record.A.OtherLegUUID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Package cdr builds call detail records from CHANNEL_HANGUP_COMPLETE events, joining the legs of bridged calls,
+// and writes them as JSON or CSV with configurable fields.
+package cdr
+
+import (
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command/call"
+)
+
+// Leg - A single channel of a call, built from its CHANNEL_HANGUP_COMPLETE event
+type Leg struct {
+	UUID              string
+	Direction         string // inbound or outbound from the point of view of FreeSWITCH
+	CallerIDName      string
+	CallerIDNumber    string
+	DestinationNumber string
+	OtherLegUUID      string // The leg this one was bridged to, if any
+	OriginatorUUID    string // The leg that originated this one, empty for A legs
+	Start             time.Time
+	Answer            time.Time // Zero if the leg was never answered
+	End               time.Time
+	Duration          time.Duration // From creation to hangup
+	BillSec           time.Duration // From answer to hangup
+	HangupCause       call.HangupCause
+	ReadCodec         string
+	WriteCodec        string
+	Variables         map[string]string // The channel variables selected with Options.Variables, without the variable_ prefix
+
+	headers map[string]string
+}
+
+// Record - A call detail record, the A leg with the B legs it originated in the order they hung up
+type Record struct {
+	A Leg
+	B []Leg
+}
+
+// Other - Returns the B leg the A leg was bridged to, the last B leg if it was never bridged, or a zero Leg if there are no B legs
+func (r *Record) Other() Leg {
+	for _, leg := range r.B {
+		if leg.UUID == r.A.OtherLegUUID {
+			return leg
+		}
+	}
+	if len(r.B) > 0 {
+		return r.B[len(r.B)-1]
+	}
+	return Leg{}
+}
+
+// Header - Returns the decoded value of any header of the leg's hangup event, the name is case insensitive
+func (l Leg) Header(name string) string {
+	return l.headers[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+// Var - Returns the value of a channel variable of the leg, e.g. Var("sip_user_agent")
+func (l Leg) Var(name string) string {
+	if value, ok := l.Variables[name]; ok {
+		return value
+	}
+	return l.Header("variable_" + name)
+}
+
+// Answered - Reports if the leg was answered
+func (l Leg) Answered() bool {
+	return !l.Answer.IsZero()
+}
+
+// IsBLeg - Reports if the leg was originated by another leg
+func (l Leg) IsBLeg() bool {
+	return l.OriginatorUUID != ""
+}
+
+// NewLeg - Builds a leg from a CHANNEL_HANGUP_COMPLETE event, copying the selected channel variables
+func NewLeg(event *eslgo.Event, variables []string) Leg {
+	headers := make(map[string]string, len(event.Headers))
+	for key := range event.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = event.GetHeader(key)
+	}
+	leg := Leg{
+		UUID:              event.GetHeader("Unique-ID"),
+		Direction:         firstHeader(event, "Call-Direction", "Caller-Direction"),
+		CallerIDName:      event.GetHeader("Caller-Caller-ID-Name"),
+		CallerIDNumber:    event.GetHeader("Caller-Caller-ID-Number"),
+		DestinationNumber: event.GetHeader("Caller-Destination-Number"),
+		OtherLegUUID:      firstHeader(event, "Other-Leg-Unique-ID", "Bridge-UUID", "variable_bridge_uuid"),
+		OriginatorUUID:    event.GetHeader("variable_originator"),
+		Start:             microseconds(firstHeader(event, "Caller-Channel-Created-Time", "variable_start_uepoch")),
+		Answer:            microseconds(firstHeader(event, "Caller-Channel-Answered-Time", "variable_answer_uepoch")),
+		End:               microseconds(firstHeader(event, "Caller-Channel-Hangup-Time", "variable_end_uepoch")),
+		HangupCause:       event.GetHangupCause(),
+		ReadCodec:         firstHeader(event, "Channel-Read-Codec-Name", "variable_read_codec"),
+		WriteCodec:        firstHeader(event, "Channel-Write-Codec-Name", "variable_write_codec"),
+		Variables:         make(map[string]string, len(variables)),
+		headers:           headers,
+	}
+	if leg.OriginatorUUID == "" && event.GetHeader("Other-Type") == "originator" {
+		leg.OriginatorUUID = event.GetHeader("Other-Leg-Unique-ID")
+	}
+
+	// FreeSWITCH computes duration and billsec, fall back to the timestamps if they are missing
+	leg.Duration = seconds(event.GetHeader("variable_duration"), leg.Start, leg.End)
+	leg.BillSec = seconds(event.GetHeader("variable_billsec"), leg.Answer, leg.End)
+	for _, name := range variables {
+		if value := event.GetHeader("variable_" + name); value != "" {
+			leg.Variables[name] = value
+		}
+	}
+	return leg
+}
+
+func firstHeader(event *eslgo.Event, names ...string) string {
+	for _, name := range names {
+		if value := event.GetHeader(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Parses a FreeSWITCH timestamp in microseconds since the epoch, 0 means not set
+func microseconds(value string) time.Time {
+	usec, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || usec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, usec*int64(time.Microsecond)).UTC()
+}
+
+func seconds(value string, from, to time.Time) time.Duration {
+	if sec, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+		return time.Duration(sec) * time.Second
+	}
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from).Truncate(time.Second)
+}
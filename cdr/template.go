@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cdr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Field - A named output field, the value is a text/template executed against the Record, e.g. {{.A.CallerIDNumber}} or {{.A.Var "sip_user_agent"}}.
+// Besides the standard functions templates can use rfc3339 and unix to format times and seconds to format durations.
+type Field struct {
+	Name     string
+	Template string
+}
+
+// Template - The compiled fields written for every record
+type Template struct {
+	names     []string
+	templates []*template.Template
+}
+
+// DefaultFields - The fields used when a writer is given a nil Template
+var DefaultFields = []Field{
+	{Name: "uuid", Template: "{{.A.UUID}}"},
+	{Name: "direction", Template: "{{.A.Direction}}"},
+	{Name: "caller_id_name", Template: "{{.A.CallerIDName}}"},
+	{Name: "caller_id_number", Template: "{{.A.CallerIDNumber}}"},
+	{Name: "destination_number", Template: "{{.A.DestinationNumber}}"},
+	{Name: "start", Template: "{{rfc3339 .A.Start}}"},
+	{Name: "answer", Template: "{{rfc3339 .A.Answer}}"},
+	{Name: "end", Template: "{{rfc3339 .A.End}}"},
+	{Name: "duration", Template: "{{seconds .A.Duration}}"},
+	{Name: "billsec", Template: "{{seconds .A.BillSec}}"},
+	{Name: "hangup_cause", Template: "{{.A.HangupCause}}"},
+	{Name: "read_codec", Template: "{{.A.ReadCodec}}"},
+	{Name: "write_codec", Template: "{{.A.WriteCodec}}"},
+	{Name: "b_uuid", Template: "{{.Other.UUID}}"},
+	{Name: "b_destination_number", Template: "{{.Other.DestinationNumber}}"},
+	{Name: "b_billsec", Template: "{{seconds .Other.BillSec}}"},
+	{Name: "b_hangup_cause", Template: "{{.Other.HangupCause}}"},
+}
+
+var templateFuncs = template.FuncMap{
+	"rfc3339": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	},
+	"unix": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return strconv.FormatInt(t.Unix(), 10)
+	},
+	"seconds": func(d time.Duration) string {
+		return strconv.FormatInt(int64(d/time.Second), 10)
+	},
+}
+
+// NewTemplate - Compiles the fields, returns an error naming the first field with an invalid template
+func NewTemplate(fields ...Field) (*Template, error) {
+	compiled := &Template{}
+	for _, field := range fields {
+		parsed, err := template.New(field.Name).Funcs(templateFuncs).Option("missingkey=zero").Parse(field.Template)
+		if err != nil {
+			return nil, fmt.Errorf("cdr: field %s: %w", field.Name, err)
+		}
+		compiled.names = append(compiled.names, field.Name)
+		compiled.templates = append(compiled.templates, parsed)
+	}
+	return compiled, nil
+}
+
+// MustTemplate - Same as NewTemplate but panics if a template is invalid, for templates known at compile time
+func MustTemplate(fields ...Field) *Template {
+	compiled, err := NewTemplate(fields...)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+var defaultTemplate = MustTemplate(DefaultFields...)
+
+// Names - Returns the field names in order
+func (t *Template) Names() []string {
+	return append([]string(nil), t.names...)
+}
+
+// Execute - Returns the values of every field for the record in order
+func (t *Template) Execute(record *Record) ([]string, error) {
+	values := make([]string, len(t.templates))
+	var builder strings.Builder
+	for i, field := range t.templates {
+		builder.Reset()
+		if err := field.Execute(&builder, record); err != nil {
+			return nil, fmt.Errorf("cdr: field %s: %w", t.names[i], err)
+		}
+		values[i] = builder.String()
+	}
+	return values, nil
+}
+
+func templateOrDefault(t *Template) *Template {
+	if t == nil {
+		return defaultTemplate
+	}
+	return t
+}
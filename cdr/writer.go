@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cdr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Writer - Stores complete call detail records, see JSONWriter and CSVWriter
+type Writer interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// JSONWriter - Writes every record as a JSON object on its own line with the template fields in order
+type JSONWriter struct {
+	lock     sync.Mutex
+	writer   *bufio.Writer
+	template *Template
+}
+
+// NewJSONWriter - Creates a writer of JSON Lines to w, a nil template writes DefaultFields, w is not closed by the writer
+func NewJSONWriter(w io.Writer, template *Template) *JSONWriter {
+	return &JSONWriter{writer: bufio.NewWriter(w), template: templateOrDefault(template)}
+}
+
+func (w *JSONWriter) Write(record *Record) error {
+	values, err := w.template.Execute(record)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writer.WriteByte('{')
+	for i, name := range w.template.names {
+		if i > 0 {
+			w.writer.WriteByte(',')
+		}
+		w.writeString(name)
+		w.writer.WriteByte(':')
+		w.writeString(values[i])
+	}
+	w.writer.WriteString("}\n")
+	return w.writer.Flush()
+}
+
+// Writes a JSON string without escaping HTML characters such as the angle brackets of "Name <number>". Caller must hold lock
+func (w *JSONWriter) writeString(value string) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+	w.writer.Write(bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
+}
+
+func (w *JSONWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.writer.Flush()
+}
+
+// CSVWriter - Writes a header row with the field names followed by a row for every record
+type CSVWriter struct {
+	lock          sync.Mutex
+	writer        *csv.Writer
+	template      *Template
+	headerWritten bool
+}
+
+// NewCSVWriter - Creates a CSV writer to w, a nil template writes DefaultFields, w is not closed by the writer
+func NewCSVWriter(w io.Writer, template *Template) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w), template: templateOrDefault(template)}
+}
+
+func (w *CSVWriter) Write(record *Record) error {
+	values, err := w.template.Execute(record)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.headerWritten {
+		if err := w.writer.Write(w.template.names); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+	if err := w.writer.Write(values); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *CSVWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writer.Flush()
+	return w.writer.Error()
+}
@@ -0,0 +1,394 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Package cluster manages inbound connections to several FreeSWITCH nodes. Events are tagged with the node they came
+// from, commands for a channel are routed to the node that owns it and new calls are spread over the healthy nodes by a Policy.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command"
+)
+
+var (
+	// ErrUnknownChannel - Returned when no node is known to own a channel
+	ErrUnknownChannel = errors.New("cluster: channel is not owned by any known node")
+	// ErrNoHealthyNode - Returned when a call cannot be placed because every node is down
+	ErrNoHealthyNode = errors.New("cluster: no healthy node")
+)
+
+// NodeConfig - A FreeSWITCH node of the cluster
+type NodeConfig struct {
+	Name     string // Unique name events are tagged with. Defaults to the address
+	Address  string // The host:port of the event socket
+	Password string // Defaults to the password of Options.Inbound
+	Weight   int    // Used by the Weighted policy. Defaults to 1
+}
+
+// NodeStatus - A snapshot of the state of a node
+type NodeStatus struct {
+	Name          string
+	Address       string
+	Weight        int
+	Connected     bool      // The event socket connection is up
+	Healthy       bool      // Connected and answering health checks, only healthy nodes are picked for new calls
+	Sessions      int       // The number of sessions on the node, see LeastSessions
+	Channels      int       // The number of channels routed to the node
+	LastHeartbeat time.Time // When the last HEARTBEAT event was received, zero if none was
+	LastError     error     // The error that last took the node down, nil if none did
+}
+
+// Options - Used to configure a Cluster, start from DefaultOptions
+type Options struct {
+	Nodes          []NodeConfig
+	Inbound        eslgo.InboundOptions    // Used to dial every node. Its Observer is also notified when a node reconnects
+	Events         []string                // Event names delivered to listeners besides the CHANNEL_CREATE, CHANNEL_DESTROY and HEARTBEAT events the cluster needs
+	Subclasses     []string                // CUSTOM event subclasses delivered to listeners
+	Policy         Policy                  // Picks the node new calls are originated on. Defaults to RoundRobin
+	HealthInterval time.Duration           // How often every node is checked with the status api
+	HealthTimeout  time.Duration           // How long a node has to answer a health check, also used to set up new connections
+	ReconnectDelay time.Duration           // How long to wait before dialing a node again after its connection was lost
+	OnNodeChange   func(status NodeStatus) // Optional, called when a node connects, disconnects or fails a health check. Called concurrently for different nodes
+}
+
+// DefaultOptions - The default options used for creating a cluster
+var DefaultOptions = Options{
+	Inbound:        eslgo.DefaultInboundOptions,
+	HealthInterval: 10 * time.Second,
+	HealthTimeout:  5 * time.Second,
+	ReconnectDelay: 2 * time.Second,
+}
+
+// NodeEvent - An event and the name of the node it came from
+type NodeEvent struct {
+	Node string
+	*eslgo.Event
+}
+
+// Listener - Called with the events of every node. Events of a node are delivered one at a time in the order they were
+// received, so listeners must not block. Listeners cannot register or remove listeners.
+type Listener func(event NodeEvent)
+
+// Cluster - Connections to a set of FreeSWITCH nodes
+type Cluster struct {
+	opts      Options
+	ctx       context.Context
+	cancel    func()
+	wait      sync.WaitGroup
+	closeOnce sync.Once
+
+	lock     sync.RWMutex
+	nodes    []*node          // In configured order
+	byName   map[string]*node // By NodeConfig.Name
+	channels map[string]*node // By channel UUID
+
+	listenerLock    sync.RWMutex
+	listeners       map[string]registeredListener
+	listenerCounter int
+
+	pickLock sync.Mutex
+}
+
+type registeredListener struct {
+	matcher  eslgo.EventMatcher
+	listener Listener
+}
+
+// Dial - Connects to every node of the cluster. Returns an error only if no node could be connected, nodes that
+// failed are dialed again in the background every ReconnectDelay.
+func Dial(ctx context.Context, opts Options) (*Cluster, error) {
+	if len(opts.Nodes) == 0 {
+		return nil, errors.New("cluster: no nodes")
+	}
+	if opts.Policy == nil {
+		opts.Policy = RoundRobin()
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = DefaultOptions.HealthInterval
+	}
+	if opts.HealthTimeout <= 0 {
+		opts.HealthTimeout = DefaultOptions.HealthTimeout
+	}
+	if opts.ReconnectDelay <= 0 {
+		opts.ReconnectDelay = DefaultOptions.ReconnectDelay
+	}
+	parent := opts.Inbound.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	runningContext, cancel := context.WithCancel(parent)
+	c := &Cluster{
+		opts:      opts,
+		ctx:       runningContext,
+		cancel:    cancel,
+		byName:    make(map[string]*node),
+		channels:  make(map[string]*node),
+		listeners: make(map[string]registeredListener),
+	}
+	for _, config := range opts.Nodes {
+		if config.Name == "" {
+			config.Name = config.Address
+		}
+		if config.Weight <= 0 {
+			config.Weight = 1
+		}
+		if _, ok := c.byName[config.Name]; ok {
+			cancel()
+			return nil, fmt.Errorf("cluster: duplicate node %s", config.Name)
+		}
+		n := &node{config: config}
+		c.nodes = append(c.nodes, n)
+		c.byName[config.Name] = n
+	}
+
+	results := make(chan error, len(c.nodes))
+	for _, n := range c.nodes {
+		c.wait.Add(1)
+		go c.run(n, results)
+	}
+	var failures []string
+	for range c.nodes {
+		select {
+		case err := <-results:
+			if err != nil {
+				failures = append(failures, err.Error())
+			}
+		case <-ctx.Done():
+			c.Close()
+			return nil, ctx.Err()
+		}
+	}
+	if len(failures) == len(c.nodes) {
+		c.Close()
+		return nil, errors.New("cluster: no node could be connected: " + strings.Join(failures, "; "))
+	}
+	return c, nil
+}
+
+// Close - Gracefully closes the connection to every node and stops reconnecting
+func (c *Cluster) Close() {
+	c.closeOnce.Do(func() {
+		c.lock.RLock()
+		var conns []*eslgo.Conn
+		for _, n := range c.nodes {
+			if n.conn != nil {
+				conns = append(conns, n.conn)
+			}
+		}
+		c.lock.RUnlock()
+		for _, conn := range conns {
+			conn.ExitAndClose()
+		}
+		c.cancel()
+		c.wait.Wait()
+	})
+}
+
+// RegisterListener - Registers a listener called with the events of every node accepted by the matcher, a nil matcher
+// accepts all events. Returns the listener ID used to remove it.
+func (c *Cluster) RegisterListener(matcher eslgo.EventMatcher, listener Listener) string {
+	c.listenerLock.Lock()
+	defer c.listenerLock.Unlock()
+	c.listenerCounter++
+	id := fmt.Sprintf("%d", c.listenerCounter)
+	c.listeners[id] = registeredListener{matcher: matcher, listener: listener}
+	return id
+}
+
+// RemoveListener - Removes the listener with the listener ID returned from RegisterListener
+func (c *Cluster) RemoveListener(id string) {
+	c.listenerLock.Lock()
+	defer c.listenerLock.Unlock()
+	delete(c.listeners, id)
+}
+
+// Nodes - Returns the status of every node in the order they were configured
+func (c *Cluster) Nodes() []NodeStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	statuses := make([]NodeStatus, len(c.nodes))
+	for i, n := range c.nodes {
+		statuses[i] = c.status(n)
+	}
+	return statuses
+}
+
+// Owner - Returns the name of the node that owns the channel, learnt from its events
+func (c *Cluster) Owner(uuid string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	n, ok := c.channels[uuid]
+	if !ok {
+		return "", false
+	}
+	return n.config.Name, true
+}
+
+// Conn - Returns the connection to the node, fails while the node is disconnected
+func (c *Cluster) Conn(name string) (*eslgo.Conn, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	n, ok := c.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("cluster: unknown node %s", name)
+	}
+	if n.conn == nil {
+		return nil, fmt.Errorf("cluster: node %s is not connected", name)
+	}
+	return n.conn, nil
+}
+
+// ConnFor - Returns the connection to the node that owns the channel, use it for commands and helpers scoped to the channel
+func (c *Cluster) ConnFor(uuid string) (*eslgo.Conn, error) {
+	name, ok := c.Owner(uuid)
+	if !ok {
+		return nil, ErrUnknownChannel
+	}
+	return c.Conn(name)
+}
+
+// SendCommand - Sends the command to the node that owns the channel
+func (c *Cluster) SendCommand(ctx context.Context, uuid string, cmd command.Command) (*eslgo.RawResponse, error) {
+	conn, err := c.ConnFor(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return conn.SendCommand(ctx, cmd)
+}
+
+// Pick - Returns a healthy node chosen by the policy and its connection
+func (c *Cluster) Pick() (string, *eslgo.Conn, error) {
+	return c.pick(nil)
+}
+
+// Originate - Originates the call on a node chosen by the policy, see eslgo.Conn.OriginateCall. When the command cannot
+// be sent the node is marked unhealthy and the call is placed on another node. Returns the node the call was placed on.
+// The channel is routed to the node right away when the aLeg sets origination_uuid or the originate reply contains it.
+func (c *Cluster) Originate(ctx context.Context, background bool, aLeg, bLeg eslgo.Leg, vars map[string]string) (string, *eslgo.RawResponse, error) {
+	tried := make(map[string]bool)
+	for {
+		name, conn, err := c.pick(tried)
+		if err != nil {
+			return "", nil, err
+		}
+		if uuid := aLeg.LegVariables["origination_uuid"]; uuid != "" {
+			c.setOwner(uuid, name)
+		}
+		response, err := conn.OriginateCall(ctx, background, aLeg, bLeg, vars)
+		if err != nil {
+			if ctx.Err() != nil {
+				return name, nil, err
+			}
+			tried[name] = true
+			c.markUnhealthy(name, err)
+			continue
+		}
+		if reply := strings.TrimSpace(string(response.Body)); !background && strings.HasPrefix(reply, "+OK ") {
+			c.setOwner(strings.TrimPrefix(reply, "+OK "), name)
+		}
+		return name, response, nil
+	}
+}
+
+func (c *Cluster) pick(exclude map[string]bool) (string, *eslgo.Conn, error) {
+	c.lock.RLock()
+	var candidates []NodeStatus
+	conns := make(map[string]*eslgo.Conn)
+	for _, n := range c.nodes {
+		if n.healthy && !exclude[n.config.Name] {
+			candidates = append(candidates, c.status(n))
+			conns[n.config.Name] = n.conn
+		}
+	}
+	c.lock.RUnlock()
+	if len(candidates) == 0 {
+		return "", nil, ErrNoHealthyNode
+	}
+
+	c.pickLock.Lock()
+	name := c.opts.Policy.Pick(candidates)
+	c.pickLock.Unlock()
+	conn, ok := conns[name]
+	if !ok {
+		return "", nil, fmt.Errorf("cluster: policy picked unknown node %q", name)
+	}
+	return name, conn, nil
+}
+
+func (c *Cluster) setOwner(uuid, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if n, ok := c.byName[name]; ok {
+		c.channels[uuid] = n
+	}
+}
+
+func (c *Cluster) markUnhealthy(name string, err error) {
+	c.lock.Lock()
+	n := c.byName[name]
+	changed := n.healthy
+	n.healthy = false
+	n.lastErr = err
+	c.lock.Unlock()
+	if changed {
+		c.notify(n)
+	}
+}
+
+// Calls the listeners accepting the event
+func (c *Cluster) dispatch(event NodeEvent) {
+	c.listenerLock.RLock()
+	defer c.listenerLock.RUnlock()
+	for _, registered := range c.listeners {
+		if registered.matcher == nil || registered.matcher.Match(event.Event) {
+			registered.listener(event)
+		}
+	}
+}
+
+func (c *Cluster) notify(n *node) {
+	if c.opts.OnNodeChange == nil {
+		return
+	}
+	c.lock.RLock()
+	status := c.status(n)
+	c.lock.RUnlock()
+	c.opts.OnNodeChange(status)
+}
+
+// Caller must hold the lock
+func (c *Cluster) status(n *node) NodeStatus {
+	channels := 0
+	for _, owner := range c.channels {
+		if owner == n {
+			channels++
+		}
+	}
+	return NodeStatus{
+		Name:          n.config.Name,
+		Address:       n.config.Address,
+		Weight:        n.config.Weight,
+		Connected:     n.conn != nil,
+		Healthy:       n.healthy,
+		Sessions:      n.sessions,
+		Channels:      channels,
+		LastHeartbeat: n.heartbeat,
+		LastError:     n.lastErr,
+	}
+}
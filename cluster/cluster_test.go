@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cluster
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reconnectObserver struct {
+	eslgo.NilObserver
+	reconnects int32
+}
+
+func (o *reconnectObserver) Reconnected() {
+	atomic.AddInt32(&o.reconnects, 1)
+}
+
+// Starts a fake FreeSWITCH node with the channels listed by show channels
+func newNode(t *testing.T, channels string) *esltest.Server {
+	server, err := esltest.NewServer("ClueCon")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	server.HandleAPI("show", func(args string) string {
+		return channels
+	})
+	server.HandleAPI("originate", func(args string) string {
+		return "+OK new-call\n"
+	})
+	return server
+}
+
+func testOptions(servers ...*esltest.Server) Options {
+	opts := DefaultOptions
+	opts.Inbound.Logger = eslgo.NilLogger{}
+	opts.ReconnectDelay = 10 * time.Millisecond
+	opts.HealthInterval = 50 * time.Millisecond
+	for i, server := range servers {
+		opts.Nodes = append(opts.Nodes, NodeConfig{Name: string(rune('a' + i)), Address: server.Addr()})
+	}
+	return opts
+}
+
+func TestCluster(t *testing.T) {
+	serverA := newNode(t, `{"row_count":1,"rows":[{"uuid":"existing","direction":"inbound"}]}`)
+	serverB := newNode(t, `{"row_count":0}`)
+	opts := testOptions(serverA, serverB)
+	opts.Events = []string{"CHANNEL_ANSWER"}
+	opts.Policy = LeastSessions()
+	opts.ReconnectDelay = 200 * time.Millisecond
+	observer := &reconnectObserver{}
+	opts.Inbound.Observer = observer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cluster, err := Dial(ctx, opts)
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	var lock sync.Mutex
+	var received []NodeEvent
+	cluster.RegisterListener(eslgo.MatchEventName("CHANNEL_ANSWER"), func(event NodeEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, event)
+	})
+
+	// Channels that existed before the cluster connected are loaded from show channels
+	owner, ok := cluster.Owner("existing")
+	assert.True(t, ok)
+	assert.Equal(t, "a", owner)
+	nodes := cluster.Nodes()
+	require.Len(t, nodes, 2)
+	assert.True(t, nodes[0].Healthy)
+	assert.Equal(t, 1, nodes[0].Sessions)
+	assert.Equal(t, 1, nodes[0].Channels)
+	assert.True(t, nodes[1].Healthy)
+
+	// Channels are learnt from events and commands are routed to their node
+	serverB.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": "call-1"}})
+	serverB.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "call-1"}})
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, "b", received[0].Node)
+	assert.Equal(t, "call-1", received[0].GetHeader("Unique-ID"))
+	owner, _ = cluster.Owner("call-1")
+	assert.Equal(t, "b", owner)
+	_, err = cluster.SendCommand(ctx, "call-1", call.Hangup{UUID: "call-1", Cause: call.CauseNormalClearing})
+	require.NoError(t, err)
+	assert.Contains(t, serverB.Commands()[len(serverB.Commands())-1], "sendmsg call-1")
+	_, err = cluster.ConnFor("unknown")
+	assert.Equal(t, ErrUnknownChannel, err)
+
+	// Heartbeats correct the session counts used by LeastSessions
+	serverA.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "HEARTBEAT", "Session-Count": "0"}})
+	serverB.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "HEARTBEAT", "Session-Count": "3"}})
+	assert.Eventually(t, func() bool {
+		nodes := cluster.Nodes()
+		return nodes[0].Sessions == 0 && nodes[1].Sessions == 3
+	}, 5*time.Second, time.Millisecond)
+	node, response, err := cluster.Originate(ctx, false, eslgo.Leg{CallURL: "user/1000"}, eslgo.Leg{CallURL: "&park()"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a", node)
+	assert.Equal(t, "+OK new-call", string(response.Body[:len(response.Body)-1]))
+	owner, _ = cluster.Owner("new-call")
+	assert.Equal(t, "a", owner)
+
+	serverB.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_DESTROY", "Unique-ID": "call-1"}})
+	assert.Eventually(t, func() bool {
+		_, ok := cluster.Owner("call-1")
+		return !ok
+	}, 5*time.Second, time.Millisecond)
+
+	// A lost node is left out of new calls until it is reconnected
+	serverA.Disconnect()
+	assert.Eventually(t, func() bool {
+		return !cluster.Nodes()[0].Healthy
+	}, 5*time.Second, time.Millisecond)
+	node, _, err = cluster.Pick()
+	require.NoError(t, err)
+	assert.Equal(t, "b", node)
+	assert.Eventually(t, func() bool {
+		return cluster.Nodes()[0].Healthy
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&observer.reconnects))
+}
+
+func TestDial_Failover(t *testing.T) {
+	server := newNode(t, `{"row_count":0}`)
+	down := newNode(t, `{"row_count":0}`)
+	opts := testOptions(server, down)
+	require.NoError(t, down.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cluster, err := Dial(ctx, opts)
+	require.NoError(t, err)
+	defer cluster.Close()
+	nodes := cluster.Nodes()
+	assert.True(t, nodes[0].Healthy)
+	assert.False(t, nodes[1].Connected)
+	assert.Error(t, nodes[1].LastError)
+	for i := 0; i < 3; i++ {
+		node, _, err := cluster.Pick()
+		require.NoError(t, err)
+		assert.Equal(t, "a", node)
+	}
+
+	_, err = Dial(ctx, testOptions(down))
+	assert.Error(t, err)
+	_, err = Dial(ctx, Options{})
+	assert.EqualError(t, err, "cluster: no nodes")
+}
+
+func TestPolicies(t *testing.T) {
+	nodes := []NodeStatus{{Name: "a", Weight: 5, Sessions: 4}, {Name: "b", Weight: 1, Sessions: 2}, {Name: "c", Weight: 1, Sessions: 2}}
+	pick := func(policy Policy, times int) []string {
+		var names []string
+		for i := 0; i < times; i++ {
+			names = append(names, policy.Pick(nodes))
+		}
+		return names
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a"}, pick(RoundRobin(), 4))
+	assert.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a"}, pick(Weighted(), 7))
+	assert.Equal(t, []string{"b"}, pick(LeastSessions(), 1))
+}
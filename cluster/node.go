@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command"
+)
+
+// node - A node of the cluster, guarded by the cluster lock
+type node struct {
+	config    NodeConfig
+	conn      *eslgo.Conn // nil while disconnected
+	healthy   bool
+	sessions  int
+	heartbeat time.Time
+	lastErr   error
+	connected bool // Connected at least once, later connections are reconnects
+}
+
+// Keeps the node connected until the cluster is closed, the result of the first attempt is sent to first
+func (c *Cluster) run(n *node, first chan<- error) {
+	defer c.wait.Done()
+	for {
+		conn, events, err := c.connect(n)
+		if first != nil {
+			first <- err
+			first = nil
+		}
+		if err == nil {
+			c.serve(n, conn, events)
+		} else {
+			c.lock.Lock()
+			n.lastErr = err
+			c.lock.Unlock()
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(c.opts.ReconnectDelay):
+		}
+	}
+}
+
+// Dials the node, subscribes to its events and loads the channels it owns
+func (c *Cluster) connect(n *node) (*eslgo.Conn, <-chan *eslgo.Event, error) {
+	inbound := c.opts.Inbound
+	inbound.Context = c.ctx
+	if n.config.Password != "" {
+		inbound.Password = n.config.Password
+	}
+	conn, err := inbound.Dial(n.config.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.opts.HealthTimeout)
+	defer cancel()
+	sub := eslgo.EventSubscription{
+		Events:     append([]string{"CHANNEL_CREATE", "CHANNEL_DESTROY", "HEARTBEAT"}, c.opts.Events...),
+		Subclasses: c.opts.Subclasses,
+	}
+	// The channel is kept until the connection closes, so the subscription is never removed
+	events, stop, err := conn.SubscribeEvents(ctx, sub, eslgo.SubscribeOptions{Buffer: 1024})
+	if err == nil {
+		var channels []string
+		if channels, err = listChannels(ctx, conn); err == nil {
+			c.attach(n, conn, channels)
+			return conn, events, nil
+		}
+		stop()
+	}
+	conn.Close()
+	return nil, nil, err
+}
+
+// Marks the node connected and replaces the channels it owns
+func (c *Cluster) attach(n *node, conn *eslgo.Conn, channels []string) {
+	c.lock.Lock()
+	reconnected := n.connected
+	n.conn = conn
+	n.connected = true
+	n.healthy = true
+	n.lastErr = nil
+	n.sessions = len(channels)
+	for uuid, owner := range c.channels {
+		if owner == n {
+			delete(c.channels, uuid)
+		}
+	}
+	for _, uuid := range channels {
+		c.channels[uuid] = n
+	}
+	c.lock.Unlock()
+
+	if reconnected && c.opts.Inbound.Observer != nil {
+		c.opts.Inbound.Observer.Reconnected()
+	}
+	c.notify(n)
+}
+
+// Handles the events of the node until its connection is lost
+func (c *Cluster) serve(n *node, conn *eslgo.Conn, events <-chan *eslgo.Event) {
+	done := make(chan struct{})
+	c.wait.Add(1)
+	go c.checkHealth(n, conn, done)
+	// The channel is closed once the connection is closed
+	for event := range events {
+		c.handleEvent(n, event)
+	}
+	close(done)
+	conn.Close()
+
+	c.lock.Lock()
+	n.conn = nil
+	n.healthy = false
+	c.lock.Unlock()
+	c.notify(n)
+}
+
+// Sends the status api to the node every HealthInterval, the connection is closed when it is not answered so it is dialed again
+func (c *Cluster) checkHealth(n *node, conn *eslgo.Conn, done <-chan struct{}) {
+	defer c.wait.Done()
+	ticker := time.NewTicker(c.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(c.ctx, c.opts.HealthTimeout)
+		_, err := conn.SendCommand(ctx, command.API{Command: "status"})
+		cancel()
+		if err != nil {
+			c.markUnhealthy(n.config.Name, err)
+			conn.Close()
+			return
+		}
+
+		c.lock.Lock()
+		changed := !n.healthy
+		n.healthy = true
+		c.lock.Unlock()
+		if changed {
+			c.notify(n)
+		}
+	}
+}
+
+// Learns channel ownership and session counts from the event and passes it to the listeners
+func (c *Cluster) handleEvent(n *node, event *eslgo.Event) {
+	name := event.GetName()
+	uuid := event.GetHeader("Unique-ID")
+	c.lock.Lock()
+	switch {
+	case name == "HEARTBEAT":
+		if count, err := strconv.Atoi(event.GetHeader("Session-Count")); err == nil {
+			n.sessions = count
+		}
+		n.heartbeat = time.Now()
+	case name == "CHANNEL_CREATE":
+		n.sessions++
+		if uuid != "" {
+			c.channels[uuid] = n
+		}
+	case name == "CHANNEL_DESTROY":
+		if n.sessions > 0 {
+			n.sessions--
+		}
+		if c.channels[uuid] == n {
+			delete(c.channels, uuid)
+		}
+	case uuid != "" && strings.HasPrefix(name, "CHANNEL_"):
+		// Channels created before the subscription was applied
+		if _, ok := c.channels[uuid]; !ok {
+			c.channels[uuid] = n
+		}
+	}
+	c.lock.Unlock()
+
+	c.dispatch(NodeEvent{Node: n.config.Name, Event: event})
+}
+
+// Returns the UUIDs of the channels on the node from show channels
+func listChannels(ctx context.Context, conn *eslgo.Conn) ([]string, error) {
+	response, err := conn.SendCommand(ctx, command.API{Command: "show", Arguments: "channels as json"})
+	if err != nil {
+		return nil, err
+	}
+	body := strings.TrimSpace(string(response.Body))
+	if strings.HasPrefix(body, "-ERR") {
+		return nil, errors.New("cluster: show channels: " + body)
+	}
+	var result struct {
+		Rows []struct {
+			UUID string `json:"uuid"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		return nil, errors.New("cluster: show channels: " + err.Error())
+	}
+	channels := make([]string, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		channels = append(channels, row.UUID)
+	}
+	return channels, nil
+}
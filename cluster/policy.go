@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package cluster
+
+// Policy - Picks the node new calls are originated on. Pick is called one at a time with the healthy nodes in the
+// order they were configured, there is always at least one, and returns the name of the chosen node.
+type Policy interface {
+	Pick(nodes []NodeStatus) string
+}
+
+// PolicyFunc - Allows a function to be used as a Policy
+type PolicyFunc func(nodes []NodeStatus) string
+
+// Pick - Calls the function
+func (f PolicyFunc) Pick(nodes []NodeStatus) string {
+	return f(nodes)
+}
+
+// RoundRobin - Returns a policy cycling through the healthy nodes
+func RoundRobin() Policy {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next int
+}
+
+func (p *roundRobin) Pick(nodes []NodeStatus) string {
+	node := nodes[p.next%len(nodes)]
+	p.next++
+	return node.Name
+}
+
+// LeastSessions - Returns a policy picking the node with the fewest sessions, the first configured node wins ties.
+// Session counts come from the Session-Count of HEARTBEAT events and are kept current between heartbeats from the
+// CHANNEL_CREATE and CHANNEL_DESTROY events.
+func LeastSessions() Policy {
+	return PolicyFunc(func(nodes []NodeStatus) string {
+		least := nodes[0]
+		for _, node := range nodes[1:] {
+			if node.Sessions < least.Sessions {
+				least = node
+			}
+		}
+		return least.Name
+	})
+}
+
+// Weighted - Returns a policy spreading calls in proportion to the node weights with smooth weighted round robin,
+// e.g. weights 5, 1 and 1 pick the nodes in the order a a b a c a a
+func Weighted() Policy {
+	return &weighted{current: make(map[string]int)}
+}
+
+type weighted struct {
+	current map[string]int
+}
+
+func (p *weighted) Pick(nodes []NodeStatus) string {
+	total := 0
+	best := ""
+	for _, node := range nodes {
+		p.current[node.Name] += node.Weight
+		total += node.Weight
+		if best == "" || p.current[node.Name] > p.current[best] {
+			best = node.Name
+		}
+	}
+	p.current[best] -= total
+	return best
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+	formatPlain = "plain"
+)
+
+// defaultCSVHeaders - The CSV columns used when -headers is not set
+var defaultCSVHeaders = []string{"Event-Date-Timestamp", "Event-Name", "Event-Subclass", "Unique-ID", "Channel-State", "Caller-Caller-ID-Number", "Caller-Destination-Number", "Hangup-Cause"}
+
+// eventEncoder - Encodes events as records of an output format
+type eventEncoder interface {
+	// Header - Returns the data written at the start of every output file, nil for formats without one
+	Header() []byte
+	// Encode - Returns a single record for the event, including its line terminator
+	Encode(event *eslgo.Event) ([]byte, error)
+}
+
+func newEncoder(format string, headers []string) (eventEncoder, error) {
+	switch format {
+	case formatJSONL:
+		return jsonlEncoder{}, nil
+	case formatCSV:
+		return csvEncoder{headers: headers}, nil
+	case formatPlain:
+		return plainEncoder{}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// jsonlEncoder - One JSON object per line encoded with Event.EncodeJSON, the layout of FreeSWITCH json events
+type jsonlEncoder struct{}
+
+func (jsonlEncoder) Header() []byte {
+	return nil
+}
+
+func (jsonlEncoder) Encode(event *eslgo.Event) ([]byte, error) {
+	data, err := event.EncodeJSON()
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// csvEncoder - One CSV row per event with the selected headers as columns, missing headers are empty
+type csvEncoder struct {
+	headers []string
+}
+
+func (e csvEncoder) Header() []byte {
+	return e.row(e.headers)
+}
+
+func (e csvEncoder) Encode(event *eslgo.Event) ([]byte, error) {
+	values := make([]string, len(e.headers))
+	for i, header := range e.headers {
+		values[i] = event.GetHeader(header)
+	}
+	return e.row(values), nil
+}
+
+func (e csvEncoder) row(values []string) []byte {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	_ = writer.Write(values)
+	writer.Flush()
+	return buffer.Bytes()
+}
+
+// plainEncoder - The text/event-plain format as received from FreeSWITCH, events are separated by a blank line
+type plainEncoder struct{}
+
+func (plainEncoder) Header() []byte {
+	return nil
+}
+
+func (plainEncoder) Encode(event *eslgo.Event) ([]byte, error) {
+	keys := make([]string, 0, len(event.Headers))
+	for key := range event.Headers {
+		if key != "Event-Name" && key != "Content-Length" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if event.HasHeader("Event-Name") {
+		keys = append([]string{"Event-Name"}, keys...)
+	}
+
+	var buffer bytes.Buffer
+	for _, key := range keys {
+		for _, value := range event.Headers[key] {
+			// Header values are kept URL encoded as they were on the wire
+			buffer.WriteString(key + ": " + value + "\n")
+		}
+	}
+	if len(event.Body) > 0 {
+		buffer.WriteString("Content-Length: " + strconv.Itoa(len(event.Body)) + "\n\n")
+		buffer.Write(event.Body)
+		buffer.WriteString("\n")
+	}
+	buffer.WriteString("\n")
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Command esl-events captures events from FreeSWITCH and writes them as JSON Lines, CSV or plain text for later analysis.
+//
+// Only the requested events and channels are subscribed to on the switch, output goes to stdout or to files rotated by size or age.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+func main() {
+	ctx, stop := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop()
+	}()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// stringList - A flag that can be repeated or hold comma separated values, e.g. -uuid a -uuid b,c
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	for _, item := range strings.FieldsFunc(value, isSeparator) {
+		*s = append(*s, item)
+	}
+	return nil
+}
+
+func isSeparator(r rune) bool {
+	return r == ',' || r == ' '
+}
+
+// Captures events until the context ends, the count or duration is reached or the connection closes. Returns the process exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("esl-events", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	host := flags.String("H", "127.0.0.1", "FreeSWITCH host")
+	port := flags.Int("P", 8021, "FreeSWITCH event socket port")
+	password := flags.String("p", "ClueCon", "Event socket password")
+	format := flags.String("format", formatJSONL, "Output format: jsonl, csv or plain")
+	output := flags.String("o", "-", "Output file, - writes to stdout")
+	rotateSize := flags.String("rotate-size", "", "Rotate the output file once it reaches this size, e.g. 100MB")
+	rotateInterval := flags.Duration("rotate-interval", 0, "Rotate the output file after this long, e.g. 1h")
+	count := flags.Int("count", 0, "Stop after this many events, 0 for no limit")
+	duration := flags.Duration("duration", 0, "Stop after this long, 0 for no limit")
+	var events, uuids, headers stringList
+	flags.Var(&events, "events", "Event names to capture, CUSTOM subclasses such as sofia::register are accepted too. All events when empty")
+	flags.Var(&uuids, "uuid", "Only capture events for these channel UUIDs, can be repeated")
+	flags.Var(&headers, "headers", "Headers written as CSV columns (default "+strings.Join(defaultCSVHeaders, ",")+")")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if len(headers) == 0 {
+		headers = defaultCSVHeaders
+	}
+	encoder, err := newEncoder(*format, headers)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	maxSize, err := parseSize(*rotateSize)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	var out *eventOutput
+	if *output == "-" {
+		out = newStreamOutput(stdout, encoder)
+	} else {
+		out = newFileOutput(*output, maxSize, *rotateInterval, encoder)
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+	}()
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Password = *password
+	opts.Logger = eslgo.NilLogger{}
+	opts.OnDisconnect = cancel
+	address := net.JoinHostPort(*host, strconv.Itoa(*port))
+	conn, err := opts.Dial(address)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to connect to %s: %v\n", address, err)
+		return 1
+	}
+	defer conn.ExitAndClose()
+
+	written, err := capture(ctx, conn, subscriptionFor(events, uuids), out, *count)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "Captured %d events\n", written)
+	return 0
+}
+
+// Builds the subscription for the requested events, names containing :: are CUSTOM subclasses
+func subscriptionFor(events, uuids []string) eslgo.EventSubscription {
+	sub := eslgo.EventSubscription{UniqueIDs: uuids}
+	for _, name := range events {
+		switch {
+		case strings.Contains(name, "::"):
+			sub.Subclasses = append(sub.Subclasses, name)
+		case strings.EqualFold(name, "ALL"):
+			return eslgo.EventSubscription{UniqueIDs: uuids}
+		default:
+			sub.Events = append(sub.Events, strings.ToUpper(name))
+		}
+	}
+	return sub
+}
+
+// Subscribes to the events and writes them to the output until the context ends or count events were written
+func capture(ctx context.Context, conn *eslgo.Conn, sub eslgo.EventSubscription, out *eventOutput, count int) (int, error) {
+	events, stop, err := conn.SubscribeEvents(ctx, sub, eslgo.SubscribeOptions{Buffer: 8192})
+	if err != nil {
+		return 0, fmt.Errorf("failed to subscribe: %w", err)
+	}
+	defer stop()
+
+	written := 0
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return written, nil
+			}
+			if err := out.WriteEvent(event); err != nil {
+				return written, err
+			}
+			written++
+			if count > 0 && written >= count {
+				return written, nil
+			}
+		case <-ctx.Done():
+			return written, nil
+		}
+	}
+}
+
+// Parses a size such as 512, 64KB, 100MB or 1GB into bytes, empty is 0
+func parseSize(size string) (int64, error) {
+	original := size
+	size = strings.ToUpper(strings.TrimSpace(size))
+	if size == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			multiplier = unit.multiplier
+			size = strings.TrimSuffix(size, unit.suffix)
+			break
+		}
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", original)
+	}
+	return value * multiplier, nil
+}
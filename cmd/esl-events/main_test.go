@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer - A buffer that can be read while events are written to it
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+func startCapture(t *testing.T, args ...string) (*esltest.Server, *syncBuffer, *syncBuffer, chan int) {
+	server, err := esltest.NewServer("ClueCon")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.NoError(t, err)
+
+	var stdout, stderr syncBuffer
+	code := make(chan int, 1)
+	go func() {
+		code <- run(context.Background(), append([]string{"-H", host, "-P", port}, args...), &stdout, &stderr)
+	}()
+	return server, &stdout, &stderr, code
+}
+
+func waitForCommand(t *testing.T, server *esltest.Server, command string) {
+	assert.Eventually(t, func() bool {
+		for _, received := range server.Commands() {
+			if received == command {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestRun_CSV(t *testing.T) {
+	server, stdout, stderr, code := startCapture(t, "-format", "csv", "-events", "CHANNEL_CREATE,sofia::register", "-uuid", "abc", "-headers", "Event-Name,Unique-ID,Caller-Caller-ID-Name", "-count", "2")
+	waitForCommand(t, server, "filter Unique-ID abc")
+
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": "other"}})
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "abc"}})
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": "abc", "Caller-Caller-ID-Name": "Doe, Jane"}})
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CUSTOM", "Event-Subclass": "sofia::register", "Unique-ID": "abc"}})
+
+	select {
+	case result := <-code:
+		assert.Equal(t, 0, result, stderr.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture did not stop after the count")
+	}
+	assert.Equal(t, "Event-Name,Unique-ID,Caller-Caller-ID-Name\nCHANNEL_CREATE,abc,\"Doe, Jane\"\nCUSTOM,abc,\n", stdout.String())
+	assert.Contains(t, stderr.String(), "Captured 2 events")
+	assert.Contains(t, server.Commands(), "event plain CHANNEL_CREATE CUSTOM sofia::register")
+}
+
+func TestRun_Duration(t *testing.T) {
+	_, stdout, stderr, code := startCapture(t, "-duration", "50ms")
+	select {
+	case result := <-code:
+		assert.Equal(t, 0, result, stderr.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture did not stop after the duration")
+	}
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "Captured 0 events")
+}
+
+func TestRun_InvalidFlags(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(context.Background(), []string{"-format", "xml"}, &stdout, &stderr))
+	assert.Equal(t, 2, run(context.Background(), []string{"-rotate-size", "lots"}, &stdout, &stderr))
+}
+
+func testEvent() *eslgo.Event {
+	return &eslgo.Event{
+		Headers: textproto.MIMEHeader{
+			"Event-Name":     {"CUSTOM"},
+			"Event-Subclass": {"test%3A%3Aevent"},
+			"Unique-Id":      {"abc"},
+		},
+		Body: []byte("hello"),
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	encoder, err := newEncoder(formatJSONL, nil)
+	require.NoError(t, err)
+	record, err := encoder.Encode(testEvent())
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Content-Length":"5","Event-Name":"CUSTOM","Event-Subclass":"test::event","Unique-Id":"abc","_body":"hello"}`+"\n", string(record))
+	assert.Nil(t, encoder.Header())
+
+	encoder, err = newEncoder(formatPlain, nil)
+	require.NoError(t, err)
+	record, err = encoder.Encode(testEvent())
+	assert.NoError(t, err)
+	assert.Equal(t, "Event-Name: CUSTOM\nEvent-Subclass: test%3A%3Aevent\nUnique-Id: abc\nContent-Length: 5\n\nhello\n\n", string(record))
+}
+
+func TestEventOutput_Rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esl-events")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.csv")
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	encoder, err := newEncoder(formatCSV, []string{"Unique-ID"})
+	require.NoError(t, err)
+	out := newFileOutput(path, 16, time.Hour, encoder)
+	out.now = func() time.Time { return now }
+
+	// "Unique-ID\n" is 10 bytes and each row 4, so the size limit allows a single row per file
+	for i := 0; i < 2; i++ {
+		require.NoError(t, out.WriteEvent(testEvent()))
+		now = now.Add(time.Second)
+	}
+	// The age limit rotates too
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, out.WriteEvent(testEvent()))
+	require.NoError(t, out.Close())
+
+	files, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{path, path + ".20200101T000001.000000000", path + ".20200101T020002.000000000"}, files)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, "Unique-ID\nabc\n", string(data))
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for input, expected := range map[string]int64{"": 0, "512": 512, "64KB": 64 << 10, "100mb": 100 << 20, "1G": 1 << 30} {
+		size, err := parseSize(input)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, size, input)
+	}
+	_, err := parseSize("-1MB")
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "-1MB"))
+}
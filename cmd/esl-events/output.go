@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+// rotationTimeFormat - The timestamp appended to rotated file names, sorts in rotation order
+const rotationTimeFormat = "20060102T150405.000000000"
+
+// eventOutput - Writes encoded events to a stream or to a file rotated by size and age. Rotated files are renamed
+// to the output path with the rotation time appended and every new file starts with the encoder header.
+type eventOutput struct {
+	encoder  eventEncoder
+	writer   io.Writer
+	path     string
+	maxSize  int64
+	interval time.Duration
+	file     *os.File
+	size     int64
+	opened   time.Time
+	now      func() time.Time
+}
+
+// Writes to an already open stream such as stdout, the encoder header is written before the first event
+func newStreamOutput(writer io.Writer, encoder eventEncoder) *eventOutput {
+	return &eventOutput{encoder: encoder, writer: writer, now: time.Now}
+}
+
+// Writes to the file at path, rotating it once it reaches maxSize bytes or is older than interval. Zero disables either limit.
+func newFileOutput(path string, maxSize int64, interval time.Duration, encoder eventEncoder) *eventOutput {
+	return &eventOutput{encoder: encoder, path: path, maxSize: maxSize, interval: interval, now: time.Now}
+}
+
+// WriteEvent - Encodes the event and writes it, rotating the file first if needed
+func (o *eventOutput) WriteEvent(event *eslgo.Event) error {
+	record, err := o.encoder.Encode(event)
+	if err != nil {
+		return err
+	}
+	if o.path == "" {
+		if o.opened.IsZero() {
+			o.opened = o.now()
+			if err := o.write(o.encoder.Header()); err != nil {
+				return err
+			}
+		}
+		return o.write(record)
+	}
+
+	if o.file != nil && o.shouldRotate(len(record)) {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+	}
+	if o.file == nil {
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+	return o.write(record)
+}
+
+// Close - Closes the current output file, streams are left open
+func (o *eventOutput) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+func (o *eventOutput) shouldRotate(next int) bool {
+	// Files always take at least one event so oversized events cannot cause endless rotation
+	if o.maxSize > 0 && o.size > int64(len(o.encoder.Header())) && o.size+int64(next) > o.maxSize {
+		return true
+	}
+	return o.interval > 0 && o.now().Sub(o.opened) >= o.interval
+}
+
+func (o *eventOutput) rotate() error {
+	if err := o.Close(); err != nil {
+		return err
+	}
+	return os.Rename(o.path, o.path+"."+o.now().UTC().Format(rotationTimeFormat))
+}
+
+func (o *eventOutput) open() error {
+	// Existing captures are appended to, the header is only written to new files
+	file, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	o.file = file
+	o.writer = file
+	o.size = info.Size()
+	o.opened = o.now()
+	if o.size > 0 {
+		return nil
+	}
+	return o.write(o.encoder.Header())
+}
+
+func (o *eventOutput) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	n, err := o.writer.Write(data)
+	o.size += int64(n)
+	return err
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command"
+)
+
+const helpText = `Lines are run as api commands, e.g. "status" or "show channels".
+Client commands:
+  /api <command>                  Run an api command
+  /bgapi <command>                Run an api command in the background, the result is printed when the job completes
+  /event <names...>               Tail events, e.g. /event CHANNEL_CREATE CHANNEL_HANGUP or /event ALL
+  /nixevent <names...>            Stop tailing events
+  /noevents                       Stop tailing all events
+  /filter <header> <value>        Only receive events with the header value
+  /filter delete <header> [value] Remove event filters
+  /log [level]                    Stream logs at the level, console, alert, crit, err, warning, notice, info, debug or 0-7
+  /nolog                          Stop streaming logs
+  /format <plain|json|table>      Change the output format
+  /history                        Show the command history, !! repeats the last command and !N command N
+  /help                           Show this help
+  /quit, /exit, /bye              Disconnect and exit`
+
+// logLevels - The FreeSWITCH log level names accepted by /log
+var logLevels = map[string]int{
+	"console": 0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"error":   3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// client - Runs client commands on an inbound connection and prints responses, events, logs and background job results
+type client struct {
+	conn    *eslgo.Conn
+	printer *printer
+	timeout time.Duration
+	history *history
+
+	jobLock         sync.Mutex
+	jobs            map[string]bool
+	jobsDone        sync.WaitGroup
+	jobsSubscribed  bool
+	stopEvents      func()
+	logListenerID   string
+	eventPumpClosed chan struct{}
+}
+
+func newClient(ctx context.Context, conn *eslgo.Conn, printer *printer, timeout time.Duration) *client {
+	c := &client{
+		conn:            conn,
+		printer:         printer,
+		timeout:         timeout,
+		history:         &history{max: maxHistory},
+		jobs:            make(map[string]bool),
+		eventPumpClosed: make(chan struct{}),
+	}
+	events, stop := conn.SubscribeWithOptions(ctx, nil, eslgo.SubscribeOptions{Buffer: 4096})
+	c.stopEvents = stop
+	go c.eventPump(events)
+	c.logListenerID = conn.RegisterLogListener(printer.logLine)
+	return c
+}
+
+// Prints events in the order they were received, results of our background jobs are printed as job results
+func (c *client) eventPump(events <-chan *eslgo.Event) {
+	defer close(c.eventPumpClosed)
+	for event := range events {
+		if event.GetName() == "BACKGROUND_JOB" {
+			jobUUID := event.GetHeader("Job-UUID")
+			c.jobLock.Lock()
+			ours := c.jobs[jobUUID]
+			delete(c.jobs, jobUUID)
+			c.jobLock.Unlock()
+			if ours {
+				c.printer.job(jobUUID, event)
+				c.jobsDone.Done()
+				continue
+			}
+		}
+		c.printer.event(event)
+	}
+}
+
+// Waits until the results of every background job started were printed or the timeout expires
+func (c *client) waitJobs(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		c.jobsDone.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (c *client) close() {
+	c.conn.RemoveLogListener(c.logListenerID)
+	c.conn.ExitAndClose()
+	c.stopEvents()
+	<-c.eventPumpClosed
+}
+
+// Runs a single line, either an api command or a /command. Returns true if the client should quit.
+func (c *client) execute(ctx context.Context, line string) (bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false, nil
+	}
+	if !strings.HasPrefix(line, "/") {
+		return false, c.api(ctx, line, false)
+	}
+
+	name, args := splitWord(line[1:])
+	switch name {
+	case "quit", "exit", "bye":
+		return true, nil
+	case "help":
+		c.printer.text(helpText)
+	case "history":
+		for i, entry := range c.history.list() {
+			c.printer.text(fmt.Sprintf("%4d  %s", i+1, entry))
+		}
+	case "api":
+		return false, c.api(ctx, args, false)
+	case "bgapi":
+		return false, c.api(ctx, args, true)
+	case "event":
+		if args == "" {
+			return false, errors.New("usage: /event <names...>")
+		}
+		return false, c.send(ctx, command.Event{Format: "plain", Listen: strings.Fields(args)})
+	case "nixevent":
+		if args == "" {
+			return false, errors.New("usage: /nixevent <names...>")
+		}
+		return false, c.send(ctx, command.Event{Ignore: true, Format: "plain", Listen: strings.Fields(args)})
+	case "noevents":
+		return false, c.send(ctx, command.DisableEvents{})
+	case "filter":
+		filter, err := parseFilter(args)
+		if err != nil {
+			return false, err
+		}
+		return false, c.send(ctx, filter)
+	case "log":
+		level, err := parseLogLevel(args)
+		if err != nil {
+			return false, err
+		}
+		return false, c.send(ctx, command.Log{Enabled: true, Level: level})
+	case "nolog":
+		return false, c.send(ctx, command.Log{Enabled: false})
+	case "format":
+		if !validFormat(args) {
+			return false, errors.New("usage: /format <plain|json|table>")
+		}
+		c.printer.setFormat(args)
+	default:
+		return false, fmt.Errorf("unknown command /%s, see /help", name)
+	}
+	return false, nil
+}
+
+// Runs an api or bgapi command and prints the response, api responses starting with -ERR are reported as errors
+func (c *client) api(ctx context.Context, line string, background bool) error {
+	cmd, args := splitWord(line)
+	if cmd == "" {
+		return errors.New("usage: /api <command>")
+	}
+	if background {
+		// Background job results are delivered as events so make sure we receive them before starting the job
+		if err := c.subscribeJobs(ctx); err != nil {
+			return err
+		}
+		c.jobsDone.Add(1)
+		// Hold the job lock until the job is registered, the event pump waits so a fast job result is not mistaken for someone else's
+		c.jobLock.Lock()
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	response, err := c.conn.SendCommand(sendCtx, command.API{Command: cmd, Arguments: args, Background: background})
+	if background {
+		jobUUID := ""
+		if err == nil && response.IsOk() {
+			jobUUID = response.GetHeader("Job-UUID")
+		}
+		if jobUUID != "" {
+			c.jobs[jobUUID] = true
+		} else {
+			c.jobsDone.Done()
+		}
+		c.jobLock.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	c.printer.response(response)
+	if strings.HasPrefix(response.GetReply(), "-ERR") {
+		return fmt.Errorf("%s failed", cmd)
+	}
+	return nil
+}
+
+func (c *client) subscribeJobs(ctx context.Context) error {
+	c.jobLock.Lock()
+	defer c.jobLock.Unlock()
+	if c.jobsSubscribed {
+		return nil
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	response, err := c.conn.SendCommand(sendCtx, command.Event{Format: "plain", Listen: []string{"BACKGROUND_JOB"}})
+	if err != nil {
+		return err
+	}
+	if !response.IsOk() {
+		return errors.New(response.GetReply())
+	}
+	c.jobsSubscribed = true
+	return nil
+}
+
+// Sends a control command and prints the reply, replies that are not +OK are returned as errors
+func (c *client) send(ctx context.Context, cmd command.Command) error {
+	sendCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	response, err := c.conn.SendCommand(sendCtx, cmd)
+	if err != nil {
+		return err
+	}
+	if !response.IsOk() {
+		return errors.New(response.GetReply())
+	}
+	c.printer.response(response)
+	return nil
+}
+
+func parseFilter(args string) (command.Filter, error) {
+	fields := strings.Fields(args)
+	if len(fields) >= 2 && fields[0] == "delete" {
+		return command.Filter{Delete: true, EventHeader: fields[1], FilterValue: strings.Join(fields[2:], " ")}, nil
+	}
+	if len(fields) < 2 {
+		return command.Filter{}, errors.New("usage: /filter <header> <value> or /filter delete <header> [value]")
+	}
+	return command.Filter{EventHeader: fields[0], FilterValue: strings.Join(fields[1:], " ")}, nil
+}
+
+// Parses a log level name or number, defaults to debug like fs_cli
+func parseLogLevel(args string) (int, error) {
+	args = strings.ToLower(strings.TrimSpace(args))
+	if args == "" {
+		return logLevels["debug"], nil
+	}
+	if level, ok := logLevels[args]; ok {
+		return level, nil
+	}
+	level, err := strconv.Atoi(args)
+	if err != nil || level < 0 || level > 7 {
+		return 0, fmt.Errorf("invalid log level %q", args)
+	}
+	return level, nil
+}
+
+// Splits the first word from the rest of the line
+func splitWord(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if index := strings.IndexAny(line, " \t"); index >= 0 {
+		return line[:index], strings.TrimSpace(line[index+1:])
+	}
+	return line, ""
+}
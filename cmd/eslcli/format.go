@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+const (
+	formatPlain = "plain"
+	formatJSON  = "json"
+	formatTable = "table"
+)
+
+func validFormat(format string) bool {
+	return format == formatPlain || format == formatJSON || format == formatTable
+}
+
+// printer - Writes responses, events and logs in the selected format. Safe to use from the event and log listeners.
+type printer struct {
+	lock   sync.Mutex
+	writer io.Writer
+	format string
+}
+
+// jsonRecord - A single line of JSON output
+type jsonRecord struct {
+	Type    string            `json:"type"`
+	Name    string            `json:"name,omitempty"`
+	Level   *int              `json:"level,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+func (p *printer) setFormat(format string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.format = format
+}
+
+func (p *printer) prompt(prompt string) {
+	if prompt == "" {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fmt.Fprint(p.writer, prompt)
+}
+
+func (p *printer) text(text string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fmt.Fprintln(p.writer, text)
+}
+
+// response - Prints a command reply or api response, plain prints just the reply or body
+func (p *printer) response(response *eslgo.RawResponse) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	headers := flattenHeaders(response.Headers, false)
+	body := string(response.Body)
+	switch p.format {
+	case formatJSON:
+		p.writeJSON(jsonRecord{Type: "response", Headers: headers, Body: body})
+	case formatTable:
+		if body != "" {
+			p.writeTable(body)
+		} else {
+			p.writeHeaders(headers, "")
+		}
+	default:
+		fmt.Fprintln(p.writer, strings.TrimRight(response.GetReply(), "\n"))
+	}
+}
+
+// event - Prints an event with its headers sorted by name
+func (p *printer) event(event *eslgo.Event) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	headers := flattenHeaders(event.Headers, true)
+	switch p.format {
+	case formatJSON:
+		p.writeJSON(jsonRecord{Type: "event", Name: event.GetName(), Headers: headers, Body: string(event.Body)})
+	case formatTable:
+		fmt.Fprintf(p.writer, "[EVENT %s]\n", event.GetName())
+		p.writeHeaders(headers, string(event.Body))
+	default:
+		fmt.Fprintf(p.writer, "[EVENT %s]\n", event.GetName())
+		for _, key := range sortedKeys(headers) {
+			fmt.Fprintf(p.writer, "%s: %s\n", key, headers[key])
+		}
+		if len(event.Body) > 0 {
+			fmt.Fprintf(p.writer, "\n%s\n", strings.TrimRight(string(event.Body), "\n"))
+		}
+		fmt.Fprintln(p.writer)
+	}
+}
+
+// job - Prints the result of a background job
+func (p *printer) job(jobUUID string, event *eslgo.Event) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	body := string(event.Body)
+	switch p.format {
+	case formatJSON:
+		p.writeJSON(jsonRecord{Type: "job", Headers: map[string]string{"Job-UUID": jobUUID, "Job-Command": event.GetHeader("Job-Command")}, Body: body})
+	case formatTable:
+		fmt.Fprintf(p.writer, "[JOB %s]\n", jobUUID)
+		p.writeTable(body)
+	default:
+		fmt.Fprintf(p.writer, "[JOB %s]\n%s\n", jobUUID, strings.TrimRight(body, "\n"))
+	}
+}
+
+// logLine - Prints a FreeSWITCH log line, registered as a log listener
+func (p *printer) logLine(line *eslgo.LogLine) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch p.format {
+	case formatJSON:
+		level := line.Level
+		headers := map[string]string{"File": line.File, "Function": line.Function, "Line": fmt.Sprint(line.Line)}
+		if line.UUID != "" {
+			headers["UUID"] = line.UUID
+		}
+		p.writeJSON(jsonRecord{Type: "log", Level: &level, Headers: headers, Body: line.Text})
+	default:
+		fmt.Fprintf(p.writer, "[LOG %s] %s\n", logLevelName(line.Level), strings.TrimRight(line.Text, "\n"))
+	}
+}
+
+func (p *printer) writeJSON(record jsonRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintln(p.writer, err)
+		return
+	}
+	fmt.Fprintln(p.writer, string(data))
+}
+
+// Writes headers as an aligned two column table followed by the body
+func (p *printer) writeHeaders(headers map[string]string, body string) {
+	writer := tabwriter.NewWriter(p.writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "HEADER\tVALUE")
+	for _, key := range sortedKeys(headers) {
+		fmt.Fprintf(writer, "%s\t%s\n", key, headers[key])
+	}
+	writer.Flush()
+	if body != "" {
+		fmt.Fprintf(p.writer, "\n%s\n", strings.TrimRight(body, "\n"))
+	}
+	fmt.Fprintln(p.writer)
+}
+
+// Writes comma separated api output such as "show channels" as aligned columns, anything else is written as is
+func (p *printer) writeTable(body string) {
+	rows, footer := parseDelimited(body)
+	if rows == nil {
+		fmt.Fprintln(p.writer, strings.TrimRight(body, "\n"))
+		return
+	}
+	writer := tabwriter.NewWriter(p.writer, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+	if footer != "" {
+		fmt.Fprintf(p.writer, "\n%s\n", footer)
+	}
+}
+
+// Parses output with a comma separated header line and rows with the same number of columns, like "show channels".
+// The "N total." footer is returned separately. Returns nil rows if the body is not in that format.
+func parseDelimited(body string) ([][]string, string) {
+	var lines []string
+	footer := ""
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if strings.HasSuffix(line, " total.") {
+			footer = line
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 || !strings.Contains(lines[0], ",") {
+		return nil, ""
+	}
+	columns := len(strings.Split(lines[0], ","))
+	rows := make([][]string, 0, len(lines))
+	for _, line := range lines {
+		row := strings.Split(line, ",")
+		if len(row) != columns {
+			return nil, ""
+		}
+		rows = append(rows, row)
+	}
+	return rows, footer
+}
+
+// Flattens MIME headers to single values, event header values are URL decoded
+func flattenHeaders(headers textproto.MIMEHeader, decode bool) map[string]string {
+	flat := make(map[string]string, len(headers))
+	for key := range headers {
+		if decode {
+			flat[key] = eslgo.Event{Headers: headers}.GetHeader(key)
+		} else {
+			flat[key] = headers.Get(key)
+		}
+	}
+	return flat
+}
+
+func sortedKeys(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func logLevelName(level int) string {
+	names := []string{"CONSOLE", "ALERT", "CRIT", "ERR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+	if level >= 0 && level < len(names) {
+		return names[level]
+	}
+	return fmt.Sprint(level)
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const maxHistory = 1000
+
+// history - The interactive command history, kept in a file between sessions when a path is set
+type history struct {
+	entries []string
+	path    string
+	max     int
+}
+
+// Loads the history kept in the file at path, a missing file starts an empty history
+func loadHistory(path string, max int) (*history, error) {
+	h := &history{path: path, max: max}
+	if path == "" {
+		return h, nil
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		h.path = ""
+		return h, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	h.trim()
+	return h, scanner.Err()
+}
+
+// Adds the line to the history and appends it to the history file, repeats of the last line are not added
+func (h *history) add(line string) {
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	h.trim()
+	if h.path == "" {
+		return
+	}
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, _ = fmt.Fprintln(file, line)
+}
+
+func (h *history) list() []string {
+	return h.entries
+}
+
+// Expands history references, !! is the last command and !N is command N as numbered by /history
+func (h *history) expand(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") || line == "!" {
+		return line, nil
+	}
+	if line == "!!" {
+		if len(h.entries) == 0 {
+			return "", fmt.Errorf("!!: event not found")
+		}
+		return h.entries[len(h.entries)-1], nil
+	}
+	index, err := strconv.Atoi(line[1:])
+	if err != nil || index < 1 || index > len(h.entries) {
+		return "", fmt.Errorf("%s: event not found", line)
+	}
+	return h.entries[index-1], nil
+}
+
+func (h *history) trim() {
+	if h.max > 0 && len(h.entries) > h.max {
+		h.entries = append([]string(nil), h.entries[len(h.entries)-h.max:]...)
+	}
+}
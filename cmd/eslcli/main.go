@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Command eslcli is an interactive FreeSWITCH event socket client similar to fs_cli.
+//
+// Lines typed at the prompt are run as api commands, lines starting with / are client commands, see /help.
+// Commands can also be run non-interactively with -x or read from a batch file with -f.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// stringList - A flag that can be repeated, e.g. -x status -x "show channels"
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Runs the client with the command line arguments and returns the process exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("eslcli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	host := flags.String("H", "127.0.0.1", "FreeSWITCH host")
+	port := flags.Int("P", 8021, "FreeSWITCH event socket port")
+	password := flags.String("p", "ClueCon", "Event socket password")
+	format := flags.String("format", formatPlain, "Output format: plain, json or table")
+	logLevel := flags.String("l", "", "Stream logs at this level, e.g. debug or 7")
+	events := flags.String("events", "", "Space separated events to tail, e.g. \"CHANNEL_CREATE CHANNEL_HANGUP\"")
+	batch := flags.String("f", "", "Run the commands in this file, - reads them from stdin")
+	wait := flags.Duration("wait", 0, "Keep printing events and logs this long after running -x or -f commands")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for each command")
+	exitOnError := flags.Bool("exit-on-error", false, "Stop running -x or -f commands at the first failure")
+	historyPath := flags.String("history", defaultHistoryPath(), "File the interactive command history is kept in, empty to disable")
+	var commands stringList
+	flags.Var(&commands, "x", "Run this command and exit, can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if !validFormat(*format) {
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Password = *password
+	opts.Logger = eslgo.NilLogger{}
+	opts.OnDisconnect = func() {
+		fmt.Fprintln(stderr, "Disconnected")
+		cancel()
+	}
+	address := net.JoinHostPort(*host, strconv.Itoa(*port))
+	conn, err := opts.Dial(address)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to connect to %s: %v\n", address, err)
+		return 1
+	}
+
+	cli := newClient(ctx, conn, &printer{writer: stdout, format: *format}, *timeout)
+	defer cli.close()
+
+	if *events != "" {
+		if _, err := cli.execute(ctx, "/event "+*events); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	if *logLevel != "" {
+		if _, err := cli.execute(ctx, "/log "+*logLevel); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	// Non-interactive: run the -x commands and then the batch file
+	if len(commands) > 0 || *batch != "" {
+		failed := false
+		for _, line := range commands {
+			quit, err := cli.execute(ctx, line)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				failed = true
+				if *exitOnError {
+					return 1
+				}
+			}
+			if quit {
+				return exitCode(failed)
+			}
+		}
+		if *batch != "" {
+			input := stdin
+			if *batch != "-" {
+				file, err := os.Open(*batch)
+				if err != nil {
+					fmt.Fprintln(stderr, err)
+					return 1
+				}
+				defer file.Close()
+				input = file
+			}
+			if !cli.runBatch(ctx, input, stderr, *exitOnError) {
+				failed = true
+				if *exitOnError {
+					return 1
+				}
+			}
+		}
+		cli.waitJobs(*timeout)
+		select {
+		case <-time.After(*wait):
+		case <-ctx.Done():
+		}
+		return exitCode(failed)
+	}
+
+	history, err := loadHistory(*historyPath, maxHistory)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to load history:", err)
+	}
+	cli.history = history
+	prompt := ""
+	if isTerminal(stdin) {
+		prompt = "freeswitch@" + address + "> "
+	}
+	cli.interactive(ctx, stdin, stderr, prompt)
+	return 0
+}
+
+// Runs every line of the input as a command, blank lines and lines starting with # are skipped. Returns false if any command failed.
+func (c *client) runBatch(ctx context.Context, input io.Reader, stderr io.Writer, exitOnError bool) bool {
+	ok := true
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		quit, err := c.execute(ctx, line)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			ok = false
+			if exitOnError {
+				return false
+			}
+		}
+		if quit {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return false
+	}
+	return ok
+}
+
+// Reads commands until the input ends, the connection closes or the user quits
+func (c *client) interactive(ctx context.Context, input io.Reader, stderr io.Writer, prompt string) {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(input)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		c.printer.prompt(prompt)
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			line, err := c.history.expand(strings.TrimSpace(line))
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				continue
+			}
+			if line == "" {
+				continue
+			}
+			c.history.add(line)
+			quit, err := c.execute(ctx, line)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+			}
+			if quit {
+				return
+			}
+		}
+	}
+}
+
+func exitCode(failed bool) int {
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".eslcli_history")
+}
+
+// Reports if the input is an interactive terminal, only then is the prompt printed
+func isTerminal(input io.Reader) bool {
+	file, ok := input.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer - A buffer that can be read while the client is writing to it
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+func startServer(t *testing.T) *esltest.Server {
+	server, err := esltest.NewServer("ClueCon")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	return server
+}
+
+func serverFlags(t *testing.T, server *esltest.Server) []string {
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.NoError(t, err)
+	return []string{"-H", host, "-P", port, "-history", ""}
+}
+
+func newTestClient(t *testing.T, server *esltest.Server, output *syncBuffer) *client {
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	cli := newClient(context.Background(), conn, &printer{writer: output, format: formatPlain}, time.Second)
+	t.Cleanup(cli.close)
+	return cli
+}
+
+func TestRun_Commands(t *testing.T) {
+	server := startServer(t)
+	var stdout, stderr syncBuffer
+	code := run(append(serverFlags(t, server), "-x", "status", "-x", "/bgapi echo hello job"), strings.NewReader(""), &stdout, &stderr)
+
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stdout.String(), "FreeSWITCH (Version 1.10.0) is ready")
+	assert.Contains(t, stdout.String(), "+OK Job-UUID: ")
+	assert.Contains(t, stdout.String(), "hello job")
+	assert.Contains(t, server.Commands(), "api status ")
+	assert.Contains(t, server.Commands(), "event plain BACKGROUND_JOB")
+}
+
+func TestRun_Batch(t *testing.T) {
+	server := startServer(t)
+	var stdout, stderr syncBuffer
+	batch := "# comment\n\necho one\nnosuch\necho two\n"
+	code := run(append(serverFlags(t, server), "-f", "-"), strings.NewReader(batch), &stdout, &stderr)
+
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "one\n-ERR nosuch Command not found!\ntwo\n", stdout.String())
+	assert.Contains(t, stderr.String(), "nosuch failed")
+
+	stdout = syncBuffer{}
+	code = run(append(serverFlags(t, server), "-exit-on-error", "-f", "-"), strings.NewReader(batch), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.NotContains(t, stdout.String(), "two")
+}
+
+func TestClient_TailEvents(t *testing.T) {
+	server := startServer(t)
+	var output syncBuffer
+	cli := newTestClient(t, server, &output)
+
+	_, err := cli.execute(context.Background(), "/event CHANNEL_CREATE")
+	require.NoError(t, err)
+	_, err = cli.execute(context.Background(), "/filter Unique-ID abc")
+	require.NoError(t, err)
+
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": "other"}})
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "abc"}})
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": "abc", "Caller-Caller-ID-Name": "Jane Doe"}})
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(output.String(), "Caller-Caller-Id-Name: Jane Doe")
+	}, time.Second, 10*time.Millisecond)
+	assert.NotContains(t, output.String(), "other")
+	assert.NotContains(t, output.String(), "CHANNEL_ANSWER")
+
+	_, err = cli.execute(context.Background(), "/nixevent CHANNEL_CREATE")
+	require.NoError(t, err)
+	_, err = cli.execute(context.Background(), "/filter")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"event plain CHANNEL_CREATE", "filter Unique-ID abc", "nixevent plain CHANNEL_CREATE"}, server.Commands()[1:])
+}
+
+func TestClient_Logs(t *testing.T) {
+	server := startServer(t)
+	var output syncBuffer
+	cli := newTestClient(t, server, &output)
+
+	_, err := cli.execute(context.Background(), "/log info")
+	require.NoError(t, err)
+	server.SendLog(7, "too verbose")
+	server.SendLog(4, "careful now")
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(output.String(), "[LOG WARNING] careful now")
+	}, time.Second, 10*time.Millisecond)
+	assert.NotContains(t, output.String(), "too verbose")
+
+	_, err = cli.execute(context.Background(), "/log loud")
+	assert.Error(t, err)
+	_, err = cli.execute(context.Background(), "/nolog")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"log 6", "nolog"}, server.Commands()[1:])
+}
+
+func TestClient_Formats(t *testing.T) {
+	server := startServer(t)
+	server.HandleAPI("show", func(args string) string {
+		return "uuid,direction,name\nabc,inbound,sofia/internal/1000\nd,outbound,sofia/external/1234\n\n2 total.\n"
+	})
+	var output syncBuffer
+	cli := newTestClient(t, server, &output)
+
+	_, err := cli.execute(context.Background(), "/format table")
+	require.NoError(t, err)
+	_, err = cli.execute(context.Background(), "show channels")
+	require.NoError(t, err)
+	assert.Equal(t, "uuid  direction  name\nabc   inbound    sofia/internal/1000\nd     outbound   sofia/external/1234\n\n2 total.\n", output.String())
+
+	output = syncBuffer{}
+	cli.printer.writer = &output
+	_, err = cli.execute(context.Background(), "/format json")
+	require.NoError(t, err)
+	_, err = cli.execute(context.Background(), "echo hi")
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"response","headers":{"Content-Length":"2","Content-Type":"api/response"},"body":"hi"}`+"\n", output.String())
+
+	_, err = cli.execute(context.Background(), "/format yaml")
+	assert.Error(t, err)
+}
+
+func TestHistory_Expand(t *testing.T) {
+	h := &history{max: 2}
+	_, err := h.expand("!!")
+	assert.Error(t, err)
+
+	h.add("status")
+	h.add("status")
+	h.add("show channels")
+	h.add("version")
+	assert.Equal(t, []string{"show channels", "version"}, h.list())
+
+	line, err := h.expand("!!")
+	assert.NoError(t, err)
+	assert.Equal(t, "version", line)
+	line, err = h.expand("!1")
+	assert.NoError(t, err)
+	assert.Equal(t, "show channels", line)
+	_, err = h.expand("!3")
+	assert.Error(t, err)
+	line, err = h.expand("echo !")
+	assert.NoError(t, err)
+	assert.Equal(t, "echo !", line)
+}
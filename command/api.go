@@ -24,3 +24,11 @@ func (api API) BuildMessage() string {
 	}
 	return fmt.Sprintf("api %s %s", api.Command, api.Arguments)
 }
+
+// Validate - Rejects a command or arguments containing line breaks, which would end the command early
+func (api API) Validate() error {
+	if err := CheckValue("command", api.Command); err != nil {
+		return err
+	}
+	return CheckValue("arguments", api.Arguments)
+}
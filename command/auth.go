@@ -10,16 +10,40 @@
  */
 package command
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type Auth struct {
 	User     string
 	Password string
 }
 
+// String - Implement the Stringer interface so the password is never printed by accident (%v)
+func (auth Auth) String() string {
+	if len(auth.User) > 0 {
+		return fmt.Sprintf("userauth %s:********", auth.User)
+	}
+	return "auth ********"
+}
+
+// GoString - Implement the GoStringer interface so the password is never printed by accident (%#v)
+func (auth Auth) GoString() string {
+	return auth.String()
+}
+
 func (auth Auth) BuildMessage() string {
 	if len(auth.User) > 0 {
 		return fmt.Sprintf("userauth %s:%s", auth.User, auth.Password)
 	}
 	return fmt.Sprintf("auth %s", auth.Password)
 }
+
+// Validate - Rejects a user or password containing line breaks, the values are left out of the error
+func (auth Auth) Validate() error {
+	if strings.ContainsAny(auth.User, "\r\n") || strings.ContainsAny(auth.Password, "\r\n") {
+		return fmt.Errorf("%w: credentials contain a line break", ErrInvalidCommand)
+	}
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"net/textproto"
 	"strconv"
+	"strings"
 
 	"github.com/shuguocloud/eslgo/command"
 )
@@ -44,8 +45,8 @@ type Export Set
 // Helper to call Execute with Push since it is commonly used
 type Push Set
 
-func (s Set) buildMessage(app string) string {
-	e := Execute{
+func (s Set) execute(app string) *Execute {
+	return &Execute{
 		UUID:      s.UUID,
 		AppName:   app,
 		AppArgs:   fmt.Sprintf("%s=%s", s.Key, s.Value),
@@ -53,22 +54,47 @@ func (s Set) buildMessage(app string) string {
 		SyncPri:   s.SyncPri,
 		ForceBody: true,
 	}
-	return e.BuildMessage()
 }
 
 func (s Set) BuildMessage() string {
-	return s.buildMessage("set")
+	return s.execute("set").BuildMessage()
+}
+
+// Validate - Rejects values that would break the sendmsg headers
+func (s Set) Validate() error {
+	return s.execute("set").Validate()
 }
 
 func (e Export) BuildMessage() string {
-	return Set(e).buildMessage("export")
+	return Set(e).execute("export").BuildMessage()
+}
+
+// Validate - Rejects values that would break the sendmsg headers
+func (e Export) Validate() error {
+	return Set(e).execute("export").Validate()
 }
 
 func (p Push) BuildMessage() string {
-	return Set(p).buildMessage("push")
+	return Set(p).execute("push").BuildMessage()
+}
+
+// Validate - Rejects values that would break the sendmsg headers
+func (p Push) Validate() error {
+	return Set(p).execute("push").Validate()
 }
 
 func (e *Execute) BuildMessage() string {
+	sendMsg := e.message()
+	return sendMsg.BuildMessage()
+}
+
+// Validate - Rejects an app name or UUID that would break the sendmsg headers, arguments with line breaks are sent in the body
+func (e *Execute) Validate() error {
+	sendMsg := e.message()
+	return sendMsg.Validate()
+}
+
+func (e *Execute) message() command.SendMessage {
 	if e.Loops == 0 {
 		e.Loops = 1
 	}
@@ -86,13 +112,12 @@ func (e *Execute) BuildMessage() string {
 		sendMsg.Headers.Set("Event-UUID", e.AppUUID)
 	}
 
-	// According to documentation that is the max header length
-	if len(e.AppArgs) > 2048 || e.ForceBody {
+	// According to documentation that is the max header length, line breaks can only be sent in the body
+	if len(e.AppArgs) > 2048 || e.ForceBody || strings.ContainsAny(e.AppArgs, "\r\n") {
 		sendMsg.Headers.Set("Content-Type", "text/plain")
 		sendMsg.Body = e.AppArgs
 	} else {
 		sendMsg.Headers.Set("execute-app-arg", e.AppArgs)
 	}
-
-	return sendMsg.BuildMessage()
+	return sendMsg
 }
@@ -88,3 +88,34 @@ func TestPush_BuildMessage(t *testing.T) {
 	}
 	assert.Equal(t, normalizeMessage(TestPushMessage), normalizeMessage(push.BuildMessage()))
 }
+
+func TestExecute_BuildMessage_LineBreak(t *testing.T) {
+	// Arguments with line breaks are moved to the body instead of ending the header early
+	exec := Execute{
+		UUID:    "none",
+		AppName: "playback",
+		AppArgs: "/tmp/test.wav\r\nCall-Command: hangup",
+	}
+	assert.NoError(t, exec.Validate())
+	assert.Equal(t, strings.ReplaceAll(`sendmsg none
+Call-Command: execute
+Content-Length: 35
+Content-Type: text/plain
+Execute-App-Name: playback
+Loops: 1
+
+/tmp/test.wav`, "\n", "\r\n")+"\r\nCall-Command: hangup", exec.BuildMessage())
+}
+
+func TestExecute_Validate(t *testing.T) {
+	assert.Error(t, (&Execute{UUID: "none", AppName: "playback\r\n"}).Validate())
+	assert.Error(t, (&Execute{UUID: "none\n", AppName: "playback"}).Validate())
+	assert.Error(t, Set{UUID: "none\r\n", Key: "hello", Value: "world"}.Validate())
+	assert.NoError(t, Set{UUID: "none", Key: "hello", Value: "multi\nline"}.Validate())
+	assert.Error(t, Hangup{UUID: "none", Cause: "NORMAL_CLEARING\r\n"}.Validate())
+	assert.Error(t, Hangup{UUID: "none", Cause: "NORMAL_CLEARNING"}.Validate())
+	assert.NoError(t, Hangup{UUID: "none", Cause: CauseNormalClearing}.Validate())
+	assert.NoError(t, Hangup{UUID: "none"}.Validate())
+	assert.Error(t, Transfer{UUID: "none", Application: "park\n"}.Validate())
+	assert.Error(t, NoMedia{UUID: "none", NoMediaUUID: "other\r"}.Validate())
+}
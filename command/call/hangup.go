@@ -11,6 +11,7 @@
 package call
 
 import (
+    "fmt"
     "net/textproto"
 
     "github.com/shuguocloud/eslgo/command"
@@ -18,12 +19,26 @@ import (
 
 type Hangup struct {
 	UUID    string
-	Cause   string
+	Cause   HangupCause
 	Sync    bool
 	SyncPri bool
 }
 
 func (h Hangup) BuildMessage() string {
+	sendMsg := h.message()
+	return sendMsg.BuildMessage()
+}
+
+// Validate - Rejects causes FreeSWITCH does not know and values that would break the sendmsg headers, an empty cause is left to FreeSWITCH
+func (h Hangup) Validate() error {
+	if h.Cause != "" && !h.Cause.IsValid() {
+		return fmt.Errorf("%w: unknown hangup cause %q", command.ErrInvalidCommand, string(h.Cause))
+	}
+	sendMsg := h.message()
+	return sendMsg.Validate()
+}
+
+func (h Hangup) message() command.SendMessage {
 	sendMsg := command.SendMessage{
 		UUID:    h.UUID,
 		Headers: make(textproto.MIMEHeader),
@@ -31,7 +46,6 @@ func (h Hangup) BuildMessage() string {
 		SyncPri: h.SyncPri,
 	}
 	sendMsg.Headers.Set("call-command", "hangup")
-	sendMsg.Headers.Set("hangup-cause", h.Cause)
-
-	return sendMsg.BuildMessage()
+	sendMsg.Headers.Set("hangup-cause", string(h.Cause))
+	return sendMsg
 }
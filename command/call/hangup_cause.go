@@ -0,0 +1,355 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package call
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HangupCause - A FreeSWITCH hangup cause name as sent in the Hangup-Cause header, e.g. NORMAL_CLEARING
+type HangupCause string
+
+// HangupClass - The outcome a hangup cause represents, see HangupCause.Class
+type HangupClass int
+
+const (
+	HangupClassFailure  HangupClass = iota // The call could not be completed because of an error, congestion or a rejection by the network
+	HangupClassNormal                      // The call ended normally, was transferred or was answered elsewhere
+	HangupClassBusy                        // The called party was reached but busy or declined the call
+	HangupClassNoAnswer                    // The called party did not answer before the call was given up
+)
+
+// The hangup causes known by FreeSWITCH, see https://freeswitch.org/confluence/display/FREESWITCH/Hangup+Cause+Code+Table
+const (
+	CauseUnspecified                 HangupCause = "UNSPECIFIED"
+	CauseUnallocatedNumber           HangupCause = "UNALLOCATED_NUMBER"
+	CauseNoRouteTransitNet           HangupCause = "NO_ROUTE_TRANSIT_NET"
+	CauseNoRouteDestination          HangupCause = "NO_ROUTE_DESTINATION"
+	CauseChannelUnacceptable         HangupCause = "CHANNEL_UNACCEPTABLE"
+	CauseCallAwardedDelivered        HangupCause = "CALL_AWARDED_DELIVERED"
+	CauseNormalClearing              HangupCause = "NORMAL_CLEARING"
+	CauseUserBusy                    HangupCause = "USER_BUSY"
+	CauseNoUserResponse              HangupCause = "NO_USER_RESPONSE"
+	CauseNoAnswer                    HangupCause = "NO_ANSWER"
+	CauseSubscriberAbsent            HangupCause = "SUBSCRIBER_ABSENT"
+	CauseCallRejected                HangupCause = "CALL_REJECTED"
+	CauseNumberChanged               HangupCause = "NUMBER_CHANGED"
+	CauseRedirectionToNewDestination HangupCause = "REDIRECTION_TO_NEW_DESTINATION"
+	CauseExchangeRoutingError        HangupCause = "EXCHANGE_ROUTING_ERROR"
+	CauseDestinationOutOfOrder       HangupCause = "DESTINATION_OUT_OF_ORDER"
+	CauseInvalidNumberFormat         HangupCause = "INVALID_NUMBER_FORMAT"
+	CauseFacilityRejected            HangupCause = "FACILITY_REJECTED"
+	CauseResponseToStatusEnquiry     HangupCause = "RESPONSE_TO_STATUS_ENQUIRY"
+	CauseNormalUnspecified           HangupCause = "NORMAL_UNSPECIFIED"
+	CauseNormalCircuitCongestion     HangupCause = "NORMAL_CIRCUIT_CONGESTION"
+	CauseNetworkOutOfOrder           HangupCause = "NETWORK_OUT_OF_ORDER"
+	CauseNormalTemporaryFailure      HangupCause = "NORMAL_TEMPORARY_FAILURE"
+	CauseSwitchCongestion            HangupCause = "SWITCH_CONGESTION"
+	CauseAccessInfoDiscarded         HangupCause = "ACCESS_INFO_DISCARDED"
+	CauseRequestedChanUnavail        HangupCause = "REQUESTED_CHAN_UNAVAIL"
+	CausePreEmpted                   HangupCause = "PRE_EMPTED"
+	CauseFacilityNotSubscribed       HangupCause = "FACILITY_NOT_SUBSCRIBED"
+	CauseOutgoingCallBarred          HangupCause = "OUTGOING_CALL_BARRED"
+	CauseIncomingCallBarred          HangupCause = "INCOMING_CALL_BARRED"
+	CauseBearerCapabilityNotAuth     HangupCause = "BEARERCAPABILITY_NOTAUTH"
+	CauseBearerCapabilityNotAvail    HangupCause = "BEARERCAPABILITY_NOTAVAIL"
+	CauseServiceUnavailable          HangupCause = "SERVICE_UNAVAILABLE"
+	CauseBearerCapabilityNotImpl     HangupCause = "BEARERCAPABILITY_NOTIMPL"
+	CauseChanNotImplemented          HangupCause = "CHAN_NOT_IMPLEMENTED"
+	CauseFacilityNotImplemented      HangupCause = "FACILITY_NOT_IMPLEMENTED"
+	CauseServiceNotImplemented       HangupCause = "SERVICE_NOT_IMPLEMENTED"
+	CauseInvalidCallReference        HangupCause = "INVALID_CALL_REFERENCE"
+	CauseIncompatibleDestination     HangupCause = "INCOMPATIBLE_DESTINATION"
+	CauseInvalidMsgUnspecified       HangupCause = "INVALID_MSG_UNSPECIFIED"
+	CauseMandatoryIEMissing          HangupCause = "MANDATORY_IE_MISSING"
+	CauseMessageTypeNonexist         HangupCause = "MESSAGE_TYPE_NONEXIST"
+	CauseWrongMessage                HangupCause = "WRONG_MESSAGE"
+	CauseIENonexist                  HangupCause = "IE_NONEXIST"
+	CauseInvalidIEContents           HangupCause = "INVALID_IE_CONTENTS"
+	CauseWrongCallState              HangupCause = "WRONG_CALL_STATE"
+	CauseRecoveryOnTimerExpire       HangupCause = "RECOVERY_ON_TIMER_EXPIRE"
+	CauseMandatoryIELengthError      HangupCause = "MANDATORY_IE_LENGTH_ERROR"
+	CauseProtocolError               HangupCause = "PROTOCOL_ERROR"
+	CauseInterworking                HangupCause = "INTERWORKING"
+	CauseSuccess                     HangupCause = "SUCCESS"
+	CauseOriginatorCancel            HangupCause = "ORIGINATOR_CANCEL"
+	CauseCrash                       HangupCause = "CRASH"
+	CauseSystemShutdown              HangupCause = "SYSTEM_SHUTDOWN"
+	CauseLoseRace                    HangupCause = "LOSE_RACE"
+	CauseManagerRequest              HangupCause = "MANAGER_REQUEST"
+	CauseBlindTransfer               HangupCause = "BLIND_TRANSFER"
+	CauseAttendedTransfer            HangupCause = "ATTENDED_TRANSFER"
+	CauseAllottedTimeout             HangupCause = "ALLOTTED_TIMEOUT"
+	CauseUserChallenge               HangupCause = "USER_CHALLENGE"
+	CauseMediaTimeout                HangupCause = "MEDIA_TIMEOUT"
+	CausePickedOff                   HangupCause = "PICKED_OFF"
+	CauseUserNotRegistered           HangupCause = "USER_NOT_REGISTERED"
+	CauseProgressTimeout             HangupCause = "PROGRESS_TIMEOUT"
+	CauseInvalidGateway              HangupCause = "INVALID_GATEWAY"
+	CauseGatewayDown                 HangupCause = "GATEWAY_DOWN"
+	CauseInvalidURL                  HangupCause = "INVALID_URL"
+	CauseInvalidProfile              HangupCause = "INVALID_PROFILE"
+	CauseNoPickup                    HangupCause = "NO_PICKUP"
+	CauseSRTPReadError               HangupCause = "SRTP_READ_ERROR"
+	CauseBowout                      HangupCause = "BOWOUT"
+	CauseBusyEverywhere              HangupCause = "BUSY_EVERYWHERE"
+	CauseDecline                     HangupCause = "DECLINE"
+	CauseDoesNotExistAnywhere        HangupCause = "DOES_NOT_EXIST_ANYWHERE"
+	CauseNotAcceptable               HangupCause = "NOT_ACCEPTABLE"
+	CauseUnwanted                    HangupCause = "UNWANTED"
+	CauseNoIdentity                  HangupCause = "NO_IDENTITY"
+	CauseBadIdentityInfo             HangupCause = "BAD_IDENTITY_INFO"
+	CauseUnsupportedCertificate      HangupCause = "UNSUPPORTED_CERTIFICATE"
+	CauseInvalidIdentity             HangupCause = "INVALID_IDENTITY"
+	CauseStaleDate                   HangupCause = "STALE_DATE"
+	CauseRejectAll                   HangupCause = "REJECT_ALL"
+)
+
+type hangupCauseInfo struct {
+	code  int // Q.850 cause code, FreeSWITCH specific causes use codes above 127
+	sip   int // SIP status FreeSWITCH answers an INVITE with when hanging up with the cause
+	class HangupClass
+}
+
+// From switch_channel.c and the sofia hangup cause to SIP status mapping, causes without a specific status are answered with 480
+var hangupCauses = map[HangupCause]hangupCauseInfo{
+	CauseUnspecified:                 {0, 480, HangupClassFailure},
+	CauseUnallocatedNumber:           {1, 404, HangupClassFailure},
+	CauseNoRouteTransitNet:           {2, 404, HangupClassFailure},
+	CauseNoRouteDestination:          {3, 404, HangupClassFailure},
+	CauseChannelUnacceptable:         {6, 480, HangupClassFailure},
+	CauseCallAwardedDelivered:        {7, 480, HangupClassNormal},
+	CauseNormalClearing:              {16, 480, HangupClassNormal},
+	CauseUserBusy:                    {17, 486, HangupClassBusy},
+	CauseNoUserResponse:              {18, 408, HangupClassNoAnswer},
+	CauseNoAnswer:                    {19, 480, HangupClassNoAnswer},
+	CauseSubscriberAbsent:            {20, 480, HangupClassNoAnswer},
+	CauseCallRejected:                {21, 603, HangupClassBusy},
+	CauseNumberChanged:               {22, 410, HangupClassFailure},
+	CauseRedirectionToNewDestination: {23, 410, HangupClassFailure},
+	CauseExchangeRoutingError:        {25, 483, HangupClassFailure},
+	CauseDestinationOutOfOrder:       {27, 480, HangupClassFailure},
+	CauseInvalidNumberFormat:         {28, 484, HangupClassFailure},
+	CauseFacilityRejected:            {29, 501, HangupClassFailure},
+	CauseResponseToStatusEnquiry:     {30, 480, HangupClassFailure},
+	CauseNormalUnspecified:           {31, 480, HangupClassNormal},
+	CauseNormalCircuitCongestion:     {34, 503, HangupClassFailure},
+	CauseNetworkOutOfOrder:           {38, 503, HangupClassFailure},
+	CauseNormalTemporaryFailure:      {41, 503, HangupClassFailure},
+	CauseSwitchCongestion:            {42, 503, HangupClassFailure},
+	CauseAccessInfoDiscarded:         {43, 480, HangupClassFailure},
+	CauseRequestedChanUnavail:        {44, 503, HangupClassFailure},
+	CausePreEmpted:                   {45, 480, HangupClassFailure},
+	CauseFacilityNotSubscribed:       {50, 480, HangupClassFailure},
+	CauseOutgoingCallBarred:          {52, 403, HangupClassFailure},
+	CauseIncomingCallBarred:          {54, 403, HangupClassFailure},
+	CauseBearerCapabilityNotAuth:     {57, 403, HangupClassFailure},
+	CauseBearerCapabilityNotAvail:    {58, 503, HangupClassFailure},
+	CauseServiceUnavailable:          {63, 480, HangupClassFailure},
+	CauseBearerCapabilityNotImpl:     {65, 488, HangupClassFailure},
+	CauseChanNotImplemented:          {66, 480, HangupClassFailure},
+	CauseFacilityNotImplemented:      {69, 501, HangupClassFailure},
+	CauseServiceNotImplemented:       {79, 501, HangupClassFailure},
+	CauseInvalidCallReference:        {81, 480, HangupClassFailure},
+	CauseIncompatibleDestination:     {88, 488, HangupClassFailure},
+	CauseInvalidMsgUnspecified:       {95, 480, HangupClassFailure},
+	CauseMandatoryIEMissing:          {96, 480, HangupClassFailure},
+	CauseMessageTypeNonexist:         {97, 480, HangupClassFailure},
+	CauseWrongMessage:                {98, 480, HangupClassFailure},
+	CauseIENonexist:                  {99, 480, HangupClassFailure},
+	CauseInvalidIEContents:           {100, 480, HangupClassFailure},
+	CauseWrongCallState:              {101, 480, HangupClassFailure},
+	CauseRecoveryOnTimerExpire:       {102, 504, HangupClassFailure},
+	CauseMandatoryIELengthError:      {103, 480, HangupClassFailure},
+	CauseProtocolError:               {111, 480, HangupClassFailure},
+	CauseInterworking:                {127, 500, HangupClassFailure},
+	CauseSuccess:                     {142, 480, HangupClassNormal},
+	CauseOriginatorCancel:            {487, 487, HangupClassNoAnswer},
+	CauseCrash:                       {700, 480, HangupClassFailure},
+	CauseSystemShutdown:              {701, 480, HangupClassFailure},
+	CauseLoseRace:                    {702, 480, HangupClassNormal},
+	CauseManagerRequest:              {703, 480, HangupClassNormal},
+	CauseBlindTransfer:               {800, 480, HangupClassNormal},
+	CauseAttendedTransfer:            {801, 480, HangupClassNormal},
+	CauseAllottedTimeout:             {802, 480, HangupClassNormal},
+	CauseUserChallenge:               {803, 480, HangupClassFailure},
+	CauseMediaTimeout:                {804, 480, HangupClassFailure},
+	CausePickedOff:                   {805, 480, HangupClassNormal},
+	CauseUserNotRegistered:           {806, 480, HangupClassFailure},
+	CauseProgressTimeout:             {807, 480, HangupClassNoAnswer},
+	CauseInvalidGateway:              {808, 480, HangupClassFailure},
+	CauseGatewayDown:                 {809, 503, HangupClassFailure},
+	CauseInvalidURL:                  {810, 480, HangupClassFailure},
+	CauseInvalidProfile:              {811, 480, HangupClassFailure},
+	CauseNoPickup:                    {812, 480, HangupClassNoAnswer},
+	CauseSRTPReadError:               {813, 480, HangupClassFailure},
+	CauseBowout:                      {814, 480, HangupClassNormal},
+	CauseBusyEverywhere:              {815, 600, HangupClassBusy},
+	CauseDecline:                     {816, 603, HangupClassBusy},
+	CauseDoesNotExistAnywhere:        {817, 604, HangupClassFailure},
+	CauseNotAcceptable:               {818, 606, HangupClassFailure},
+	CauseUnwanted:                    {819, 607, HangupClassBusy},
+	CauseNoIdentity:                  {820, 428, HangupClassFailure},
+	CauseBadIdentityInfo:             {821, 429, HangupClassFailure},
+	CauseUnsupportedCertificate:      {822, 437, HangupClassFailure},
+	CauseInvalidIdentity:             {823, 438, HangupClassFailure},
+	CauseStaleDate:                   {824, 403, HangupClassFailure},
+	CauseRejectAll:                   {825, 603, HangupClassBusy},
+}
+
+var hangupCausesByCode = make(map[int]HangupCause, len(hangupCauses))
+
+// The cause FreeSWITCH uses for a SIP final response received for an outgoing INVITE, anything else is NORMAL_UNSPECIFIED
+var sipHangupCauses = map[int]HangupCause{
+	200: CauseNormalClearing,
+	400: CauseNormalTemporaryFailure,
+	401: CauseCallRejected,
+	402: CauseCallRejected,
+	403: CauseCallRejected,
+	404: CauseUnallocatedNumber,
+	405: CauseServiceUnavailable,
+	406: CauseServiceNotImplemented,
+	407: CauseCallRejected,
+	408: CauseRecoveryOnTimerExpire,
+	410: CauseNumberChanged,
+	413: CauseInterworking,
+	414: CauseInterworking,
+	415: CauseServiceNotImplemented,
+	416: CauseInterworking,
+	420: CauseInterworking,
+	421: CauseInterworking,
+	423: CauseInterworking,
+	428: CauseNoIdentity,
+	429: CauseBadIdentityInfo,
+	437: CauseUnsupportedCertificate,
+	438: CauseInvalidIdentity,
+	480: CauseNoUserResponse,
+	481: CauseNormalTemporaryFailure,
+	482: CauseExchangeRoutingError,
+	483: CauseExchangeRoutingError,
+	484: CauseInvalidNumberFormat,
+	485: CauseNoRouteDestination,
+	486: CauseUserBusy,
+	487: CauseOriginatorCancel,
+	488: CauseIncompatibleDestination,
+	500: CauseNormalTemporaryFailure,
+	501: CauseServiceNotImplemented,
+	502: CauseNetworkOutOfOrder,
+	503: CauseNormalTemporaryFailure,
+	504: CauseRecoveryOnTimerExpire,
+	505: CauseInterworking,
+	513: CauseInterworking,
+	600: CauseUserBusy,
+	603: CauseCallRejected,
+	604: CauseNoRouteDestination,
+	606: CauseIncompatibleDestination,
+	607: CauseUnwanted,
+	608: CauseCallRejected,
+}
+
+func init() {
+	for cause, info := range hangupCauses {
+		hangupCausesByCode[info.code] = cause
+	}
+}
+
+// ParseHangupCause - Parses a cause name, case insensitive, or a numeric Q.850 code such as variable_hangup_cause_q850
+func ParseHangupCause(value string) (HangupCause, error) {
+	value = strings.TrimSpace(value)
+	if code, err := strconv.Atoi(value); err == nil {
+		if cause, ok := HangupCauseFromQ850(code); ok {
+			return cause, nil
+		}
+		return "", fmt.Errorf("unknown hangup cause code %d", code)
+	}
+	cause := HangupCause(strings.ToUpper(value))
+	if !cause.IsValid() {
+		return "", fmt.Errorf("unknown hangup cause %q", value)
+	}
+	return cause, nil
+}
+
+// HangupCauseFromQ850 - Returns the cause with the Q.850 code
+func HangupCauseFromQ850(code int) (HangupCause, bool) {
+	cause, ok := hangupCausesByCode[code]
+	return cause, ok
+}
+
+// HangupCauseFromSIP - Returns the cause FreeSWITCH reports when an outgoing call fails with the SIP status
+func HangupCauseFromSIP(status int) HangupCause {
+	if cause, ok := sipHangupCauses[status]; ok {
+		return cause
+	}
+	return CauseNormalUnspecified
+}
+
+// IsValid - Reports if the cause is known by FreeSWITCH
+func (c HangupCause) IsValid() bool {
+	_, ok := hangupCauses[c]
+	return ok
+}
+
+// Q850 - Returns the Q.850 cause code, 0 for unknown causes
+func (c HangupCause) Q850() int {
+	return hangupCauses[c].code
+}
+
+// SIPStatus - Returns the SIP status FreeSWITCH responds with when rejecting a call with the cause, 480 for unknown causes
+func (c HangupCause) SIPStatus() int {
+	if info, ok := hangupCauses[c]; ok {
+		return info.sip
+	}
+	return 480
+}
+
+// Class - Returns the outcome the cause represents, unknown causes are failures
+func (c HangupCause) Class() HangupClass {
+	return hangupCauses[c].class
+}
+
+// IsNormal - Reports if the call ended normally, e.g. NORMAL_CLEARING or a transfer
+func (c HangupCause) IsNormal() bool {
+	return c.Class() == HangupClassNormal
+}
+
+// IsBusy - Reports if the called party was busy or declined the call, e.g. USER_BUSY or CALL_REJECTED
+func (c HangupCause) IsBusy() bool {
+	return c.Class() == HangupClassBusy
+}
+
+// IsNoAnswer - Reports if the call was not answered in time or canceled before it was, e.g. NO_ANSWER or ORIGINATOR_CANCEL
+func (c HangupCause) IsNoAnswer() bool {
+	return c.Class() == HangupClassNoAnswer
+}
+
+// IsFailure - Reports if the call failed for any other reason, including unknown causes
+func (c HangupCause) IsFailure() bool {
+	return c.Class() == HangupClassFailure
+}
+
+func (c HangupCause) String() string {
+	return string(c)
+}
+
+func (c HangupClass) String() string {
+	switch c {
+	case HangupClassNormal:
+		return "normal"
+	case HangupClassBusy:
+		return "busy"
+	case HangupClassNoAnswer:
+		return "no-answer"
+	default:
+		return "failure"
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package call
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHangupCause(t *testing.T) {
+	cause, err := ParseHangupCause("normal_clearing")
+	assert.Nil(t, err)
+	assert.Equal(t, CauseNormalClearing, cause)
+
+	cause, err = ParseHangupCause(" 17 ")
+	assert.Nil(t, err)
+	assert.Equal(t, CauseUserBusy, cause)
+
+	_, err = ParseHangupCause("NORMAL_CLEARNING")
+	assert.EqualError(t, err, `unknown hangup cause "NORMAL_CLEARNING"`)
+	_, err = ParseHangupCause("4")
+	assert.Error(t, err)
+}
+
+func TestHangupCause_Mappings(t *testing.T) {
+	assert.Len(t, hangupCausesByCode, len(hangupCauses), "every cause has its own Q.850 code")
+	for cause, info := range hangupCauses {
+		parsed, ok := HangupCauseFromQ850(info.code)
+		assert.True(t, ok)
+		assert.Equal(t, cause, parsed)
+	}
+
+	assert.Equal(t, 16, CauseNormalClearing.Q850())
+	assert.Equal(t, 487, CauseOriginatorCancel.Q850())
+	assert.Equal(t, 0, HangupCause("BOGUS").Q850())
+	assert.Equal(t, 486, CauseUserBusy.SIPStatus())
+	assert.Equal(t, 404, CauseNoRouteDestination.SIPStatus())
+	assert.Equal(t, 503, CauseGatewayDown.SIPStatus())
+	assert.Equal(t, 480, HangupCause("BOGUS").SIPStatus())
+
+	assert.Equal(t, CauseUserBusy, HangupCauseFromSIP(486))
+	assert.Equal(t, CauseCallRejected, HangupCauseFromSIP(403))
+	assert.Equal(t, CauseRecoveryOnTimerExpire, HangupCauseFromSIP(408))
+	assert.Equal(t, CauseNormalUnspecified, HangupCauseFromSIP(499))
+}
+
+func TestHangupCause_Class(t *testing.T) {
+	assert.True(t, CauseNormalClearing.IsNormal())
+	assert.True(t, CauseBlindTransfer.IsNormal())
+	assert.True(t, CauseUserBusy.IsBusy())
+	assert.True(t, CauseCallRejected.IsBusy())
+	assert.True(t, CauseNoAnswer.IsNoAnswer())
+	assert.True(t, CauseOriginatorCancel.IsNoAnswer())
+	assert.True(t, CauseNormalTemporaryFailure.IsFailure())
+	assert.True(t, HangupCause("BOGUS").IsFailure())
+	assert.False(t, HangupCause("BOGUS").IsValid())
+	assert.Equal(t, "no-answer", CauseNoAnswer.Class().String())
+	assert.Equal(t, "NORMAL_CLEARING", CauseNormalClearing.String())
+}
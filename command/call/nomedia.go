@@ -24,6 +24,17 @@ type NoMedia struct {
 }
 
 func (n NoMedia) BuildMessage() string {
+	sendMsg := n.message()
+	return sendMsg.BuildMessage()
+}
+
+// Validate - Rejects values that would break the sendmsg headers
+func (n NoMedia) Validate() error {
+	sendMsg := n.message()
+	return sendMsg.Validate()
+}
+
+func (n NoMedia) message() command.SendMessage {
 	sendMsg := command.SendMessage{
 		UUID:    n.UUID,
 		Headers: make(textproto.MIMEHeader),
@@ -32,6 +43,5 @@ func (n NoMedia) BuildMessage() string {
 	}
 	sendMsg.Headers.Set("call-command", "nomedia")
 	sendMsg.Headers.Set("nomedia-uuid", n.NoMediaUUID)
-
-	return sendMsg.BuildMessage()
+	return sendMsg
 }
@@ -25,6 +25,17 @@ type Transfer struct {
 }
 
 func (t Transfer) BuildMessage() string {
+	sendMsg := t.message()
+	return sendMsg.BuildMessage()
+}
+
+// Validate - Rejects values that would break the sendmsg headers
+func (t Transfer) Validate() error {
+	sendMsg := t.message()
+	return sendMsg.Validate()
+}
+
+func (t Transfer) message() command.SendMessage {
 	sendMsg := command.SendMessage{
 		UUID:    t.UUID,
 		Headers: make(textproto.MIMEHeader),
@@ -33,6 +44,5 @@ func (t Transfer) BuildMessage() string {
 	}
 	sendMsg.Headers.Set("call-command", "xferext")
 	sendMsg.Headers.Set("application", t.Application)
-
-	return sendMsg.BuildMessage()
+	return sendMsg
 }
@@ -11,6 +11,7 @@
 package call
 
 import (
+    "fmt"
     "net"
     "net/textproto"
 
@@ -33,6 +34,20 @@ type Unicast struct {
 }
 
 func (u Unicast) BuildMessage() string {
+	sendMsg := u.message()
+	return sendMsg.BuildMessage()
+}
+
+// Validate - Requires both addresses and rejects values that would break the sendmsg headers
+func (u Unicast) Validate() error {
+	if u.Local == nil || u.Remote == nil {
+		return fmt.Errorf("%w: unicast requires a local and a remote address", command.ErrInvalidCommand)
+	}
+	sendMsg := u.message()
+	return sendMsg.Validate()
+}
+
+func (u Unicast) message() command.SendMessage {
 	sendMsg := command.SendMessage{
 		UUID:    u.UUID,
 		Headers: make(textproto.MIMEHeader),
@@ -50,5 +65,5 @@ func (u Unicast) BuildMessage() string {
 	if len(u.Flags) > 0 {
 		sendMsg.Headers.Set("flags", u.Flags)
 	}
-	return sendMsg.BuildMessage()
+	return sendMsg
 }
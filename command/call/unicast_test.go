@@ -38,3 +38,10 @@ func TestUnicast_BuildMessage(t *testing.T) {
 	}
 	assert.Equal(t, normalizeMessage(TestUnicastMessage), normalizeMessage(unicast.BuildMessage()))
 }
+
+func TestUnicast_Validate(t *testing.T) {
+	testLocal, _ := net.ResolveTCPAddr("tcp", "192.168.1.100:8025")
+	assert.Error(t, Unicast{UUID: "none", Local: testLocal}.Validate())
+	assert.Error(t, Unicast{UUID: "none", Local: testLocal, Remote: testLocal, Flags: "native\r\n"}.Validate())
+	assert.NoError(t, Unicast{UUID: "none", Local: testLocal, Remote: testLocal}.Validate())
+}
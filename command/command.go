@@ -11,6 +11,8 @@
 package command
 
 import (
+	"errors"
+	"fmt"
 	"net/textproto"
 	"sort"
 	"strings"
@@ -21,10 +23,56 @@ type Command interface {
 	BuildMessage() string
 }
 
-var crlfToLF = strings.NewReplacer("\r\n", "\n")
+// Validator - Implemented by commands that check their fields before they are built. SendCommand returns the error
+// instead of writing a command that would be corrupted on the wire. Implement it for your own commands too.
+type Validator interface {
+	Validate() error
+}
+
+// ErrInvalidCommand - Wrapped by every validation error of the commands, test for it with errors.Is
+var ErrInvalidCommand = errors.New("invalid command")
+
+// Replaces line breaks left in header values by BuildMessage callers that skipped Validate
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// Validate - Validates the command if it implements Validator
+func Validate(cmd Command) error {
+	if validator, ok := cmd.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// CheckValue - Rejects a value containing a line break, which would end the command or start a new header on the wire.
+// Use it for arguments and header values written before the body of a command
+func CheckValue(name, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%w: %s contains a line break: %q", ErrInvalidCommand, name, value)
+	}
+	return nil
+}
 
-// FormatHeaderString - Writes headers in a FreeSWITCH ESL friendly format. Converts headers containing \r\n to \n
+// CheckHeaders - Rejects header names that are empty or contain a colon, a space or a line break, and values containing a line break
+func CheckHeaders(headers textproto.MIMEHeader) error {
+	for key, values := range headers {
+		if key == "" || strings.ContainsAny(key, ": \t\r\n") {
+			return fmt.Errorf("%w: invalid header name %q", ErrInvalidCommand, key)
+		}
+		for _, value := range values {
+			if err := CheckValue("header "+key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FormatHeaderString - Writes headers in a FreeSWITCH ESL friendly format, sorted by name so the output is deterministic.
+// Line breaks in values are replaced by spaces, use CheckHeaders to reject them instead
 func FormatHeaderString(headers textproto.MIMEHeader) string {
+	if len(headers) == 0 {
+		return ""
+	}
 	var ws strings.Builder
 	ws.Grow(estimateSize(headers))
 
@@ -38,7 +86,7 @@ func FormatHeaderString(headers textproto.MIMEHeader) string {
 
 	for _, key := range keys {
 		for _, value := range headers[key] {
-			value = crlfToLF.Replace(value)
+			value = lineBreaks.Replace(value)
 			value = textproto.TrimString(value)
 			ws.WriteString(key)
 			ws.WriteString(": ")
@@ -48,7 +96,7 @@ func FormatHeaderString(headers textproto.MIMEHeader) string {
 	}
 	// Remove the extra \r\n
 	str := ws.String()
-	return str[:len(str)-2]
+	return strings.TrimSuffix(str, "\r\n")
 }
 
 // helper for FormatHeaderString that estimates the size of the final header string to avoid multiple allocations
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package command
+
+import (
+	"errors"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatHeaderString(t *testing.T) {
+	assert.Equal(t, "", FormatHeaderString(nil))
+	assert.Equal(t, "A: 1\r\nB: 2\r\nB: 3\r\nC: 4", FormatHeaderString(textproto.MIMEHeader{
+		"C": {"4"},
+		"A": {"1"},
+		"B": {"2", "3"},
+	}))
+	// Line breaks never reach the wire even when Validate is skipped
+	assert.Equal(t, "A: one two three", FormatHeaderString(textproto.MIMEHeader{"A": {"one\r\ntwo\nthree"}}))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(Exit{}))
+	assert.NoError(t, Validate(API{Command: "status"}))
+	assert.NoError(t, Validate(Event{Format: "plain", Listen: []string{"ALL"}}))
+	assert.NoError(t, Validate(MyEvents{Format: "plain", UUID: "none"}))
+
+	for _, cmd := range []Command{
+		API{Command: "status\r\n\r\napi", Arguments: "shutdown"},
+		API{Command: "echo", Arguments: "a\nb"},
+		Auth{Password: "secret\r\n"},
+		Auth{User: "user\n", Password: "secret"},
+		Event{Format: "plain", Listen: []string{"ALL\r\n"}},
+		MyEvents{Format: "plain", UUID: "none\n"},
+		Filter{EventHeader: "Unique-ID", FilterValue: "none\r\nexit"},
+		&SendEvent{},
+	} {
+		assert.True(t, errors.Is(Validate(cmd), ErrInvalidCommand), "%v", cmd)
+	}
+	// The credentials are never part of the error
+	assert.NotContains(t, Validate(Auth{Password: "secret\r\n"}).Error(), "secret")
+}
@@ -68,18 +68,52 @@ func (d DivertEvents) BuildMessage() string {
 	return "divert_events off"
 }
 
+// Validate - Rejects a format or event names containing line breaks
+func (e Event) Validate() error {
+	if err := CheckValue("format", e.Format); err != nil {
+		return err
+	}
+	return CheckValue("events", strings.Join(e.Listen, " "))
+}
+
+// Validate - Rejects a format or UUID containing line breaks
+func (m MyEvents) Validate() error {
+	if err := CheckValue("format", m.Format); err != nil {
+		return err
+	}
+	return CheckValue("uuid", m.UUID)
+}
+
+// Validate - Requires an event name and rejects line breaks in it and in the headers, the body is sent with its length
+func (s *SendEvent) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("%w: empty event name", ErrInvalidCommand)
+	}
+	if err := CheckValue("event name", s.Name); err != nil {
+		return err
+	}
+	return CheckHeaders(s.Headers)
+}
+
 func (s *SendEvent) BuildMessage() string {
+	// Copy the headers so a nil map can be sent and the caller's map is left untouched
+	headers := make(textproto.MIMEHeader, len(s.Headers)+1)
+	for key, values := range s.Headers {
+		if textproto.CanonicalMIMEHeaderKey(key) != "Content-Length" {
+			headers[key] = values
+		}
+	}
 	// Ensure the correct content length is set in the header
 	if len(s.Body) > 0 {
-		s.Headers.Set("Content-Length", strconv.Itoa(len(s.Body)))
-	} else {
-		delete(s.Headers, "Content-Length")
+		headers.Set("Content-Length", strconv.Itoa(len(s.Body)))
 	}
 
-	// Format the headers
-	headerString := FormatHeaderString(s.Headers)
-	if _, ok := s.Headers["Content-Length"]; ok {
-		return fmt.Sprintf("sendevent %s\r\n%s\r\n\r\n%s", s.Name, headerString, s.Body)
+	message := "sendevent " + s.Name
+	if len(headers) > 0 {
+		message += "\r\n" + FormatHeaderString(headers)
+	}
+	if len(s.Body) > 0 {
+		return message + "\r\n\r\n" + s.Body
 	}
-	return fmt.Sprintf("sendevent %s\r\n%s", s.Name, headerString)
+	return message
 }
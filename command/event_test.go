@@ -57,3 +57,20 @@ func TestSendEvent_BuildMessage(t *testing.T) {
 	}
 	assert.Equal(t, TestSendEventMessage, sendEvent.BuildMessage())
 }
+
+func TestSendEvent_BuildMessage_Body(t *testing.T) {
+	// A nil header map is allowed and the caller's headers are left untouched
+	assert.Equal(t, "sendevent CUSTOM", (&SendEvent{Name: "CUSTOM"}).BuildMessage())
+	assert.Equal(t, "sendevent CUSTOM\r\nContent-Length: 5\r\n\r\nhello", (&SendEvent{Name: "CUSTOM", Body: "hello"}).BuildMessage())
+
+	headers := map[string][]string{"Event-Subclass": {"test::event"}}
+	sendEvent := SendEvent{Name: "CUSTOM", Headers: headers, Body: "hi"}
+	assert.Equal(t, "sendevent CUSTOM\r\nContent-Length: 2\r\nEvent-Subclass: test::event\r\n\r\nhi", sendEvent.BuildMessage())
+	assert.Len(t, headers, 1)
+}
+
+func TestSendEvent_Validate(t *testing.T) {
+	assert.NoError(t, (&SendEvent{Name: "CUSTOM", Body: "multi\nline"}).Validate())
+	assert.Error(t, (&SendEvent{Name: "CUSTOM\r\nexit"}).Validate())
+	assert.Error(t, (&SendEvent{Name: "CUSTOM", Headers: map[string][]string{"Event-Subclass": {"a\r\n\r\nb"}}}).Validate())
+}
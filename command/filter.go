@@ -29,3 +29,11 @@ func (f Filter) BuildMessage() string {
 	}
 	return fmt.Sprintf("filter %s %s", f.EventHeader, f.FilterValue)
 }
+
+// Validate - Rejects a header or value containing line breaks
+func (f Filter) Validate() error {
+	if err := CheckValue("event header", f.EventHeader); err != nil {
+		return err
+	}
+	return CheckValue("filter value", f.FilterValue)
+}
@@ -11,7 +11,6 @@
 package command
 
 import (
-	"fmt"
 	"net/textproto"
 	"strconv"
 	"strings"
@@ -25,41 +24,50 @@ type SendMessage struct {
 	SyncPri bool
 }
 
-func (s *SendMessage) BuildMessage() string {
-	var headers []string
+// Validate - Rejects a UUID or headers containing line breaks, the body can contain anything as its length is sent
+func (s *SendMessage) Validate() error {
+	if err := CheckValue("uuid", s.UUID); err != nil {
+		return err
+	}
+	return CheckHeaders(s.Headers)
+}
 
-	if s.Headers == nil {
-		s.Headers = make(textproto.MIMEHeader)
+func (s *SendMessage) BuildMessage() string {
+	var builder strings.Builder
+	builder.WriteString("sendmsg")
+	// Outbound connections can leave the UUID out to target their own channel
+	if s.UUID != "" {
+		builder.WriteString(" " + s.UUID)
 	}
 
 	// Waits for this event to finish before continuing even in async mode
 	if s.Sync {
-		headers = append(headers, "event-lock: true")
+		builder.WriteString("\r\nevent-lock: true")
 	}
 
 	// No documentation on this flag, I assume it takes priority over the other flag?
 	if s.SyncPri {
-		headers = append(headers, "event-lock-pri: true")
+		builder.WriteString("\r\nevent-lock-pri: true")
 	}
 
-	// Ensure the correct content length is set in the header
-	if len(s.Body) > 0 {
-		headers = append(headers, "Content-Length: "+strconv.Itoa(len(s.Body)))
-	} else {
-		delete(s.Headers, "Content-Length")
-	}
-
-	// Format the headers
+	// Copy the headers so the caller's map is left untouched and ensure the correct content length is set
+	headers := make(textproto.MIMEHeader, len(s.Headers)+1)
 	for key, values := range s.Headers {
-		for _, value := range values {
-			headers = append(headers, key+": "+value)
+		if textproto.CanonicalMIMEHeaderKey(key) != "Content-Length" {
+			headers[key] = values
 		}
 	}
+	if len(s.Body) > 0 {
+		headers.Set("Content-Length", strconv.Itoa(len(s.Body)))
+	}
 
-	headerString := strings.Join(headers, "\r\n")
-
+	if len(headers) > 0 {
+		builder.WriteString("\r\n")
+		builder.WriteString(FormatHeaderString(headers))
+	}
 	if len(s.Body) > 0 {
-		return fmt.Sprintf("sendmsg %s\r\n%s\r\n\r\n%s", s.UUID, headerString, s.Body)
+		builder.WriteString("\r\n\r\n")
+		builder.WriteString(s.Body)
 	}
-	return fmt.Sprintf("sendmsg %s\r\n%s", s.UUID, headerString)
+	return builder.String()
 }
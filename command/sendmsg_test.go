@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package command
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var TestSendMessageMessage = strings.ReplaceAll(`sendmsg none
+event-lock: true
+Call-Command: execute
+Content-Length: 5
+Content-Type: text/plain
+Execute-App-Name: set
+
+a=b c`, "\n", "\r\n")
+
+func TestSendMessage_BuildMessage(t *testing.T) {
+	headers := textproto.MIMEHeader{
+		"Execute-App-Name": {"set"},
+		"Call-Command":     {"execute"},
+		"Content-Type":     {"text/plain"},
+		"Content-Length":   {"999"},
+	}
+	message := SendMessage{UUID: "none", Headers: headers, Body: "a=b c", Sync: true}
+	// The header order is always the same and the caller's headers are left untouched
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, TestSendMessageMessage, message.BuildMessage())
+	}
+	assert.Equal(t, []string{"999"}, headers["Content-Length"])
+
+	assert.Equal(t, "sendmsg", (&SendMessage{}).BuildMessage())
+	assert.Equal(t, "sendmsg none\r\nevent-lock-pri: true", (&SendMessage{UUID: "none", SyncPri: true}).BuildMessage())
+}
+
+func TestSendMessage_Validate(t *testing.T) {
+	assert.NoError(t, (&SendMessage{UUID: "none", Body: "multi\r\nline"}).Validate())
+	assert.NoError(t, (&SendMessage{}).Validate())
+
+	for _, message := range []SendMessage{
+		{UUID: "none\r\nevent-lock: true"},
+		{UUID: "none", Headers: textproto.MIMEHeader{"Execute-App-Arg": {"a.wav\r\nCall-Command: hangup"}}},
+		{UUID: "none", Headers: textproto.MIMEHeader{"Execute-App-Arg": {"a.wav\n"}}},
+		{UUID: "none", Headers: textproto.MIMEHeader{"Bad Header": {"value"}}},
+		{UUID: "none", Headers: textproto.MIMEHeader{"Bad:Header": {"value"}}},
+		{UUID: "none", Headers: textproto.MIMEHeader{"": {"value"}}},
+	} {
+		err := message.Validate()
+		assert.True(t, errors.Is(err, ErrInvalidCommand), "%#v", message)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"net"
 	"net/textproto"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shuguocloud/eslgo/command"
@@ -32,21 +33,49 @@ type Conn struct {
 	stopFunc             func()
 	responseChannels     map[string]chan *RawResponse
 	responseChanMutex    sync.RWMutex
+	pendingLock          sync.Mutex
+	pending              []*pendingCommand
+	pendingClosed        bool
 	eventListenerLock    sync.RWMutex
 	eventListeners       map[string]map[string]EventListener
 	eventListenerCounter int
+	matchListeners       map[string]*matchListener
+	matchListenerIndex   map[string]map[string]*matchListener
+	logListeners         map[string]LogListener
 	outbound             bool
-	logger               Logger
+	logger               StructuredLogger
+	logFields            atomic.Value
+	logCommands          bool
+	observer             Observer
+	disconnectErr        error
+	closeErr             error
+	hooks                OutboundHooks
+	tracer               Tracer
+	recorder             *Recorder
+	traceLock            sync.Mutex
+	traceLinks           map[string]Span
+	traceLinkOrder       []string
+	sessionUUID          string
+	sessionContext       context.Context
 	exitTimeout          time.Duration
 	closeOnce            sync.Once
 	closeDelay           time.Duration
+	subscriptionLock     sync.Mutex
+	subscriptions        map[string]managedSubscription
+	appliedSubscription  subscriptionState
+	allEvents            bool // EnableEvents or myevents subscribed to every event, guarded by subscriptionLock
 }
 
 // Options - Generic options for an ESL connection, either inbound or outbound
 type Options struct {
-	Context     context.Context // This specifies the base running context for the connection. If this context expires all connections will be terminated.
-	Logger      Logger          // This specifies the logger to be used for any library internal messages. Can be set to nil to suppress everything.
-	ExitTimeout time.Duration   // How long should we wait for FreeSWITCH to respond to our "exit" command. 5 seconds is a sane default.
+	Context          context.Context  // This specifies the base running context for the connection. If this context expires all connections will be terminated.
+	Logger           Logger           // This specifies the logger to be used for any library internal messages. Can be set to nil to suppress everything.
+	StructuredLogger StructuredLogger // This specifies a key/value logger for library internal messages, used instead of Logger when set. See NewSlogLogger.
+	LogCommands      bool             // Log every command sent at debug level, passwords in auth commands are redacted.
+	Observer         Observer         // This specifies an optional observer notified about commands, replies, events and listeners, e.g. a MetricsCollector. Can be set to nil.
+	Recorder         *Recorder        // This specifies an optional recorder that writes every message sent and received to a recording for later replay. Outbound servers use OutboundOptions.NewRecorder instead.
+	Tracer           Tracer           // This specifies an optional tracer used to create spans for commands, events and outbound sessions. Can be set to nil to disable tracing.
+	ExitTimeout      time.Duration    // How long should we wait for FreeSWITCH to respond to our "exit" command. 5 seconds is a sane default.
 }
 
 // DefaultOptions - The default options used for creating the connection
@@ -62,9 +91,8 @@ func newConnection(c net.Conn, outbound bool, opts Options) *Conn {
 	reader := bufio.NewReader(c)
 	header := textproto.NewReader(reader)
 
-	// If logger is nil, do not actually output anything
-	if opts.Logger == nil {
-		opts.Logger = NilLogger{}
+	if opts.Observer == nil {
+		opts.Observer = NilObserver{}
 	}
 
 	runningContext, stop := context.WithCancel(opts.Context)
@@ -74,21 +102,29 @@ func newConnection(c net.Conn, outbound bool, opts Options) *Conn {
 		reader: reader,
 		header: header,
 		responseChannels: map[string]chan *RawResponse{
-			TypeReply:       make(chan *RawResponse),
-			TypeAPIResponse: make(chan *RawResponse),
 			TypeEventPlain:  make(chan *RawResponse),
 			TypeEventXML:    make(chan *RawResponse),
 			TypeEventJSON:   make(chan *RawResponse),
 			TypeAuthRequest: make(chan *RawResponse, 1), // Buffered to ensure we do not lose the initial auth request before we are setup to respond
 			TypeDisconnect:  make(chan *RawResponse),
 		},
-		runningContext: runningContext,
-		stopFunc:       stop,
-		eventListeners: make(map[string]map[string]EventListener),
-		outbound:       outbound,
-		logger:         opts.Logger,
-		exitTimeout:    opts.ExitTimeout,
+		runningContext:     runningContext,
+		stopFunc:           stop,
+		eventListeners:     make(map[string]map[string]EventListener),
+		matchListeners:     make(map[string]*matchListener),
+		matchListenerIndex: make(map[string]map[string]*matchListener),
+		logListeners:       make(map[string]LogListener),
+		subscriptions:      make(map[string]managedSubscription),
+		outbound:           outbound,
+		logger:             opts.structuredLogger(),
+		logCommands:        opts.LogCommands,
+		observer:           opts.Observer,
+		tracer:             opts.Tracer,
+		recorder:           opts.Recorder,
+		traceLinks:         make(map[string]Span),
+		exitTimeout:        opts.ExitTimeout,
 	}
+	instance.setLogFields("")
 	go instance.receiveLoop()
 	go instance.eventLoop()
 	return instance
@@ -119,8 +155,113 @@ func (c *Conn) RemoveEventListener(channelUUID string, id string) {
 	}
 }
 
+// matchListener - A listener registered with an EventMatcher, inline listeners are called from the dispatch loop instead of their own goroutine
+type matchListener struct {
+	matcher  EventMatcher
+	listener EventListener
+	keys     []string
+	inline   bool
+}
+
+// RegisterMatchListener - Registers a new event listener called for every event accepted by the matcher. Returns the registered listener ID used to remove it.
+// Listeners with matchers limited to specific event names, such as MatchEventName or MatchSubclass, are indexed so they are only tried against those events.
+func (c *Conn) RegisterMatchListener(matcher EventMatcher, listener EventListener) string {
+	return c.registerMatchListener(matcher, listener, false)
+}
+
+// RemoveMatchListener - Removes the listener with the listener ID returned from RegisterMatchListener
+func (c *Conn) RemoveMatchListener(id string) {
+	c.eventListenerLock.Lock()
+	defer c.eventListenerLock.Unlock()
+
+	registered, ok := c.matchListeners[id]
+	if !ok {
+		return
+	}
+	delete(c.matchListeners, id)
+	for _, key := range registered.keys {
+		delete(c.matchListenerIndex[key], id)
+		if len(c.matchListenerIndex[key]) == 0 {
+			delete(c.matchListenerIndex, key)
+		}
+	}
+}
+
+// Once - Returns a channel that receives the first event accepted by the matcher, the listener is removed after the first match.
+// The channel is closed without receiving an event if the context or connection ends first.
+func (c *Conn) Once(ctx context.Context, matcher EventMatcher) <-chan *Event {
+	events := make(chan *Event, 1)
+	done := make(chan struct{})
+	var once sync.Once
+	finish := func(event *Event) {
+		once.Do(func() {
+			if event != nil {
+				events <- event
+			}
+			close(events)
+			close(done)
+		})
+	}
+
+	id := c.registerMatchListener(matcher, finish, true)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		case <-c.runningContext.Done():
+		}
+		c.RemoveMatchListener(id)
+		finish(nil)
+	}()
+	return events
+}
+
+func (c *Conn) registerMatchListener(matcher EventMatcher, listener EventListener, inline bool) string {
+	c.eventListenerLock.Lock()
+	defer c.eventListenerLock.Unlock()
+
+	c.eventListenerCounter++
+	id := fmt.Sprintf("%d", c.eventListenerCounter)
+	registered := &matchListener{
+		matcher:  matcher,
+		listener: listener,
+		keys:     matcherEventNames(matcher),
+		inline:   inline,
+	}
+	if registered.keys == nil {
+		// Not limited to any event names, needs to be tried against every event
+		registered.keys = []string{""}
+	}
+	c.matchListeners[id] = registered
+	for _, key := range registered.keys {
+		if _, ok := c.matchListenerIndex[key]; !ok {
+			c.matchListenerIndex[key] = make(map[string]*matchListener)
+		}
+		c.matchListenerIndex[key][id] = registered
+	}
+	return id
+}
+
 // SendCommand - Sends the specified ESL command to FreeSWITCH with the provided context. Returns the response data and any errors encountered.
+// Commands implementing command.Validator are validated first, an invalid command is not sent and its error wraps command.ErrInvalidCommand.
 func (c *Conn) SendCommand(ctx context.Context, cmd command.Command) (*RawResponse, error) {
+	if err := command.Validate(cmd); err != nil {
+		return nil, err
+	}
+	message := cmd.BuildMessage()
+	verb := commandVerb(message)
+	if c.logCommands {
+		c.log(LevelDebug, "Sending command", "command", verb, "message", redactCommand(message))
+	}
+	ctx, span := c.startCommandSpan(ctx, verb, message)
+	start := time.Now()
+	response, err := c.sendCommand(ctx, cmd, message, span)
+	c.observer.CommandSent(verb, time.Since(start), err)
+	c.endCommandSpan(span, message, response, err)
+	return response, err
+}
+
+func (c *Conn) sendCommand(ctx context.Context, cmd command.Command, message string, span Span) (*RawResponse, error) {
 	if linger, ok := cmd.(command.Linger); ok {
 		c.writeLock.Lock()
 		if linger.Enabled {
@@ -135,13 +276,25 @@ func (c *Conn) SendCommand(ctx context.Context, cmd command.Command) (*RawRespon
 		c.writeLock.Unlock()
 	}
 
+	// Queue the reply before writing so replies, which FreeSWITCH sends in command order, are always matched to the right command
+	reply := make(chan *RawResponse, 1)
+	pending := &pendingCommand{reply: reply, message: message, span: span}
 	deadline, ok := ctx.Deadline()
 	c.writeLock.Lock()
+	if !c.pushPending(pending) {
+		c.writeLock.Unlock()
+		return nil, errors.New("connection closed")
+	}
 	if ok {
 		_ = c.conn.SetWriteDeadline(deadline)
 	}
-	_, err := c.conn.Write([]byte(cmd.BuildMessage() + EndOfMessage))
+	if c.recorder != nil {
+		// Recorded before writing so the command always comes before its reply in the recording
+		c.recorder.recordSent(message)
+	}
+	_, err := c.conn.Write([]byte(message + EndOfMessage))
 	if err != nil {
+		c.removePending(pending)
 		c.writeLock.Unlock()
 		return nil, err
 	}
@@ -151,26 +304,77 @@ func (c *Conn) SendCommand(ctx context.Context, cmd command.Command) (*RawRespon
 	c.writeLock.Unlock()
 
 	// Get response
-	c.responseChanMutex.RLock()
-	defer c.responseChanMutex.RUnlock()
 	select {
-	case response, ok := <-c.responseChannels[TypeReply]:
-		if !ok || response == nil {
-			// We only get nil here if the channel is closed
-			return nil, errors.New("connection closed")
-		}
-		return response, nil
-	case response, ok := <-c.responseChannels[TypeAPIResponse]:
+	case response, ok := <-reply:
 		if !ok || response == nil {
 			// We only get nil here if the channel is closed
 			return nil, errors.New("connection closed")
 		}
 		return response, nil
 	case <-ctx.Done():
+		// The reply stays queued so the replies to later commands are still matched correctly, it is discarded when it arrives
 		return nil, ctx.Err()
 	}
 }
 
+// A command written to FreeSWITCH and waiting for its reply
+type pendingCommand struct {
+	reply   chan *RawResponse
+	message string
+	span    Span // The span of the command when tracing, linked to the events of the command once the reply arrives
+}
+
+// Adds a command to the end of the pending queue, returns false if the connection no longer accepts commands
+func (c *Conn) pushPending(command *pendingCommand) bool {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	if c.pendingClosed {
+		return false
+	}
+	c.pending = append(c.pending, command)
+	return true
+}
+
+// Removes a command from the pending queue, used when the command was never written
+func (c *Conn) removePending(command *pendingCommand) {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	for i, pending := range c.pending {
+		if pending == command {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// Hands a reply to the oldest command still waiting for one
+func (c *Conn) popPending(response *RawResponse) bool {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	if len(c.pending) == 0 {
+		return false
+	}
+	pending := c.pending[0]
+	c.pending[0] = nil
+	c.pending = c.pending[1:]
+	// Linked before the next message is read, so the events caused by the command always find its span
+	c.linkCommandSpan(pending.span, pending.message, response)
+	// Buffered so this never blocks, even if the caller already gave up waiting
+	pending.reply <- response
+	return true
+}
+
+// Fails every command still waiting for a reply and stops accepting new ones, no more replies can arrive
+func (c *Conn) closePending() {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	c.pendingClosed = true
+	for _, pending := range c.pending {
+		close(pending.reply)
+	}
+	c.pending = nil
+}
+
 // ExitAndClose - Attempt to gracefully send FreeSWITCH "exit" over the ESL connection before closing our connection and stopping. Protected by a sync.Once
 func (c *Conn) ExitAndClose() {
 	c.closeOnce.Do(func() {
@@ -187,9 +391,18 @@ func (c *Conn) Close() {
 	c.closeOnce.Do(c.close)
 }
 
+// Closes the connection reporting err to the observer instead of the receive error caused by closing it
+func (c *Conn) closeWithError(err error) {
+	c.pendingLock.Lock()
+	c.closeErr = err
+	c.pendingLock.Unlock()
+	c.Close()
+}
+
 func (c *Conn) close() {
 	// Allow users to do anything they need to do before we tear everything down
 	c.stopFunc()
+	c.closePending()
 	c.responseChanMutex.Lock()
 	defer c.responseChanMutex.Unlock()
 	for key, responseChan := range c.responseChannels {
@@ -199,6 +412,27 @@ func (c *Conn) close() {
 
 	// Close the connection only after we have the response channel lock and we have deleted all response channels to ensure we don't receive on a closed channel
 	_ = c.conn.Close()
+	if c.recorder != nil && c.recorder.closeWithConn {
+		_ = c.recorder.Close()
+	}
+
+	c.pendingLock.Lock()
+	err := c.disconnectErr
+	if c.closeErr != nil {
+		err = c.closeErr
+	}
+	c.pendingLock.Unlock()
+	c.observer.Disconnected(err)
+	if c.hooks.OnClosed != nil {
+		c.hooks.OnClosed(c, err)
+	}
+}
+
+// Returns the response channel for the content type, nil once the connection has been closed
+func (c *Conn) responseChannel(contentType string) chan *RawResponse {
+	c.responseChanMutex.RLock()
+	defer c.responseChanMutex.RUnlock()
+	return c.responseChannels[contentType]
 }
 
 func (c *Conn) callEventListener(event *Event) {
@@ -208,7 +442,7 @@ func (c *Conn) callEventListener(event *Event) {
 	// First check if there are any general event listener
 	if listeners, ok := c.eventListeners[EventListenAll]; ok {
 		for _, listener := range listeners {
-			go listener(event)
+			go c.runListener(listener, event)
 		}
 	}
 
@@ -217,7 +451,7 @@ func (c *Conn) callEventListener(event *Event) {
 		channelUUID := event.GetHeader("Unique-Id")
 		if listeners, ok := c.eventListeners[channelUUID]; ok {
 			for _, listener := range listeners {
-				go listener(event)
+				go c.runListener(listener, event)
 			}
 		}
 	}
@@ -227,7 +461,7 @@ func (c *Conn) callEventListener(event *Event) {
 		appUUID := event.GetHeader("Application-UUID")
 		if listeners, ok := c.eventListeners[appUUID]; ok {
 			for _, listener := range listeners {
-				go listener(event)
+				go c.runListener(listener, event)
 			}
 		}
 	}
@@ -237,10 +471,27 @@ func (c *Conn) callEventListener(event *Event) {
 		jobUUID := event.GetHeader("Job-UUID")
 		if listeners, ok := c.eventListeners[jobUUID]; ok {
 			for _, listener := range listeners {
-				go listener(event)
+				go c.runListener(listener, event)
 			}
 		}
 	}
+
+	// Finally any matcher listeners indexed by this event name and those that need to see every event
+	c.callMatchListeners(c.matchListenerIndex[event.GetName()], event)
+	c.callMatchListeners(c.matchListenerIndex[""], event)
+}
+
+func (c *Conn) callMatchListeners(listeners map[string]*matchListener, event *Event) {
+	for _, registered := range listeners {
+		if !registered.matcher.Match(event) {
+			continue
+		}
+		if registered.inline {
+			registered.listener(event)
+		} else {
+			go c.runListener(registered.listener, event)
+		}
+	}
 }
 
 func (c *Conn) eventLoop() {
@@ -277,34 +528,45 @@ func (c *Conn) eventLoop() {
 		c.responseChanMutex.RUnlock()
 
 		if err != nil {
-			c.logger.Warn("Error parsing event\n%s\n", err.Error())
+			c.log(LevelWarn, "Error parsing event", "error", err)
 			continue
 		}
+		c.observer.EventReceived(event.GetName())
+		c.traceEvent(event)
 
 		c.callEventListener(event)
 	}
 }
 
 func (c *Conn) receiveLoop() {
+	// Once we stop receiving no command can get its reply
+	defer c.closePending()
 	for c.runningContext.Err() == nil {
 		err := c.doMessage()
 		if err != nil {
-			c.logger.Warn("Error receiving message: %s\n", err.Error())
+			c.pendingLock.Lock()
+			c.disconnectErr = err
+			c.pendingLock.Unlock()
+			c.log(LevelWarn, "Error receiving message", "error", err)
 			// when err.Error() is EOF we should trigger event to responseChannel and exit the loop
 			// because the connection is closed
 			if err.Error() == "EOF" {
 				// send signal to c.responseChannels[TypeDisconnect]
-				c.logger.Warn("Connection closed, stopping receive loop\n")
-				select {
-				case c.responseChannels[TypeDisconnect] <- &RawResponse{
-					Headers: textproto.MIMEHeader{
-						"Content-Type": []string{TypeDisconnect},
-						"Error":        []string{err.Error()},
-					},
-					Body: []byte("connection closed: " + err.Error()),
-				}:
-				default:
+				c.log(LevelWarn, "Connection closed, stopping receive loop")
+				c.responseChanMutex.RLock()
+				if disconnect, ok := c.responseChannels[TypeDisconnect]; ok {
+					select {
+					case disconnect <- &RawResponse{
+						Headers: textproto.MIMEHeader{
+							"Content-Type": []string{TypeDisconnect},
+							"Error":        []string{err.Error()},
+						},
+						Body: []byte("connection closed: " + err.Error()),
+					}:
+					default:
+					}
 				}
+				c.responseChanMutex.RUnlock()
 				return
 			}
 			break
@@ -318,6 +580,19 @@ func (c *Conn) doMessage() error {
 		return err
 	}
 
+	// Replies are matched to commands in the order they were sent
+	if contentType := response.GetHeader("Content-Type"); contentType == TypeReply || contentType == TypeAPIResponse {
+		c.observer.ReplyReceived(contentType, response.IsOk())
+		if !c.popPending(response) {
+			c.log(LevelWarn, "Received a reply with no pending command", "reply", response.GetReply())
+		}
+		return nil
+	}
+	if response.GetHeader("Content-Type") == TypeLogData {
+		c.callLogListeners(response)
+		return nil
+	}
+
 	c.responseChanMutex.RLock()
 	defer c.responseChanMutex.RUnlock()
 	responseChan, ok := c.responseChannels[response.GetHeader("Content-Type")]
@@ -339,7 +614,7 @@ func (c *Conn) doMessage() error {
 			return c.runningContext.Err()
 		case <-ctx.Done():
 			// Do not return an error since this is not fatal but log since it could be a indication of problems
-			c.logger.Warn("No one to handle response\nIs the connection overloaded or stopping?\n%v\n\n", response)
+			c.log(LevelWarn, "No one to handle response, is the connection overloaded or stopping?", "content_type", response.GetHeader("Content-Type"))
 		}
 	} else {
 		return errors.New("no response channel for Content-Type: " + response.GetHeader("Content-Type"))
@@ -13,7 +13,12 @@ package eslgo
 import (
     "bufio"
     "context"
+    "errors"
+    "fmt"
     "net"
+    "net/textproto"
+    "strconv"
+    "strings"
     "sync"
     "testing"
     "time"
@@ -57,3 +62,99 @@ func TestConn_SendCommand(t *testing.T) {
 	assert.Nil(t, err)
 	wait.Wait()
 }
+
+func TestConn_SendCommand_Pipelined(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	// Replies carry the argument of the command so we can check every caller got the reply to its own command
+	server, connection := newFakeServer(false, opts, func(cmd string) string {
+		fields := strings.Fields(cmd)
+		if fields[0] == "bgapi" {
+			return "Content-Type: command/reply\r\nReply-Text: +OK " + fields[2] + "\r\n\r\n"
+		}
+		return fmt.Sprintf("Content-Type: api/response\r\nContent-Length: %d\r\n\r\n%s", len(fields[2]), fields[2])
+	})
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 5000; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			response, err := connection.SendCommand(ctx, command.API{
+				Command:    "echo",
+				Arguments:  strconv.Itoa(i),
+				Background: i%2 == 0,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+			if i%2 == 0 {
+				assert.Equal(t, TypeReply, response.GetHeader("Content-Type"))
+				assert.Equal(t, "+OK "+strconv.Itoa(i), response.GetReply())
+			} else {
+				assert.Equal(t, TypeAPIResponse, response.GetHeader("Content-Type"))
+				assert.Equal(t, strconv.Itoa(i), string(response.Body))
+			}
+		}(i)
+	}
+	wait.Wait()
+}
+
+func TestConn_SendCommand_Cancelled(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	replies := make(chan string)
+	server, connection := newFakeServer(false, opts, func(cmd string) string {
+		return <-replies
+	})
+	defer server.close()
+	defer connection.Close()
+
+	// The first caller gives up before its reply arrives, the second must not receive the late reply
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := connection.SendCommand(cancelled, command.API{Command: "echo", Arguments: "first"})
+	assert.Equal(t, context.Canceled, err)
+	replies <- "Content-Type: api/response\r\nContent-Length: 5\r\n\r\nfirst"
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelCtx()
+	go func() {
+		replies <- "Content-Type: api/response\r\nContent-Length: 6\r\n\r\nsecond"
+	}()
+	response, err := connection.SendCommand(ctx, command.API{Command: "echo", Arguments: "second"})
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(response.Body))
+
+	// Commands waiting when the connection closes fail instead of hanging
+	go connection.Close()
+	_, err = connection.SendCommand(ctx, command.API{Command: "echo", Arguments: "third"})
+	assert.NotNil(t, err)
+}
+
+func TestConn_SendCommand_Invalid(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The command would inject a second one, it is rejected before anything is written
+	_, err := connection.SendCommand(ctx, command.API{Command: "status\r\n\r\napi", Arguments: "shutdown"})
+	assert.True(t, errors.Is(err, command.ErrInvalidCommand))
+	_, err = connection.SendCommand(ctx, &command.SendEvent{Name: "CUSTOM", Headers: textproto.MIMEHeader{"Event-Subclass": {"a\r\n\r\nexit"}}})
+	assert.True(t, errors.Is(err, command.ErrInvalidCommand))
+	assert.Empty(t, server.received())
+
+	// Valid commands still go through on the same connection
+	_, err = connection.SendCommand(ctx, &command.SendEvent{Name: "CUSTOM"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sendevent CUSTOM"}, server.received())
+}
@@ -0,0 +1,551 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Package esltest provides an in-process fake FreeSWITCH event socket for testing code built on eslgo without a real switch.
+package esltest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+// APIHandler - Handles an api or bgapi command, returns the response body
+type APIHandler func(args string) string
+
+// CommandHandler - Handles a raw command such as sendmsg, returns the Reply-Text of the command/reply
+type CommandHandler func(command string) string
+
+// Server - A fake FreeSWITCH inbound event socket listening on a local TCP port. It authenticates clients, answers api/bgapi
+// commands with registered handlers, tracks event subscriptions and filters per connection and can push events and log lines.
+type Server struct {
+	Password string
+
+	listener net.Listener
+	lock     sync.Mutex
+	apis     map[string]APIHandler
+	handlers map[string]CommandHandler
+	conns    map[*serverConn]bool
+	commands []string
+	wait     sync.WaitGroup
+}
+
+// Event - An event pushed to clients by the Server
+type Event struct {
+	Headers map[string]string
+	Body    string
+}
+
+type serverConn struct {
+	server    *Server
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	format    string
+	all       bool
+	events    map[string]bool
+	excluded  map[string]bool // Removed with nixevent while all was set, FreeSWITCH then lists every other event instead
+	filters   map[string][]string
+	logLevel  int
+}
+
+// NewServer - Starts a fake server on a random local port that accepts the provided password
+func NewServer(password string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		Password: password,
+		listener: listener,
+		apis:     make(map[string]APIHandler),
+		handlers: make(map[string]CommandHandler),
+		conns:    make(map[*serverConn]bool),
+	}
+	server.HandleAPI("status", func(string) string {
+		return "UP 0 years, 0 days, 0 hours, 0 minutes, 1 second\nFreeSWITCH (Version 1.10.0) is ready\n0 session(s) since startup\n"
+	})
+	server.HandleAPI("echo", func(args string) string {
+		return args
+	})
+	server.wait.Add(1)
+	go server.acceptLoop()
+	return server, nil
+}
+
+// Addr - The address clients should dial
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// HandleAPI - Registers the handler for api and bgapi commands with the name, e.g. "status"
+func (s *Server) HandleAPI(name string, handler APIHandler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.apis[name] = handler
+}
+
+// HandleCommand - Registers the handler for a command verb other than api and bgapi, e.g. "sendmsg"
+func (s *Server) HandleCommand(verb string, handler CommandHandler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[verb] = handler
+}
+
+// Commands - Returns every command received so far, auth commands included
+func (s *Server) Commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Connections - Returns the number of connected clients
+func (s *Server) Connections() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+// SendEvent - Sends the event to every connected client subscribed to it whose filters accept it
+func (s *Server) SendEvent(event Event) {
+	for _, conn := range s.connections() {
+		if conn.wants(event) {
+			_ = conn.sendEvent(event)
+		}
+	}
+}
+
+// SendLog - Sends a log line to every connected client that enabled logs at the level or higher, levels are 0 (console) to 7 (debug)
+func (s *Server) SendLog(level int, text string) {
+	for _, conn := range s.connections() {
+		conn.lock.Lock()
+		wanted := conn.logLevel >= level
+		conn.lock.Unlock()
+		if wanted {
+			_ = conn.write(fmt.Sprintf("Content-Type: log/data\nContent-Length: %d\nLog-Level: %d\nText-Channel: 3\nLog-File: esltest.c\nLog-Func: esltest\nLog-Line: 1\nUser-Data: \n\n%s", len(text), level, text))
+		}
+	}
+}
+
+// Disconnect - Sends a disconnect notice to every client and closes their connections
+func (s *Server) Disconnect() {
+	for _, conn := range s.connections() {
+		conn.disconnect()
+	}
+}
+
+// Close - Stops accepting clients, disconnects every client and waits for them to finish
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	for _, conn := range s.connections() {
+		_ = conn.conn.Close()
+	}
+	s.wait.Wait()
+	return err
+}
+
+func (s *Server) connections() []*serverConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wait.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		conn := &serverConn{
+			server:   s,
+			conn:     c,
+			reader:   bufio.NewReader(c),
+			format:   "plain",
+			events:   make(map[string]bool),
+			excluded: make(map[string]bool),
+			filters:  make(map[string][]string),
+			logLevel: -1,
+		}
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.lock.Unlock()
+		s.wait.Add(1)
+		go conn.serve()
+	}
+}
+
+func (c *serverConn) serve() {
+	defer c.server.wait.Done()
+	defer func() {
+		c.server.lock.Lock()
+		delete(c.server.conns, c)
+		c.server.lock.Unlock()
+		_ = c.conn.Close()
+	}()
+
+	if c.write("Content-Type: auth/request\n\n") != nil {
+		return
+	}
+	authenticated := false
+	for {
+		command, err := readCommand(c.reader)
+		if err != nil {
+			return
+		}
+		c.server.lock.Lock()
+		c.server.commands = append(c.server.commands, command)
+		c.server.lock.Unlock()
+
+		verb, args := splitCommand(command)
+		if !authenticated {
+			if verb == "auth" && args == c.server.Password {
+				authenticated = true
+				_ = c.reply("+OK accepted")
+				continue
+			}
+			_ = c.reply("-ERR invalid")
+			c.disconnect()
+			return
+		}
+		if verb == "exit" {
+			_ = c.reply("+OK bye")
+			c.disconnect()
+			return
+		}
+		if err := c.handle(verb, args, command); err != nil {
+			return
+		}
+	}
+}
+
+func (c *serverConn) handle(verb, args, command string) error {
+	switch verb {
+	case "api":
+		name, apiArgs := splitCommand(args)
+		body := c.server.callAPI(name, apiArgs)
+		return c.write(fmt.Sprintf("Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body))
+	case "bgapi":
+		jobUUID := eslgo.NewUUID()
+		name, apiArgs := splitCommand(args)
+		if err := c.write("Content-Type: command/reply\nReply-Text: +OK Job-UUID: " + jobUUID + "\nJob-UUID: " + jobUUID + "\n\n"); err != nil {
+			return err
+		}
+		go c.server.SendEvent(Event{
+			Headers: map[string]string{
+				"Event-Name":      "BACKGROUND_JOB",
+				"Job-UUID":        jobUUID,
+				"Job-Command":     name,
+				"Job-Command-Arg": apiArgs,
+			},
+			Body: c.server.callAPI(name, apiArgs),
+		})
+		return nil
+	case "event", "myevents":
+		c.subscribe(verb, args)
+		return c.reply("+OK event listener enabled " + c.format)
+	case "nixevent":
+		c.unsubscribe(args)
+		return c.reply("+OK events removed")
+	case "noevents":
+		c.lock.Lock()
+		c.all = false
+		c.events = make(map[string]bool)
+		c.excluded = make(map[string]bool)
+		c.lock.Unlock()
+		return c.reply("+OK no longer listening for events")
+	case "filter":
+		return c.reply(c.filter(args))
+	case "log":
+		level, err := strconv.Atoi(strings.TrimSpace(args))
+		if err != nil {
+			level = 7
+		}
+		c.lock.Lock()
+		c.logLevel = level
+		c.lock.Unlock()
+		return c.reply(fmt.Sprintf("+OK log level %d [%d]", level, level))
+	case "nolog":
+		c.lock.Lock()
+		c.logLevel = -1
+		c.lock.Unlock()
+		return c.reply("+OK no longer logging")
+	}
+
+	c.server.lock.Lock()
+	handler, ok := c.server.handlers[verb]
+	c.server.lock.Unlock()
+	if ok {
+		return c.reply(handler(command))
+	}
+	if verb == "sendevent" {
+		event := parseSendEvent(args, command)
+		go c.server.SendEvent(event)
+		return c.reply("+OK " + event.Headers["Event-UUID"])
+	}
+	if verb == "sendmsg" || verb == "linger" || verb == "nolinger" || verb == "divert_events" {
+		return c.reply("+OK")
+	}
+	return c.reply("-ERR command not found")
+}
+
+// Builds the event fired by a sendevent command, like FreeSWITCH it gets an Event-UUID
+func parseSendEvent(name, command string) Event {
+	event := Event{Headers: map[string]string{"Event-Name": strings.TrimSpace(name), "Event-UUID": eslgo.NewUUID()}}
+	parts := strings.SplitN(command, "\n\n", 2)
+	for _, line := range strings.Split(parts[0], "\n")[1:] {
+		pair := strings.SplitN(line, ":", 2)
+		if len(pair) != 2 || textproto.CanonicalMIMEHeaderKey(pair[0]) == "Content-Length" {
+			continue
+		}
+		event.Headers[pair[0]] = strings.TrimSpace(pair[1])
+	}
+	if len(parts) == 2 {
+		event.Body = parts[1]
+	}
+	return event
+}
+
+func (s *Server) callAPI(name, args string) string {
+	s.lock.Lock()
+	handler, ok := s.apis[name]
+	s.lock.Unlock()
+	if !ok {
+		return "-ERR " + name + " Command not found!\n"
+	}
+	return handler(args)
+}
+
+func (c *serverConn) subscribe(verb, args string) {
+	fields := strings.Fields(args)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(fields) > 0 && isFormat(fields[0]) {
+		c.format = fields[0]
+		fields = fields[1:]
+	}
+	if verb == "myevents" {
+		c.all = true
+		c.excluded = make(map[string]bool)
+		return
+	}
+	for _, name := range fields {
+		if strings.EqualFold(name, "all") {
+			c.all = true
+			c.excluded = make(map[string]bool)
+			continue
+		}
+		c.events[name] = true
+		delete(c.excluded, name)
+	}
+}
+
+func (c *serverConn) unsubscribe(args string) {
+	fields := strings.Fields(args)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(fields) > 0 && isFormat(fields[0]) {
+		fields = fields[1:]
+	}
+	for _, name := range fields {
+		if strings.EqualFold(name, "all") {
+			c.all = false
+			c.events = make(map[string]bool)
+			c.excluded = make(map[string]bool)
+			continue
+		}
+		delete(c.events, name)
+		if c.all {
+			// Like FreeSWITCH, ALL is replaced by the list of every event but the removed ones
+			c.excluded[name] = true
+		}
+	}
+}
+
+func (c *serverConn) filter(args string) string {
+	fields := strings.Fields(args)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(fields) > 0 && fields[0] == "delete" {
+		switch len(fields) {
+		case 2:
+			delete(c.filters, textproto.CanonicalMIMEHeaderKey(fields[1]))
+		case 3:
+			header := textproto.CanonicalMIMEHeaderKey(fields[1])
+			var kept []string
+			for _, value := range c.filters[header] {
+				if value != fields[2] {
+					kept = append(kept, value)
+				}
+			}
+			c.filters[header] = kept
+		default:
+			return "-ERR invalid syntax"
+		}
+		return "+OK filter deleted."
+	}
+	if len(fields) < 2 {
+		return "-ERR invalid syntax"
+	}
+	header := textproto.CanonicalMIMEHeaderKey(fields[0])
+	c.filters[header] = append(c.filters[header], strings.Join(fields[1:], " "))
+	return fmt.Sprintf("+OK filter added. [%s]=[%s]", fields[0], strings.Join(fields[1:], " "))
+}
+
+func isFormat(word string) bool {
+	return word == "plain" || word == "json" || word == "xml"
+}
+
+// Reports if the connection subscribed to the event and its filters accept it
+func (c *serverConn) wants(event Event) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	name := event.Headers["Event-Name"]
+	all := c.all && !c.excluded[name]
+	subscribed := all || c.events[name]
+	if name == "CUSTOM" && !all {
+		// Like FreeSWITCH, CUSTOM events with a subclass are only sent when the subclass was listed after CUSTOM
+		subclass := event.Headers["Event-Subclass"]
+		subscribed = c.events[name] && (subclass == "" || c.events[subclass])
+	}
+	if !subscribed {
+		return false
+	}
+	filtered := false
+	for header, values := range c.filters {
+		if len(values) == 0 {
+			continue
+		}
+		filtered = true
+		for key, value := range event.Headers {
+			if textproto.CanonicalMIMEHeaderKey(key) != header {
+				continue
+			}
+			for _, allowed := range values {
+				if allowed == value {
+					return true
+				}
+			}
+		}
+	}
+	return !filtered
+}
+
+func (c *serverConn) sendEvent(event Event) error {
+	c.lock.Lock()
+	format := c.format
+	c.lock.Unlock()
+
+	var contentType string
+	switch format {
+	case "json":
+		contentType = "text/event-json"
+	case "xml":
+		contentType = "text/event-xml"
+	default:
+		contentType = "text/event-plain"
+	}
+	body, err := event.toEvent().Encode(format)
+	if err != nil {
+		return err
+	}
+	return c.write(fmt.Sprintf("Content-Length: %d\nContent-Type: %s\n\n%s", len(body), contentType, body))
+}
+
+// EncodePlainEvent - Encodes an event in the text/event-plain format, Event-Name first and then the other headers sorted with URL encoded values
+func EncodePlainEvent(event Event) string {
+	return string(event.toEvent().EncodePlain())
+}
+
+// Converts to an eslgo event, which holds header values URL encoded like they are received
+func (event Event) toEvent() eslgo.Event {
+	headers := make(textproto.MIMEHeader, len(event.Headers))
+	for key, value := range event.Headers {
+		headers[key] = []string{url.PathEscape(value)}
+	}
+	return eslgo.Event{Headers: headers, Body: []byte(event.Body)}
+}
+
+func (c *serverConn) reply(text string) error {
+	return c.write("Content-Type: command/reply\nReply-Text: " + text + "\n\n")
+}
+
+func (c *serverConn) disconnect() {
+	text := "Disconnected, goodbye.\nSee you at ClueCon! http://www.cluecon.com/\n"
+	_ = c.write(fmt.Sprintf("Content-Type: text/disconnect-notice\nContent-Length: %d\n\n%s", len(text), text))
+	_ = c.conn.Close()
+}
+
+func (c *serverConn) write(data string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write([]byte(data))
+	return err
+}
+
+// Reads a single command including the body of commands that set a Content-Length, header lines are joined with \n
+func readCommand(reader *bufio.Reader) (string, error) {
+	var lines []string
+	length := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(lines) == 0 {
+				// Skip the terminator sent after a command body
+				continue
+			}
+			break
+		}
+		lines = append(lines, line)
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && textproto.CanonicalMIMEHeaderKey(parts[0]) == "Content-Length" {
+			length, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	}
+	command := strings.Join(lines, "\n")
+	if length > 0 {
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return "", err
+		}
+		command += "\n\n" + string(body)
+	}
+	return command, nil
+}
+
+func splitCommand(command string) (string, string) {
+	firstLine := command
+	if index := strings.Index(command, "\n"); index >= 0 {
+		firstLine = command[:index]
+	}
+	parts := strings.SplitN(firstLine, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
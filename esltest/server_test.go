@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package esltest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	server, err := esltest.NewServer("secret")
+	require.NoError(t, err)
+	defer server.Close()
+	server.HandleAPI("uuid_exists", func(args string) string {
+		if args == "abc" {
+			return "true"
+		}
+		return "false"
+	})
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	opts.Password = "secret"
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	defer conn.ExitAndClose()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := conn.SendCommand(ctx, command.API{Command: "uuid_exists", Arguments: "abc"})
+	require.NoError(t, err)
+	assert.Equal(t, "true", string(response.Body))
+	response, err = conn.SendCommand(ctx, command.API{Command: "nosuch"})
+	require.NoError(t, err)
+	assert.Equal(t, "-ERR nosuch Command not found!\n", string(response.Body))
+
+	// Events are only sent to connections subscribed to them
+	events, stop := conn.Subscribe(ctx, nil)
+	defer stop()
+	require.NoError(t, conn.EnableEvents(ctx))
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CUSTOM", "Event-Subclass": "test::event", "Note": "a b/c"}, Body: "body"})
+	select {
+	case event := <-events:
+		assert.Equal(t, "CUSTOM", event.GetName())
+		assert.Equal(t, "a b/c", event.GetHeader("Note"))
+		assert.Equal(t, "body", string(event.Body))
+	case <-ctx.Done():
+		t.Fatal("event not received")
+	}
+
+	// Background jobs answer with a BACKGROUND_JOB event
+	response, err = conn.SendCommand(ctx, command.API{Command: "uuid_exists", Arguments: "def", Background: true})
+	require.NoError(t, err)
+	select {
+	case event := <-events:
+		assert.Equal(t, "BACKGROUND_JOB", event.GetName())
+		assert.Equal(t, response.GetHeader("Job-UUID"), event.GetHeader("Job-UUID"))
+		assert.Equal(t, "false", string(event.Body))
+	case <-ctx.Done():
+		t.Fatal("job result not received")
+	}
+	assert.Equal(t, "auth secret", server.Commands()[0])
+	assert.Equal(t, 1, server.Connections())
+}
+
+func TestServer_WrongPassword(t *testing.T) {
+	server, err := esltest.NewServer("secret")
+	require.NoError(t, err)
+	defer server.Close()
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	opts.ExitTimeout = 10 * time.Millisecond
+	opts.Password = "wrong"
+	_, err = opts.Dial(server.Addr())
+	assert.Error(t, err)
+}
+
+func TestServer_EventFormats(t *testing.T) {
+	server, err := esltest.NewServer("ClueCon")
+	require.NoError(t, err)
+	defer server.Close()
+
+	for _, format := range []string{"plain", "json", "xml"} {
+		t.Run(format, func(t *testing.T) {
+			opts := eslgo.DefaultInboundOptions
+			opts.Logger = eslgo.NilLogger{}
+			conn, err := opts.Dial(server.Addr())
+			require.NoError(t, err)
+			defer conn.ExitAndClose()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			events, stop := conn.Subscribe(ctx, eslgo.MatchSubclass("test::format"))
+			defer stop()
+			_, err = conn.SendCommand(ctx, command.Event{Format: format, Listen: []string{"CUSTOM", "test::format"}})
+			require.NoError(t, err)
+
+			// Events fired with sendevent reach the subscribed connections
+			require.NoError(t, conn.FireCustomEvent(ctx, "test::format", map[string]string{"Note": "a b&<c>"}, "body"))
+			select {
+			case event := <-events:
+				assert.Equal(t, "CUSTOM", event.GetName())
+				assert.Equal(t, "a b&<c>", event.GetHeader("Note"))
+				assert.NotEmpty(t, event.GetHeader("Event-UUID"))
+				assert.Equal(t, "body", string(event.Body))
+			case <-ctx.Done():
+				t.Fatal("event not received")
+			}
+		})
+	}
+}
+
+func TestServer_NixeventAfterAll(t *testing.T) {
+	server, err := esltest.NewServer("ClueCon")
+	require.NoError(t, err)
+	defer server.Close()
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	defer conn.ExitAndClose()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, stop := conn.Subscribe(ctx, nil)
+	defer stop()
+	require.NoError(t, conn.EnableEvents(ctx))
+
+	// Removing an event while every event is enabled only drops that event
+	_, err = conn.SendCommand(ctx, command.Event{Ignore: true, Format: "plain", Listen: []string{"CHANNEL_ANSWER"}})
+	require.NoError(t, err)
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_ANSWER"}})
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_HANGUP"}})
+	select {
+	case event := <-events:
+		assert.Equal(t, "CHANNEL_HANGUP", event.GetName())
+	case <-ctx.Done():
+		t.Fatal("event not received")
+	}
+}
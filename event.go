@@ -13,12 +13,17 @@ package eslgo
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+
+	"github.com/shuguocloud/eslgo/command/call"
 )
 
 type EventListener func(event *Event)
@@ -59,20 +64,72 @@ func readPlainEvent(body []byte) (*Event, error) {
 	return event, nil
 }
 
-// TODO: Needs processing
+// Reads an event in the text/event-xml format. Header values stay URL encoded like in the plain format
 func readXMLEvent(body []byte) (*Event, error) {
-	return &Event{
+	event := &Event{
 		Headers: make(textproto.MIMEHeader),
-		Body:    body,
-	}, nil
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var path []string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return event, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			path = append(path, token.Name.Local)
+			text.Reset()
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			switch {
+			case len(path) == 3 && path[1] == "headers":
+				event.Headers.Add(token.Name.Local, strings.TrimSpace(text.String()))
+			case len(path) == 2 && path[1] == "body":
+				event.Body = []byte(text.String())
+			}
+			path = path[:len(path)-1]
+			text.Reset()
+		}
+	}
+	if len(event.Headers) == 0 {
+		return event, errors.New("xml event without headers")
+	}
+	return event, nil
 }
 
-// TODO: Needs processing
+// Reads an event in the text/event-json format. Header values are URL encoded so GetHeader reads them like plain events,
+// array headers get a value per element and the _body field is the body
 func readJSONEvent(body []byte) (*Event, error) {
-	return &Event{
+	event := &Event{
 		Headers: make(textproto.MIMEHeader),
-		Body:    body,
-	}, nil
+	}
+	fields := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// Keeps numbers such as Event-Date-Timestamp as they were sent
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return event, err
+	}
+	for key, value := range fields {
+		if key == "_body" {
+			event.Body = []byte(fmt.Sprint(value))
+			continue
+		}
+		if values, ok := value.([]interface{}); ok {
+			for _, element := range values {
+				event.Headers.Add(key, url.PathEscape(fmt.Sprint(element)))
+			}
+			continue
+		}
+		event.Headers.Add(key, url.PathEscape(fmt.Sprint(value)))
+	}
+	return event, nil
 }
 
 // GetName Helper function that returns the event name header
@@ -92,6 +149,15 @@ func (e Event) GetHeader(header string) string {
 	return value
 }
 
+// GetHangupCause Helper function that returns the typed cause from the Hangup-Cause header, falling back to variable_hangup_cause
+func (e Event) GetHangupCause() call.HangupCause {
+	cause := e.GetHeader("Hangup-Cause")
+	if cause == "" {
+		cause = e.GetHeader("variable_hangup_cause")
+	}
+	return call.HangupCause(cause)
+}
+
 // String Implement the Stringer interface for pretty printing (%v)
 func (e Event) String() string {
 	var builder strings.Builder
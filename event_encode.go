@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Encode - Encodes the event in an ESL event format, plain, json or xml, the way FreeSWITCH sends it. Header values are
+// expected URL encoded as they are in received events, the output is read back by the connection into an equal event.
+func (e Event) Encode(format string) ([]byte, error) {
+	switch format {
+	case "plain", "":
+		return e.EncodePlain(), nil
+	case "json":
+		return e.EncodeJSON()
+	case "xml":
+		return e.EncodeXML()
+	}
+	return nil, errors.New("unknown event format " + format)
+}
+
+// EncodePlain - Encodes the event in the text/event-plain format, Event-Name first and the other headers sorted
+func (e Event) EncodePlain() []byte {
+	var buffer bytes.Buffer
+	for _, key := range e.sortedHeaders() {
+		for _, value := range e.Headers[key] {
+			buffer.WriteString(key + ": " + value + "\n")
+		}
+	}
+	if len(e.Body) > 0 {
+		buffer.WriteString("Content-Length: " + strconv.Itoa(len(e.Body)) + "\n\n")
+		buffer.Write(e.Body)
+	} else {
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes()
+}
+
+// EncodeJSON - Encodes the event in the text/event-json format, header values are decoded, headers with several values
+// become arrays and the body is the _body field
+func (e Event) EncodeJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(e.Headers)+2)
+	for _, key := range e.sortedHeaders() {
+		values := make([]string, len(e.Headers[key]))
+		for i, value := range e.Headers[key] {
+			values[i] = decodeHeaderValue(value)
+		}
+		if len(values) == 1 {
+			fields[key] = values[0]
+		} else {
+			fields[key] = values
+		}
+	}
+	if len(e.Body) > 0 {
+		fields["Content-Length"] = strconv.Itoa(len(e.Body))
+		fields["_body"] = string(e.Body)
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	// FreeSWITCH leaves <, > and & as they are
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fields); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// EncodeXML - Encodes the event in the text/event-xml format, header values stay URL encoded like FreeSWITCH sends them
+func (e Event) EncodeXML() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("<event>\n  <headers>\n")
+	keys := e.sortedHeaders()
+	for _, key := range keys {
+		for _, value := range e.Headers[key] {
+			if err := writeXMLElement(&buffer, "    ", key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(e.Body) > 0 {
+		if err := writeXMLElement(&buffer, "    ", "Content-Length", strconv.Itoa(len(e.Body))); err != nil {
+			return nil, err
+		}
+	}
+	buffer.WriteString("  </headers>\n")
+	if len(e.Body) > 0 {
+		if err := writeXMLElement(&buffer, "  ", "body", string(e.Body)); err != nil {
+			return nil, err
+		}
+	}
+	buffer.WriteString("</event>")
+	return buffer.Bytes(), nil
+}
+
+// Event-Name first and the other headers sorted, Content-Length is left out as the encoders set it from the body
+func (e Event) sortedHeaders() []string {
+	keys := make([]string, 0, len(e.Headers))
+	for key := range e.Headers {
+		canonical := textproto.CanonicalMIMEHeaderKey(key)
+		if canonical != "Event-Name" && canonical != "Content-Length" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for key := range e.Headers {
+		if textproto.CanonicalMIMEHeaderKey(key) == "Event-Name" {
+			keys = append([]string{key}, keys...)
+		}
+	}
+	return keys
+}
+
+func writeXMLElement(buffer *bytes.Buffer, indent, name, value string) error {
+	if !isXMLName(name) {
+		return errors.New("header " + strconv.Quote(name) + " is not a valid xml element name")
+	}
+	buffer.WriteString(indent + "<" + name + ">")
+	if err := xml.EscapeText(buffer, []byte(value)); err != nil {
+		return err
+	}
+	buffer.WriteString("</" + name + ">\n")
+	return nil
+}
+
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, char := range name {
+		letter := char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		if !letter && (i == 0 || !(char == '-' || char == '.' || (char >= '0' && char <= '9'))) {
+			return false
+		}
+	}
+	return true
+}
+
+// Header values of received events are URL encoded, values that are not are used as they are
+func decodeHeaderValue(value string) string {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -11,10 +11,18 @@
 package eslgo
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
+	"errors"
 	"net"
+	"net/textproto"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const TestEventToSend = "Content-Length: 483\r\nContent-Type: text/event-plain\r\n\r\nMessage-Account: sip%3A1006%4010.0.1.250\r\nEvent-Name: MESSAGE_QUERY\r\nCore-UUID: 2130a7d1-c1f7-44cd-8fae-8ed5946f3cec\r\nFreeSWITCH-Hostname: localhost.localdomain\r\nFreeSWITCH-IPv4: 10.0.1.250\r\nFreeSWITCH-IPv6: 127.0.0.1\r\nEvent-Date-Local: 2007-12-16%2022%3A29%3A59\r\nEvent-Date-GMT: Mon,%2017%20Dec%202007%2004%3A29%3A59%20GMT\r\nEvent-Date-timestamp: 1197865799573052\r\nEvent-Calling-File: sofia_reg.c\r\nEvent-Calling-Function: sofia_reg_handle_register\r\nEvent-Calling-Line-Number: 603\r\n\r\n"
@@ -39,3 +47,93 @@ func TestEvent_readPlainEvent(t *testing.T) {
 	assert.Nil(t, err)
 	wait.Wait()
 }
+
+func TestEvent_GetHangupCause(t *testing.T) {
+	event := Event{Headers: textproto.MIMEHeader{"Hangup-Cause": {"USER_BUSY"}}}
+	assert.Equal(t, call.CauseUserBusy, event.GetHangupCause())
+	assert.True(t, event.GetHangupCause().IsBusy())
+
+	event = Event{Headers: textproto.MIMEHeader{"Variable_hangup_cause": {"NO_ANSWER"}}}
+	assert.Equal(t, call.CauseNoAnswer, event.GetHangupCause())
+	assert.Equal(t, call.HangupCause(""), Event{Headers: textproto.MIMEHeader{}}.GetHangupCause())
+}
+
+func TestConn_Hangup_UnknownCause(t *testing.T) {
+	server, connection := newFakeServer(false, DefaultOptions, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connection.Hangup(ctx, "abc", "NORMAL_CLEARNING")
+	assert.True(t, errors.Is(err, command.ErrInvalidCommand))
+	assert.EqualError(t, err, `invalid command: unknown hangup cause "NORMAL_CLEARNING"`)
+	assert.Error(t, connection.HangupCall(ctx, "abc", "NORMAL_CLEARNING"))
+	assert.Empty(t, server.received())
+
+	_, err = connection.Hangup(ctx, "abc", call.CauseUserBusy)
+	assert.Nil(t, err)
+	if assert.Len(t, server.received(), 1) {
+		assert.Contains(t, server.received()[0], "Call-Command: hangup")
+		assert.Contains(t, server.received()[0], "Hangup-Cause: USER_BUSY")
+	}
+}
+
+func TestEvent_Encode(t *testing.T) {
+	event := &Event{
+		Headers: textproto.MIMEHeader{
+			"Event-Name":       {"CUSTOM"},
+			"Event-Subclass":   {"conference::maintenance"},
+			"Caller-Caller-Id": {"%2B1%20555%20%3C0100%3E"},
+			"Variable_list":    {"a", "b&c"},
+		},
+		Body: []byte("<hello> & goodbye\n"),
+	}
+
+	plain, err := event.Encode("plain")
+	require.NoError(t, err)
+	assert.Equal(t, "Event-Name: CUSTOM\nCaller-Caller-Id: %2B1%20555%20%3C0100%3E\nEvent-Subclass: conference::maintenance\nVariable_list: a\nVariable_list: b&c\nContent-Length: 18\n\n<hello> & goodbye\n", string(plain))
+	xmlData, err := event.Encode("xml")
+	require.NoError(t, err)
+	assert.Contains(t, string(xmlData), "<Caller-Caller-Id>%2B1%20555%20%3C0100%3E</Caller-Caller-Id>")
+	assert.Contains(t, string(xmlData), "<Variable_list>b&amp;c</Variable_list>")
+	assert.Contains(t, string(xmlData), "<body>&lt;hello&gt; &amp; goodbye&#xA;</body>")
+	jsonData, err := event.Encode("json")
+	require.NoError(t, err)
+	assert.Contains(t, string(jsonData), `"Caller-Caller-Id":"+1 555 <0100>"`)
+	assert.Contains(t, string(jsonData), `"Variable_list":["a","b&c"]`)
+
+	// Every format is read back into the same event
+	for format, read := range map[string]func([]byte) (*Event, error){"plain": readPlainEvent, "xml": readXMLEvent, "json": readJSONEvent} {
+		data, err := event.Encode(format)
+		require.NoError(t, err)
+		decoded, err := read(data)
+		require.NoError(t, err, format)
+		assert.Equal(t, "CUSTOM", decoded.GetName(), format)
+		assert.Equal(t, "conference::maintenance", decoded.GetHeader("Event-Subclass"), format)
+		assert.Equal(t, "+1 555 <0100>", decoded.GetHeader("Caller-Caller-Id"), format)
+		assert.Equal(t, []string{"a", "b&c"}, decoded.Headers["Variable_list"], format)
+		assert.Equal(t, "18", decoded.GetHeader("Content-Length"), format)
+		assert.Equal(t, event.Body, decoded.Body, format)
+		assert.Len(t, decoded.Headers, 5, format)
+	}
+
+	_, err = event.Encode("yaml")
+	assert.Error(t, err)
+	_, err = Event{Headers: textproto.MIMEHeader{"1st": {"x"}}}.EncodeXML()
+	assert.Error(t, err)
+}
+
+func TestEvent_readJSONEvent(t *testing.T) {
+	event, err := readJSONEvent([]byte(`{"Event-Name":"HEARTBEAT","Event-Date-Timestamp":1197865799573052,"Up-Time":"0 years, 1 day","Content-Length":"2","_body":"ok"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "HEARTBEAT", event.GetName())
+	assert.Equal(t, "1197865799573052", event.GetHeader("Event-Date-Timestamp"))
+	assert.Equal(t, "0 years, 1 day", event.GetHeader("Up-Time"))
+	assert.Equal(t, "ok", string(event.Body))
+
+	_, err = readJSONEvent([]byte(`{"Event-Name"`))
+	assert.Error(t, err)
+	_, err = readXMLEvent([]byte(`<event><headers></headers></event>`))
+	assert.Error(t, err)
+}
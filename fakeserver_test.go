@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeServer - A minimal stand in for the FreeSWITCH side of an ESL connection used by the tests
+type fakeServer struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	commands  []string
+	reply     func(command string) string
+}
+
+// newFakeServer - Creates a connection to a fake server that answers every command with the output of reply
+func newFakeServer(outbound bool, opts Options, reply func(command string) string) (*fakeServer, *Conn) {
+	fake, client := startFakeServer(reply)
+	return fake, newConnection(client, outbound, opts)
+}
+
+// startFakeServer - Starts a fake server and returns the client side of the network connection to it
+func startFakeServer(reply func(command string) string) (*fakeServer, net.Conn) {
+	server, client := net.Pipe()
+	if reply == nil {
+		reply = func(string) string {
+			return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+		}
+	}
+	fake := &fakeServer{
+		conn:   server,
+		reader: bufio.NewReader(server),
+		reply:  reply,
+	}
+	go fake.serve()
+	return fake, client
+}
+
+func (f *fakeServer) serve() {
+	for {
+		cmd, err := f.readCommand()
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.commands = append(f.commands, cmd)
+		f.lock.Unlock()
+		if err := f.write(f.reply(cmd)); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand - Reads a single command, including the body of commands like sendmsg that set a Content-Length
+func (f *fakeServer) readCommand() (string, error) {
+	var builder strings.Builder
+	length := 0
+	for {
+		line, err := f.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if builder.Len() == 0 {
+				// Skip the trailing terminator sent after a command body
+				continue
+			}
+			break
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(line)
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && textproto.CanonicalMIMEHeaderKey(parts[0]) == "Content-Length" {
+			length, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	}
+	if length > 0 {
+		body := make([]byte, length)
+		if _, err := io.ReadFull(f.reader, body); err != nil {
+			return "", err
+		}
+		builder.WriteString("\n\n")
+		builder.Write(body)
+	}
+	return builder.String(), nil
+}
+
+func (f *fakeServer) write(data string) error {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+	_, err := f.conn.Write([]byte(data))
+	return err
+}
+
+// sendEvent - Sends a plain event with the provided headers to the connection
+func (f *fakeServer) sendEvent(headers map[string]string) error {
+	var body strings.Builder
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		body.WriteString(key + ": " + headers[key] + "\n")
+	}
+	body.WriteString("\n")
+	return f.write("Content-Length: " + strconv.Itoa(body.Len()) + "\r\nContent-Type: text/event-plain\r\n\r\n" + body.String())
+}
+
+// received - Returns a copy of the commands received so far
+func (f *fakeServer) received() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeServer) close() {
+	_ = f.conn.Close()
+}
@@ -38,7 +38,11 @@ func (c *Conn) EnableEvents(ctx context.Context, format ...string) error {
 			Listen: []string{"all"},
 		})
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	// Managed subscriptions must not narrow or remove the events enabled here
+	return c.trackAllEvents(ctx)
 }
 
 // DebugEvents - A helper that will output all events to a logger
@@ -83,7 +87,7 @@ func (c *Conn) PlaybackAsync(ctx context.Context, uuid, appArgs string, times in
 	return c.audioCommand(ctx, "playback", uuid, appArgs, times, false)
 }
 
-// Say - Executes the mod_dptools say app
+// Say - Executes the mod_dptools say app, see SayWithOptions for typed arguments
 func (c *Conn) Say(ctx context.Context, uuid, appArgs string, times int) (*RawResponse, error) {
 	return c.audioCommand(ctx, "say", uuid, appArgs, times, true)
 }
@@ -93,7 +97,7 @@ func (c *Conn) SayAsync(ctx context.Context, uuid, appArgs string, times int) (*
 	return c.audioCommand(ctx, "say", uuid, appArgs, times, false)
 }
 
-// Speak - Executes the mod_dptools speak app
+// Speak - Executes the mod_dptools speak app, see SpeakWithOptions for typed arguments
 func (c *Conn) Speak(ctx context.Context, uuid, appArgs string, times int) (*RawResponse, error) {
 	return c.audioCommand(ctx, "speak", uuid, appArgs, times, true)
 }
@@ -113,13 +117,13 @@ func (c *Conn) ExecuteAsync(ctx context.Context, uuid, cmd, appArgs string) (*Ra
 	return c.executeCommand(ctx, cmd, uuid, appArgs, false)
 }
 
-// Hangup - Executes the mod_dptools hangup app
-func (c *Conn) Hangup(ctx context.Context, uuid, cause string) (*RawResponse, error) {
+// Hangup - Executes the mod_dptools hangup app, an empty cause hangs up with NORMAL_CLEARING
+func (c *Conn) Hangup(ctx context.Context, uuid string, cause call.HangupCause) (*RawResponse, error) {
 	return c.hangupCommand(ctx, uuid, cause, true)
 }
 
 // HangupAsync - Executes the mod_dptools hangup app with async mode
-func (c *Conn) HangupAsync(ctx context.Context, uuid, cause string) (*RawResponse, error) {
+func (c *Conn) HangupAsync(ctx context.Context, uuid string, cause call.HangupCause) (*RawResponse, error) {
 	return c.hangupCommand(ctx, uuid, cause, false)
 }
 
@@ -244,7 +248,7 @@ func (c *Conn) setCommand(ctx context.Context, uuid, key, value string, wait boo
 	return response, nil
 }
 
-func (c *Conn) hangupCommand(ctx context.Context, uuid, cause string, wait bool) (*RawResponse, error) {
+func (c *Conn) hangupCommand(ctx context.Context, uuid string, cause call.HangupCause, wait bool) (*RawResponse, error) {
 	response, err := c.SendCommand(ctx, call.Hangup{
 		UUID:  uuid,
 		Cause: cause,
@@ -254,7 +258,7 @@ func (c *Conn) hangupCommand(ctx context.Context, uuid, cause string, wait bool)
 		return response, err
 	}
 	if !response.IsOk() {
-		return response, errors.New("set response is not okay")
+		return response, errors.New("hangup response is not okay")
 	}
 	return response, nil
 }
@@ -0,0 +1,313 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/shuguocloud/eslgo/command/call"
+)
+
+// BridgeResult - The outcome of a bridge, PeerUUID is set when the channel was bridged and Cause when it was not
+type BridgeResult struct {
+	UUID     string
+	PeerUUID string
+	Cause    call.HangupCause
+}
+
+// Bridged - Reports if the channel was bridged
+func (r BridgeResult) Bridged() bool {
+	return r.PeerUUID != ""
+}
+
+// TransferLeg - Which legs of a bridged call uuid_transfer moves
+type TransferLeg string
+
+const (
+	TransferALeg     TransferLeg = ""
+	TransferBLeg     TransferLeg = "-bleg"
+	TransferBothLegs TransferLeg = "-both"
+)
+
+// TransferOptions - Used to configure Transfer and TransferInline
+type TransferOptions struct {
+	Leg      TransferLeg
+	Dialplan string // The dialplan to transfer to, FreeSWITCH uses XML when empty. Ignored by TransferInline
+	Context  string // The dialplan context to transfer to, FreeSWITCH uses the context of the channel when empty. Ignored by TransferInline
+}
+
+// Consultation - The consultation call of an attended transfer started with AttendedTransfer. UUID is the transferor and
+// PeerUUID the consulted party when the consultation was answered
+type Consultation struct {
+	BridgeResult
+	HeldUUID string // The party the transferor was bridged to, held during the consultation
+	conn     *Conn
+}
+
+// Bridge - Executes the mod_dptools bridge app on the channel, trying the legs in order until one answers. Waits for
+// the channel to be bridged, for the bridge to fail or for the channel to hang up. The events are subscribed to on the
+// connection for the duration of the call, see watchChannels.
+func (c *Conn) Bridge(ctx context.Context, uuid string, legs ...Leg) (BridgeResult, error) {
+	if len(legs) == 0 {
+		return BridgeResult{}, errors.New("no leg to bridge to")
+	}
+	dial := make([]string, len(legs))
+	for i, leg := range legs {
+		dial[i] = leg.String()
+	}
+
+	events, done, err := c.watchChannels(ctx, []string{"CHANNEL_BRIDGE", "CHANNEL_EXECUTE_COMPLETE", "CHANNEL_HANGUP"}, uuid)
+	if err != nil {
+		return BridgeResult{}, err
+	}
+	defer done()
+	if _, err := c.ExecuteAsync(ctx, uuid, "bridge", strings.Join(dial, "|")); err != nil {
+		return BridgeResult{}, err
+	}
+	return waitForBridge(ctx, events, uuid, "bridge")
+}
+
+// UUIDBridge - Bridges two existing channels with uuid_bridge and waits for the bridge or for one of them to hang up
+func (c *Conn) UUIDBridge(ctx context.Context, uuid, otherUUID string) (BridgeResult, error) {
+	events, done, err := c.watchChannels(ctx, []string{"CHANNEL_BRIDGE", "CHANNEL_HANGUP"}, uuid, otherUUID)
+	if err != nil {
+		return BridgeResult{}, err
+	}
+	defer done()
+	if _, err := c.apiOutput(ctx, "uuid_bridge", uuid+" "+otherUUID); err != nil {
+		return BridgeResult{}, err
+	}
+	return waitForBridge(ctx, events, uuid, "")
+}
+
+// Transfer - Blind transfers the channel to the destination extension with uuid_transfer, returns once FreeSWITCH accepted the transfer
+func (c *Conn) Transfer(ctx context.Context, uuid, destination string, opts TransferOptions) error {
+	args := []string{uuid}
+	if opts.Leg != TransferALeg {
+		args = append(args, string(opts.Leg))
+	}
+	args = append(args, quoteArgument(destination))
+	if opts.Dialplan != "" || opts.Context != "" {
+		dialplan := opts.Dialplan
+		if dialplan == "" {
+			dialplan = "XML"
+		}
+		args = append(args, dialplan)
+		if opts.Context != "" {
+			args = append(args, opts.Context)
+		}
+	}
+	_, err := c.apiOutput(ctx, "uuid_transfer", strings.Join(args, " "))
+	return err
+}
+
+// TransferInline - Blind transfers the channel to the inline dialplan made of the apps, e.g. "playback:/tmp/bye.wav", "hangup"
+func (c *Conn) TransferInline(ctx context.Context, uuid string, opts TransferOptions, apps ...string) error {
+	if len(apps) == 0 {
+		return errors.New("no app to transfer to")
+	}
+	return c.Transfer(ctx, uuid, strings.Join(apps, ","), TransferOptions{Leg: opts.Leg, Dialplan: "inline"})
+}
+
+// Unbridge - Parks both legs of the bridged channel and returns the UUID of the leg it was bridged to
+func (c *Conn) Unbridge(ctx context.Context, uuid string) (string, error) {
+	events, done, err := c.watchChannels(ctx, []string{"CHANNEL_UNBRIDGE", "CHANNEL_HANGUP"}, uuid)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	if err := c.TransferInline(ctx, uuid, TransferOptions{Leg: TransferBothLegs}, "park"); err != nil {
+		return "", err
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return "", errors.New("connection closed")
+			}
+			switch event.GetName() {
+			case "CHANNEL_UNBRIDGE":
+				return otherLeg(event, uuid), nil
+			case "CHANNEL_HANGUP":
+				return "", errors.New("channel hung up: " + event.GetHangupCause().String())
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// Park - Parks the channel with uuid_park, a channel it was bridged to hangs up unless park_after_bridge is set on it
+func (c *Conn) Park(ctx context.Context, uuid string) error {
+	events, done, err := c.watchChannels(ctx, []string{"CHANNEL_PARK", "CHANNEL_HANGUP"}, uuid)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if _, err := c.apiOutput(ctx, "uuid_park", uuid); err != nil {
+		return err
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return errors.New("connection closed")
+			}
+			if event.GetName() == "CHANNEL_HANGUP" {
+				return errors.New("channel hung up: " + event.GetHangupCause().String())
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// AttendedTransfer - Executes the mod_dptools att_xfer app on the transferor channel, which holds the party it is bridged
+// to and calls the target. Returns once the target answered and is bridged to the transferor or the call to it failed,
+// use Complete or Cancel on an answered consultation.
+func (c *Conn) AttendedTransfer(ctx context.Context, uuid string, target Leg) (*Consultation, error) {
+	held, err := c.apiOutput(ctx, "uuid_getvar", uuid+" bridge_uuid")
+	if err != nil {
+		return nil, err
+	}
+	if held == "" || held == "_undef_" {
+		return nil, errors.New("channel " + uuid + " is not bridged")
+	}
+
+	events, done, err := c.watchChannels(ctx, []string{"CHANNEL_BRIDGE", "CHANNEL_EXECUTE_COMPLETE", "CHANNEL_HANGUP"}, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if _, err := c.ExecuteAsync(ctx, uuid, "att_xfer", target.String()); err != nil {
+		return nil, err
+	}
+	result, err := waitForBridge(ctx, events, uuid, "att_xfer")
+	if err != nil {
+		return nil, err
+	}
+	return &Consultation{BridgeResult: result, HeldUUID: held, conn: c}, nil
+}
+
+// Complete - Hangs up the transferor so FreeSWITCH bridges the held party to the consulted party. The result has the
+// consulted party as UUID and the held party as PeerUUID
+func (t *Consultation) Complete(ctx context.Context) (BridgeResult, error) {
+	if !t.Bridged() {
+		return BridgeResult{}, errors.New("consultation was not answered")
+	}
+	return t.hangupAndWait(ctx, t.UUID, t.PeerUUID)
+}
+
+// Cancel - Hangs up the consulted party so FreeSWITCH bridges the transferor back to the held party. The result has the
+// transferor as UUID and the held party as PeerUUID
+func (t *Consultation) Cancel(ctx context.Context) (BridgeResult, error) {
+	if !t.Bridged() {
+		return BridgeResult{}, errors.New("consultation was not answered")
+	}
+	return t.hangupAndWait(ctx, t.PeerUUID, t.UUID)
+}
+
+// Hangs up one side of the consultation and waits for the other side to be bridged to the held party. FreeSWITCH may
+// report the bridge on either channel so both are watched
+func (t *Consultation) hangupAndWait(ctx context.Context, hangup, remaining string) (BridgeResult, error) {
+	events, done, err := t.conn.watchChannels(ctx, []string{"CHANNEL_BRIDGE", "CHANNEL_HANGUP"}, remaining, t.HeldUUID)
+	if err != nil {
+		return BridgeResult{}, err
+	}
+	defer done()
+	if err := t.conn.HangupCall(ctx, hangup, call.CauseNormalClearing); err != nil {
+		return BridgeResult{}, err
+	}
+	return waitForBridge(ctx, events, remaining, "")
+}
+
+// Waits for the channel to be bridged, to hang up or, when app is set, for the app to complete without bridging it
+func waitForBridge(ctx context.Context, events <-chan *Event, uuid, app string) (BridgeResult, error) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return BridgeResult{}, errors.New("connection closed")
+			}
+			switch event.GetName() {
+			case "CHANNEL_BRIDGE":
+				return BridgeResult{UUID: uuid, PeerUUID: otherLeg(event, uuid)}, nil
+			case "CHANNEL_EXECUTE_COMPLETE":
+				if app != "" && event.GetHeader("Application") == app && event.GetHeader("Unique-ID") == uuid {
+					return BridgeResult{UUID: uuid, Cause: bridgeFailureCause(event)}, nil
+				}
+			case "CHANNEL_HANGUP":
+				return BridgeResult{UUID: uuid, Cause: event.GetHangupCause()}, nil
+			}
+		case <-ctx.Done():
+			return BridgeResult{}, ctx.Err()
+		}
+	}
+}
+
+// Returns the UUID of the channel on the other side of a bridge event from uuid
+func otherLeg(event *Event, uuid string) string {
+	a, b := event.GetHeader("Bridge-A-Unique-ID"), event.GetHeader("Bridge-B-Unique-ID")
+	switch {
+	case a == uuid && b != "":
+		return b
+	case b == uuid && a != "":
+		return a
+	case event.GetHeader("Unique-ID") == uuid:
+		return event.GetHeader("Other-Leg-Unique-ID")
+	default:
+		return event.GetHeader("Unique-ID")
+	}
+}
+
+// The reason a bridge app failed, FreeSWITCH sets originate_disposition to the hangup cause of the last leg tried
+func bridgeFailureCause(event *Event) call.HangupCause {
+	for _, header := range []string{"variable_originate_disposition", "variable_bridge_hangup_cause", "variable_last_bridge_hangup_cause"} {
+		if cause := event.GetHeader(header); cause != "" && cause != "SUCCESS" {
+			return call.HangupCause(cause)
+		}
+	}
+	return call.CauseNormalUnspecified
+}
+
+// Subscribes to the events of the channels in the order they are received, done removes the subscription. The channels
+// are matched on the client so the Unique-ID filters of the connection keep delivering the events of other channels. Only
+// the event names the connection does not receive yet are added with SubscribeEvents and removed again with nixevent,
+// events enabled with EnableEvents stay enabled.
+func (c *Conn) watchChannels(ctx context.Context, events []string, uuids ...string) (<-chan *Event, func(), error) {
+	sub := EventSubscription{Events: events, UniqueIDs: uuids, ClientSideFilter: true}
+	return c.SubscribeEvents(ctx, sub, SubscribeOptions{Buffer: 64})
+}
+
+// Runs an api command and returns its output, the output of a failed command is returned as the error
+func (c *Conn) apiOutput(ctx context.Context, cmd, args string) (string, error) {
+	response, err := c.SendCommand(ctx, command.API{Command: cmd, Arguments: args})
+	if err != nil {
+		return "", err
+	}
+	output := strings.TrimSpace(string(response.Body))
+	if strings.HasPrefix(output, "-ERR") || strings.HasPrefix(output, "-USAGE") {
+		return "", errors.New(cmd + ": " + output)
+	}
+	return output, nil
+}
+
+// Quotes an api argument containing spaces so FreeSWITCH keeps it together
+func quoteArgument(arg string) string {
+	if strings.ContainsAny(arg, " \t") {
+		return "'" + arg + "'"
+	}
+	return arg
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Starts a fake server that answers commands containing a key of events with +OK and then sends the events
+func newBridgeServer(t *testing.T, events map[string][]map[string]string) (*fakeServer, *Conn) {
+	var server *fakeServer
+	reply := func(cmd string) string {
+		for key, send := range events {
+			if strings.Contains(cmd, key) {
+				send := send
+				go func() {
+					for _, event := range send {
+						_ = server.sendEvent(event)
+					}
+				}()
+			}
+		}
+		if !strings.HasPrefix(cmd, "api ") {
+			return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+		}
+		body := "+OK\n"
+		switch {
+		case strings.Contains(cmd, "missing"):
+			body = "-ERR No such channel!\n"
+		case strings.HasPrefix(cmd, "api uuid_getvar b bridge_uuid"):
+			body = "a"
+		case strings.HasPrefix(cmd, "api uuid_getvar "):
+			body = "_undef_"
+		}
+		return "Content-Type: api/response\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, reply)
+	t.Cleanup(func() {
+		connection.Close()
+		server.close()
+	})
+	return server, connection
+}
+
+func TestConn_Bridge(t *testing.T) {
+	server, connection := newBridgeServer(t, map[string][]map[string]string{
+		"Execute-App-Arg: [leg_timeout=10]user/1000|sofia/gateway/carrier/1000": {
+			{"Event-Name": "CHANNEL_EXECUTE", "Unique-ID": "a", "Application": "bridge"},
+			{"Event-Name": "CHANNEL_BRIDGE", "Unique-ID": "a", "Other-Leg-Unique-ID": "b", "Bridge-A-Unique-ID": "a", "Bridge-B-Unique-ID": "b"},
+		},
+		"Execute-App-Arg: user/2000": {
+			{"Event-Name": "CHANNEL_EXECUTE_COMPLETE", "Unique-ID": "a", "Application": "bridge", "variable_originate_disposition": "USER_BUSY"},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := connection.Bridge(ctx, "a", Leg{CallURL: "user/1000", LegVariables: map[string]string{"leg_timeout": "10"}}, Leg{CallURL: "sofia/gateway/carrier/1000"})
+	require.NoError(t, err)
+	assert.True(t, result.Bridged())
+	assert.Equal(t, BridgeResult{UUID: "a", PeerUUID: "b"}, result)
+
+	result, err = connection.Bridge(ctx, "a", Leg{CallURL: "user/2000"})
+	require.NoError(t, err)
+	assert.False(t, result.Bridged())
+	assert.Equal(t, call.CauseUserBusy, result.Cause)
+	// The channel is matched on the client, the connection's Unique-ID filters are left alone
+	for _, received := range server.received() {
+		assert.NotContains(t, received, "filter")
+	}
+
+	_, err = connection.Bridge(ctx, "a")
+	assert.Error(t, err)
+}
+
+func TestConn_UUIDBridge(t *testing.T) {
+	_, connection := newBridgeServer(t, map[string][]map[string]string{
+		"uuid_bridge a b": {
+			{"Event-Name": "CHANNEL_BRIDGE", "Unique-ID": "b", "Bridge-A-Unique-ID": "b", "Bridge-B-Unique-ID": "a"},
+		},
+		"uuid_bridge c d": {
+			{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "d", "Hangup-Cause": "ORIGINATOR_CANCEL"},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := connection.UUIDBridge(ctx, "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, BridgeResult{UUID: "a", PeerUUID: "b"}, result)
+	result, err = connection.UUIDBridge(ctx, "c", "d")
+	require.NoError(t, err)
+	assert.Equal(t, BridgeResult{UUID: "c", Cause: call.CauseOriginatorCancel}, result)
+	_, err = connection.UUIDBridge(ctx, "a", "missing")
+	assert.EqualError(t, err, "uuid_bridge: -ERR No such channel!")
+}
+
+func TestConn_Transfer(t *testing.T) {
+	server, connection := newBridgeServer(t, map[string][]map[string]string{
+		"uuid_transfer a -both park inline": {
+			{"Event-Name": "CHANNEL_UNBRIDGE", "Unique-ID": "a", "Other-Leg-Unique-ID": "b"},
+		},
+		"uuid_park c": {
+			{"Event-Name": "CHANNEL_PARK", "Unique-ID": "c"},
+		},
+		"uuid_park d": {
+			{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "d", "Hangup-Cause": "NORMAL_CLEARING"},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, connection.Transfer(ctx, "a", "1000", TransferOptions{}))
+	assert.NoError(t, connection.Transfer(ctx, "a", "1000", TransferOptions{Leg: TransferBLeg, Context: "support"}))
+	assert.NoError(t, connection.TransferInline(ctx, "a", TransferOptions{}, "playback:/tmp/hold music.wav", "hangup"))
+	assert.Error(t, connection.Transfer(ctx, "missing", "1000", TransferOptions{}))
+	received := server.received()
+	assert.Equal(t, []string{
+		"api uuid_transfer a 1000",
+		"api uuid_transfer a -bleg 1000 XML support",
+		"api uuid_transfer a 'playback:/tmp/hold music.wav,hangup' inline",
+		"api uuid_transfer missing 1000",
+	}, received)
+
+	peer, err := connection.Unbridge(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "b", peer)
+	assert.NoError(t, connection.Park(ctx, "c"))
+	assert.EqualError(t, connection.Park(ctx, "d"), "channel hung up: NORMAL_CLEARING")
+}
+
+func TestConn_AttendedTransfer(t *testing.T) {
+	server, connection := newBridgeServer(t, map[string][]map[string]string{
+		"Execute-App-Name: att_xfer": {
+			{"Event-Name": "CHANNEL_BRIDGE", "Unique-ID": "b", "Other-Leg-Unique-ID": "c"},
+		},
+		// The transferor hangs up, FreeSWITCH bridges the held party to the consulted party
+		"Hangup-Cause: NORMAL_CLEARING": {
+			{"Event-Name": "CHANNEL_BRIDGE", "Unique-ID": "a", "Other-Leg-Unique-ID": "c", "Bridge-A-Unique-ID": "a", "Bridge-B-Unique-ID": "c"},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consultation, err := connection.AttendedTransfer(ctx, "b", Leg{CallURL: "user/1002"})
+	require.NoError(t, err)
+	assert.Equal(t, BridgeResult{UUID: "b", PeerUUID: "c"}, consultation.BridgeResult)
+	assert.Equal(t, "a", consultation.HeldUUID)
+	assert.Contains(t, server.received()[2], "Execute-App-Arg: user/1002")
+
+	result, err := consultation.Complete(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, BridgeResult{UUID: "c", PeerUUID: "a"}, result)
+
+	_, err = connection.AttendedTransfer(ctx, "x", Leg{CallURL: "user/1002"})
+	assert.EqualError(t, err, "channel x is not bridged")
+}
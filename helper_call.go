@@ -110,7 +110,7 @@ func (c *Conn) BackgroundOriginateCall(ctx context.Context, background bool, aLe
 }
 
 // HangupCall - A helper to hangup a call asynchronously
-func (c *Conn) HangupCall(ctx context.Context, uuid, cause string) error {
+func (c *Conn) HangupCall(ctx context.Context, uuid string, cause call.HangupCause) error {
 	_, err := c.SendCommand(ctx, call.Hangup{
 		UUID:  uuid,
 		Cause: cause,
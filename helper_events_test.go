@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHelpers_EnabledEvents(t *testing.T) {
+	server, err := esltest.NewServer("ClueCon")
+	require.NoError(t, err)
+	defer server.Close()
+	// Answers the bridge and playback apps like FreeSWITCH would
+	server.HandleCommand("sendmsg", func(command string) string {
+		headers := make(map[string]string)
+		for _, line := range strings.Split(command, "\n") {
+			if pair := strings.SplitN(line, ":", 2); len(pair) == 2 {
+				headers[strings.ToLower(pair[0])] = strings.TrimSpace(pair[1])
+			}
+		}
+		switch headers["execute-app-name"] {
+		case "bridge":
+			go server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_BRIDGE", "Unique-ID": "a", "Other-Leg-Unique-ID": "b", "Bridge-A-Unique-ID": "a", "Bridge-B-Unique-ID": "b"}})
+		case "playback":
+			go server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_EXECUTE_COMPLETE", "Unique-ID": "a", "Application-UUID": headers["event-uuid"], "Application-Response": "FILE PLAYED"}})
+		}
+		return "+OK"
+	})
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	defer conn.ExitAndClose()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hangups, stop := conn.Subscribe(ctx, eslgo.MatchEventName("CHANNEL_HANGUP"))
+	defer stop()
+	require.NoError(t, conn.EnableEvents(ctx))
+
+	result, err := conn.Bridge(ctx, "a", eslgo.Leg{CallURL: "user/1000"})
+	require.NoError(t, err)
+	assert.True(t, result.Bridged())
+	played, err := conn.PlayMedia(ctx, "a", eslgo.File("/tmp/a.wav"), eslgo.PlaybackOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "FILE PLAYED", played.Response)
+
+	// The helpers left the events enabled by EnableEvents alone, so the user still receives CHANNEL_HANGUP
+	for _, command := range server.Commands() {
+		assert.False(t, strings.HasPrefix(command, "nixevent"), command)
+	}
+	server.SendEvent(esltest.Event{Headers: map[string]string{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "a"}})
+	select {
+	case event := <-hangups:
+		assert.Equal(t, "a", event.GetHeader("Unique-ID"))
+	case <-ctx.Done():
+		t.Fatal("CHANNEL_HANGUP not received")
+	}
+}
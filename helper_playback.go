@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command/call"
+)
+
+// MediaSource - A file or stream the playback app can play, built with File, Tone, Silence, LocalStream and Sequence.
+// Invalid sources carry their error, reported by Err and by PlayMedia.
+type MediaSource struct {
+	uri string
+	err error
+}
+
+// PlaybackOptions - Channel variables set with uuid_setvar_multi before PlayMedia starts the playback. They are not scoped
+// to the playback, they stay on the channel and apply to later playbacks until they are set again.
+type PlaybackOptions struct {
+	Terminators string            // Digits that stop the playback, sets playback_terminators. Use none to disable them
+	Volume      int               // Volume adjustment, sets playback_volume. 0 leaves the channel variable unchanged
+	Sleep       time.Duration     // How long to pause after the playback, sets playback_sleep_val
+	Variables   map[string]string // Other channel variables set before the playback
+}
+
+// PlaybackResult - The outcome of a playback, from its PLAYBACK_STOP and CHANNEL_EXECUTE_COMPLETE events
+type PlaybackResult struct {
+	Path       string           // The path or URI played
+	Status     string           // The Playback-Status, done when it played to the end and break when it was interrupted
+	Response   string           // The Application-Response, e.g. FILE PLAYED or FILE NOT FOUND
+	Terminator string           // The terminator digit that stopped the playback, empty when none was pressed
+	Offset     int64            // The position in samples the playback stopped at
+	Duration   time.Duration    // How long the playback lasted
+	Cause      call.HangupCause // Set when the channel hung up during the playback
+}
+
+// Completed - Reports if the media played to the end
+func (r PlaybackResult) Completed() bool {
+	return r.Status == "done"
+}
+
+// File - A sound file, the path is absolute or relative to the sound prefix of FreeSWITCH
+func File(path string) MediaSource {
+	if path == "" {
+		return MediaSource{err: errors.New("media source: empty file path")}
+	}
+	return newMediaSource(path)
+}
+
+// Tone - A tone_stream:// in TGML, e.g. %(400,200,400,450) for a ring. loops repeats it, -1 forever and 0 plays it once
+func Tone(spec string, loops int) MediaSource {
+	if spec == "" {
+		return MediaSource{err: errors.New("media source: empty tone")}
+	}
+	uri := "tone_stream://" + spec
+	if loops != 0 {
+		uri += ";loops=" + strconv.Itoa(loops)
+	}
+	return newMediaSource(uri)
+}
+
+// Silence - A silence_stream:// lasting the duration, a negative duration plays silence until the playback is stopped
+func Silence(duration time.Duration) MediaSource {
+	ms := duration.Milliseconds()
+	switch {
+	case duration < 0:
+		ms = -1
+	case ms == 0:
+		return MediaSource{err: errors.New("media source: silence shorter than a millisecond")}
+	}
+	return newMediaSource("silence_stream://" + strconv.FormatInt(ms, 10))
+}
+
+// LocalStream - A local_stream:// of mod_local_stream, e.g. moh for music on hold
+func LocalStream(name string) MediaSource {
+	if name == "" {
+		return MediaSource{err: errors.New("media source: empty local stream name")}
+	}
+	return newMediaSource("local_stream://" + name)
+}
+
+// Sequence - A file_string:// playing the sources one after the other, nested sequences are flattened
+func Sequence(sources ...MediaSource) MediaSource {
+	if len(sources) == 0 {
+		return MediaSource{err: errors.New("media source: empty sequence")}
+	}
+	parts := make([]string, len(sources))
+	for i, source := range sources {
+		if source.err != nil {
+			return source
+		}
+		parts[i] = strings.TrimPrefix(source.uri, "file_string://")
+	}
+	return MediaSource{uri: "file_string://" + strings.Join(parts, "!")}
+}
+
+// String - The path or URI passed to the playback app
+func (m MediaSource) String() string {
+	return m.uri
+}
+
+// Err - Returns why the source is invalid, nil if it is valid
+func (m MediaSource) Err() error {
+	return m.err
+}
+
+// The ! separates the files of a file_string and line breaks would end the sendmsg header
+func newMediaSource(uri string) MediaSource {
+	if strings.ContainsAny(uri, "!\r\n") {
+		return MediaSource{err: fmt.Errorf("media source: invalid character in %q", uri)}
+	}
+	return MediaSource{uri: uri}
+}
+
+// PlayMedia - Sets the playback channel variables, executes the mod_dptools playback app and waits for it to finish.
+// PLAYBACK_STOP, CHANNEL_EXECUTE_COMPLETE and CHANNEL_HANGUP are subscribed to until the playback finished.
+func (c *Conn) PlayMedia(ctx context.Context, uuid string, source MediaSource, opts PlaybackOptions) (PlaybackResult, error) {
+	if source.err != nil {
+		return PlaybackResult{}, source.err
+	}
+	vars := make(map[string]string, len(opts.Variables)+3)
+	for key, value := range opts.Variables {
+		vars[key] = value
+	}
+	if opts.Terminators != "" {
+		vars["playback_terminators"] = opts.Terminators
+	}
+	if opts.Volume != 0 {
+		vars["playback_volume"] = strconv.Itoa(opts.Volume)
+	}
+	if opts.Sleep > 0 {
+		vars["playback_sleep_val"] = strconv.FormatInt(opts.Sleep.Milliseconds(), 10)
+	}
+	if err := c.setChannelVariables(ctx, uuid, vars); err != nil {
+		return PlaybackResult{}, err
+	}
+
+	events, done, err := c.watchChannels(ctx, []string{"PLAYBACK_STOP", "CHANNEL_EXECUTE_COMPLETE", "CHANNEL_HANGUP"}, uuid)
+	if err != nil {
+		return PlaybackResult{}, err
+	}
+	defer done()
+	appUUID := NewUUID()
+	response, err := c.SendCommand(ctx, &call.Execute{UUID: uuid, AppName: "playback", AppArgs: source.uri, AppUUID: appUUID})
+	if err != nil {
+		return PlaybackResult{}, err
+	}
+	if !response.IsOk() {
+		return PlaybackResult{}, errors.New("playback: " + response.GetReply())
+	}
+	return waitForPlayback(ctx, events, source.uri, appUUID)
+}
+
+func waitForPlayback(ctx context.Context, events <-chan *Event, path, appUUID string) (PlaybackResult, error) {
+	result := PlaybackResult{Path: path}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return result, errors.New("connection closed")
+			}
+			switch event.GetName() {
+			case "PLAYBACK_STOP":
+				// Other playbacks of the channel are ignored
+				if event.GetHeader("Playback-File-Path") == path {
+					result.Status = event.GetHeader("Playback-Status")
+					readPlaybackVariables(event, &result)
+				}
+			case "CHANNEL_EXECUTE_COMPLETE":
+				if event.GetHeader("Application-UUID") == appUUID {
+					result.Response = event.GetHeader("Application-Response")
+					readPlaybackVariables(event, &result)
+					return result, nil
+				}
+			case "CHANNEL_HANGUP":
+				result.Cause = event.GetHangupCause()
+				return result, nil
+			}
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+}
+
+// Reads the variables FreeSWITCH sets when a playback stops, values already read are kept
+func readPlaybackVariables(event *Event, result *PlaybackResult) {
+	if terminator := event.GetHeader("variable_playback_terminator_used"); terminator != "" {
+		result.Terminator = terminator
+	}
+	if offset, err := strconv.ParseInt(event.GetHeader("variable_playback_last_offset_pos"), 10, 64); err == nil {
+		result.Offset = offset
+	}
+	if ms, err := strconv.ParseInt(event.GetHeader("variable_playback_ms"), 10, 64); err == nil {
+		result.Duration = time.Duration(ms) * time.Millisecond
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaSource(t *testing.T) {
+	assert.Equal(t, "/tmp/hello.wav", File("/tmp/hello.wav").String())
+	assert.Equal(t, "tone_stream://%(400,200,400,450);loops=3", Tone("%(400,200,400,450)", 3).String())
+	assert.Equal(t, "tone_stream://%(1000,0,640)", Tone("%(1000,0,640)", 0).String())
+	assert.Equal(t, "silence_stream://1500", Silence(1500*time.Millisecond).String())
+	assert.Equal(t, "silence_stream://-1", Silence(-time.Second).String())
+	assert.Equal(t, "local_stream://moh", LocalStream("moh").String())
+
+	sequence := Sequence(File("a.wav"), Silence(time.Second), Sequence(File("b.wav"), LocalStream("moh")))
+	require.NoError(t, sequence.Err())
+	assert.Equal(t, "file_string://a.wav!silence_stream://1000!b.wav!local_stream://moh", sequence.String())
+
+	for _, source := range []MediaSource{
+		File(""),
+		File("a!b.wav"),
+		File("a.wav\r\nevent-lock: true"),
+		Tone("", 0),
+		Silence(time.Microsecond),
+		LocalStream(""),
+		Sequence(),
+		Sequence(File("a.wav"), File("")),
+	} {
+		assert.Error(t, source.Err(), source.String())
+	}
+}
+
+func TestConn_PlayMedia(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	source := Sequence(File("/tmp/a.wav"), File("/tmp/b.wav"))
+	type played struct {
+		result PlaybackResult
+		err    error
+	}
+	results := make(chan played, 1)
+	go func() {
+		result, err := connection.PlayMedia(ctx, "abc", source, PlaybackOptions{Terminators: "#", Sleep: 500 * time.Millisecond})
+		results <- played{result, err}
+	}()
+
+	var sendmsg []string
+	assert.Eventually(t, func() bool {
+		received := server.received()
+		if len(received) == 0 {
+			return false
+		}
+		last := received[len(received)-1]
+		sendmsg = strings.Split(last, "\n")
+		return strings.HasPrefix(last, "sendmsg abc")
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []string{
+		"api uuid_setvar_multi abc playback_sleep_val=500;playback_terminators=#",
+		"event plain CHANNEL_EXECUTE_COMPLETE CHANNEL_HANGUP PLAYBACK_STOP",
+	}, server.received()[:2])
+	assert.Contains(t, sendmsg, "Execute-App-Name: playback")
+	assert.Contains(t, sendmsg, "Execute-App-Arg: file_string:///tmp/a.wav!/tmp/b.wav")
+	assert.NotContains(t, sendmsg, "event-lock: true")
+	var appUUID string
+	for _, header := range sendmsg {
+		if strings.HasPrefix(header, "Event-Uuid: ") {
+			appUUID = strings.TrimPrefix(header, "Event-Uuid: ")
+		}
+	}
+	require.NotEmpty(t, appUUID)
+
+	// The stop of another playback and the completion of another app are ignored
+	require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "PLAYBACK_STOP", "Unique-ID": "abc", "Playback-File-Path": "/tmp/other.wav", "Playback-Status": "done"}))
+	require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_EXECUTE_COMPLETE", "Unique-ID": "abc", "Application-UUID": "other"}))
+	require.NoError(t, server.sendEvent(map[string]string{
+		"Event-Name":                        "PLAYBACK_STOP",
+		"Unique-ID":                         "abc",
+		"Playback-File-Path":                source.String(),
+		"Playback-Status":                   "break",
+		"variable_playback_terminator_used": "#",
+		"variable_playback_last_offset_pos": "16000",
+		"variable_playback_ms":              "2000",
+	}))
+	require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_EXECUTE_COMPLETE", "Unique-ID": "abc", "Application-UUID": appUUID, "Application-Response": "FILE PLAYED"}))
+
+	outcome := <-results
+	require.NoError(t, outcome.err)
+	assert.Equal(t, PlaybackResult{
+		Path:       source.String(),
+		Status:     "break",
+		Response:   "FILE PLAYED",
+		Terminator: "#",
+		Offset:     16000,
+		Duration:   2 * time.Second,
+	}, outcome.result)
+	assert.False(t, outcome.result.Completed())
+
+	// The subscription is removed once the playback finished
+	assert.Eventually(t, func() bool {
+		received := server.received()
+		return received[len(received)-1] == "nixevent plain CHANNEL_EXECUTE_COMPLETE CHANNEL_HANGUP PLAYBACK_STOP"
+	}, 5*time.Second, time.Millisecond)
+
+	assert.NotContains(t, server.received(), "filter Unique-ID abc")
+
+	// Invalid sources are reported before anything is sent
+	count := len(server.received())
+	_, err := connection.PlayMedia(ctx, "abc", File("a!b"), PlaybackOptions{})
+	assert.Error(t, err)
+	assert.Len(t, server.received(), count)
+}
+
+func TestConn_PlayMedia_Hangup(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := make(chan PlaybackResult, 1)
+	go func() {
+		result, err := connection.PlayMedia(ctx, "abc", LocalStream("moh"), PlaybackOptions{})
+		assert.NoError(t, err)
+		results <- result
+	}()
+	assert.Eventually(t, func() bool {
+		received := server.received()
+		return len(received) > 0 && strings.HasPrefix(received[len(received)-1], "sendmsg abc")
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "abc", "Hangup-Cause": "NORMAL_CLEARING"}))
+	result := <-results
+	assert.Equal(t, call.HangupCause("NORMAL_CLEARING"), result.Cause)
+	assert.Equal(t, "local_stream://moh", result.Path)
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+)
+
+// RecordOptions - Used to configure a recording started with StartRecording
+type RecordOptions struct {
+	Stereo     bool              // Record each leg on its own channel, sets RECORD_STEREO
+	SampleRate int               // The sample rate of the file, sets record_sample_rate. 0 uses the rate of the channel
+	MinSeconds int               // Recordings shorter than this are discarded, sets RECORD_MIN_SEC
+	Append     bool              // Append to the file if it exists, sets RECORD_APPEND
+	Limit      time.Duration     // Stop recording after this long, 0 records until stopped or the channel hangs up
+	Session    bool              // Start with the record_session app instead of the uuid_record api
+	Variables  map[string]string // Other channel variables set before the recording starts, e.g. RECORD_TITLE
+}
+
+// RecordingResult - Reported by the RECORD_STOP event of a recording
+type RecordingResult struct {
+	Path     string
+	Duration time.Duration
+	Samples  int64
+	Cause    string // The Record-Completion-Cause, e.g. success-silence or success-maxtime, empty on older FreeSWITCH versions
+}
+
+// Recording - A recording in progress on a channel, returned by StartRecording
+type Recording struct {
+	conn    *Conn
+	uuid    string
+	path    string
+	subID   string
+	once    sync.Once
+	started chan struct{}
+	done    chan struct{}
+	result  RecordingResult
+}
+
+var channelVariable = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// StartRecording - Starts recording the channel to path. References to channel variables in the path such as
+// /recordings/${caller_id_number}/${uuid}.wav are expanded before the recording starts. The RECORD_START and RECORD_STOP
+// events are added to the connection's subscription with AddSubscription, the channel is matched on the client so other
+// channels keep their events.
+func (c *Conn) StartRecording(ctx context.Context, uuid, path string, opts RecordOptions) (*Recording, error) {
+	path, err := c.expandChannelVariables(ctx, uuid, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setRecordVariables(ctx, uuid, opts); err != nil {
+		return nil, err
+	}
+
+	recording := &Recording{
+		conn:    c,
+		uuid:    uuid,
+		path:    path,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	recording.subID, err = c.AddSubscription(ctx, EventSubscription{
+		Events:           []string{"RECORD_START", "RECORD_STOP"},
+		UniqueIDs:        []string{uuid},
+		ClientSideFilter: true,
+	}, recording.handleEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Session {
+		appArgs := path
+		if opts.Limit > 0 {
+			appArgs += " +" + strconv.Itoa(int(opts.Limit/time.Second))
+		}
+		_, err = c.Execute(ctx, uuid, "record_session", appArgs)
+	} else {
+		args := uuid + " start " + path
+		if opts.Limit > 0 {
+			args += " " + strconv.Itoa(int(opts.Limit/time.Second))
+		}
+		_, err = c.Api(ctx, "uuid_record", args)
+	}
+	if err != nil {
+		_ = c.RemoveSubscription(context.Background(), recording.subID)
+		return nil, err
+	}
+	return recording, nil
+}
+
+// Path - Returns the path of the file with channel variables expanded
+func (r *Recording) Path() string {
+	return r.path
+}
+
+// Started - Closed once the RECORD_START event is received
+func (r *Recording) Started() <-chan struct{} {
+	return r.started
+}
+
+// Done - Closed once the RECORD_STOP event is received
+func (r *Recording) Done() <-chan struct{} {
+	return r.done
+}
+
+// Stop - Stops the recording, use Wait for its result
+func (r *Recording) Stop(ctx context.Context) error {
+	_, err := r.conn.Api(ctx, "uuid_record", r.uuid+" stop "+r.path)
+	return err
+}
+
+// Mask - Replaces the recorded audio with silence until Unmask, e.g. while a card number is entered
+func (r *Recording) Mask(ctx context.Context) error {
+	_, err := r.conn.Api(ctx, "uuid_record", r.uuid+" mask "+r.path)
+	return err
+}
+
+// Unmask - Resumes recording the audio after Mask
+func (r *Recording) Unmask(ctx context.Context) error {
+	_, err := r.conn.Api(ctx, "uuid_record", r.uuid+" unmask "+r.path)
+	return err
+}
+
+// Wait - Waits for the recording to stop and returns the result reported by FreeSWITCH
+func (r *Recording) Wait(ctx context.Context) (RecordingResult, error) {
+	select {
+	case <-r.done:
+		return r.result, nil
+	case <-ctx.Done():
+		return RecordingResult{}, ctx.Err()
+	}
+}
+
+// Listener for the RECORD_START and RECORD_STOP events of the channel, other recordings on it are ignored
+func (r *Recording) handleEvent(event *Event) {
+	if event.GetHeader("Record-File-Path") != r.path {
+		return
+	}
+	switch event.GetName() {
+	case "RECORD_START":
+		r.markStarted()
+	case "RECORD_STOP":
+		r.once.Do(func() {
+			// Listeners run concurrently so RECORD_STOP may be handled first
+			r.markStarted()
+			r.result = recordingResult(event)
+			close(r.done)
+			go func() {
+				ctx, cancel := context.WithTimeout(r.conn.runningContext, 5*time.Second)
+				defer cancel()
+				_ = r.conn.RemoveSubscription(ctx, r.subID)
+			}()
+		})
+	}
+}
+
+func (r *Recording) markStarted() {
+	select {
+	case <-r.started:
+	default:
+		close(r.started)
+	}
+}
+
+func recordingResult(event *Event) RecordingResult {
+	result := RecordingResult{
+		Path:  event.GetHeader("Record-File-Path"),
+		Cause: event.GetHeader("Record-Completion-Cause"),
+	}
+	if ms, err := strconv.ParseInt(event.GetHeader("variable_record_ms"), 10, 64); err == nil {
+		result.Duration = time.Duration(ms) * time.Millisecond
+	} else if seconds, err := strconv.ParseInt(event.GetHeader("variable_record_seconds"), 10, 64); err == nil {
+		result.Duration = time.Duration(seconds) * time.Second
+	}
+	result.Samples, _ = strconv.ParseInt(event.GetHeader("variable_record_samples"), 10, 64)
+	return result
+}
+
+// Sets the channel variables FreeSWITCH reads when a recording starts
+func (c *Conn) setRecordVariables(ctx context.Context, uuid string, opts RecordOptions) error {
+	vars := make(map[string]string, len(opts.Variables)+4)
+	for key, value := range opts.Variables {
+		vars[key] = value
+	}
+	if opts.Stereo {
+		vars["RECORD_STEREO"] = "true"
+	}
+	if opts.SampleRate > 0 {
+		vars["record_sample_rate"] = strconv.Itoa(opts.SampleRate)
+	}
+	if opts.MinSeconds > 0 {
+		vars["RECORD_MIN_SEC"] = strconv.Itoa(opts.MinSeconds)
+	}
+	if opts.Append {
+		vars["RECORD_APPEND"] = "true"
+	}
+	return c.setChannelVariables(ctx, uuid, vars)
+}
+
+// Sets the channel variables with a single uuid_setvar_multi, values must not contain ; or line breaks
+func (c *Conn) setChannelVariables(ctx context.Context, uuid string, vars map[string]string) error {
+	if len(vars) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(vars))
+	for key, value := range vars {
+		if strings.ContainsAny(value, ";\r\n") {
+			return fmt.Errorf("invalid value for channel variable %s: %q", key, value)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assignments := make([]string, len(keys))
+	for i, key := range keys {
+		assignments[i] = key + "=" + vars[key]
+	}
+	_, err := c.Api(ctx, "uuid_setvar_multi", uuid+" "+strings.Join(assignments, ";"))
+	return err
+}
+
+// Expands ${name} references with the values of the channel variables, ${uuid} is the channel UUID
+func (c *Conn) expandChannelVariables(ctx context.Context, uuid, value string) (string, error) {
+	var err error
+	expanded := channelVariable.ReplaceAllStringFunc(value, func(reference string) string {
+		name := channelVariable.FindStringSubmatch(reference)[1]
+		if err != nil {
+			return ""
+		}
+		if name == "uuid" {
+			return uuid
+		}
+		response, apiErr := c.SendCommand(ctx, command.API{Command: "uuid_getvar", Arguments: uuid + " " + name})
+		if apiErr != nil {
+			err = apiErr
+			return ""
+		}
+		result := strings.TrimSpace(string(response.Body))
+		if strings.HasPrefix(result, "-ERR") {
+			err = errors.New("uuid_getvar " + name + ": " + result)
+			return ""
+		}
+		if result == "_undef_" {
+			return ""
+		}
+		return result
+	})
+	return expanded, err
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Answers api commands like FreeSWITCH would for a channel with caller_id_number 1000
+func recordSessionReply(cmd string) string {
+	if !strings.HasPrefix(cmd, "api ") {
+		return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+	}
+	body := "+OK Success\n"
+	switch {
+	case cmd == "api uuid_getvar abc caller_id_number":
+		body = "1000"
+	case strings.HasPrefix(cmd, "api uuid_getvar "):
+		body = "_undef_"
+	case strings.Contains(cmd, "/missing/"):
+		body = "-ERR Cannot open file\n"
+	}
+	return "Content-Type: api/response\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestConn_StartRecording(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, recordSessionReply)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recording, err := connection.StartRecording(ctx, "abc", "/recordings/${caller_id_number}/${uuid}${nothing}.wav", RecordOptions{
+		Stereo:     true,
+		SampleRate: 16000,
+		MinSeconds: 2,
+		Limit:      time.Minute,
+		Variables:  map[string]string{"RECORD_TITLE": "Support"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/recordings/1000/abc.wav", recording.Path())
+	assert.Equal(t, []string{
+		"api uuid_getvar abc caller_id_number",
+		"api uuid_getvar abc nothing",
+		"api uuid_setvar_multi abc RECORD_MIN_SEC=2;RECORD_STEREO=true;RECORD_TITLE=Support;record_sample_rate=16000",
+		"event plain RECORD_START RECORD_STOP",
+		"api uuid_record abc start /recordings/1000/abc.wav 60",
+	}, server.received())
+
+	assert.NoError(t, recording.Mask(ctx))
+	assert.NoError(t, recording.Unmask(ctx))
+	assert.NoError(t, recording.Stop(ctx))
+
+	// Another recording of the same channel is ignored
+	assert.NoError(t, server.sendEvent(map[string]string{"Event-Name": "RECORD_STOP", "Unique-ID": "abc", "Record-File-Path": "/tmp/other.wav"}))
+	assert.NoError(t, server.sendEvent(map[string]string{"Event-Name": "RECORD_START", "Unique-ID": "abc", "Record-File-Path": "/recordings/1000/abc.wav"}))
+	select {
+	case <-recording.Started():
+	case <-ctx.Done():
+		t.Fatal("RECORD_START not received")
+	}
+	assert.NoError(t, server.sendEvent(map[string]string{
+		"Event-Name":              "RECORD_STOP",
+		"Unique-ID":               "abc",
+		"Record-File-Path":        "/recordings/1000/abc.wav",
+		"Record-Completion-Cause": "success-silence",
+		"variable_record_ms":      "12500",
+		"variable_record_samples": "200000",
+	}))
+	result, err := recording.Wait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RecordingResult{Path: "/recordings/1000/abc.wav", Duration: 12500 * time.Millisecond, Samples: 200000, Cause: "success-silence"}, result)
+
+	// The subscription is removed once the recording stopped
+	assert.Eventually(t, func() bool {
+		received := server.received()
+		return received[len(received)-1] == "nixevent plain RECORD_START RECORD_STOP"
+	}, 5*time.Second, time.Millisecond)
+	assert.Contains(t, server.received(), "api uuid_record abc mask /recordings/1000/abc.wav")
+	assert.Contains(t, server.received(), "api uuid_record abc unmask /recordings/1000/abc.wav")
+	assert.Contains(t, server.received(), "api uuid_record abc stop /recordings/1000/abc.wav")
+}
+
+func TestConn_StartRecording_Errors(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, recordSessionReply)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connection.StartRecording(ctx, "abc", "/missing/file.wav", RecordOptions{})
+	assert.Error(t, err)
+	_, err = connection.StartRecording(ctx, "abc", "/tmp/file.wav", RecordOptions{Variables: map[string]string{"RECORD_TITLE": "a;b"}})
+	assert.EqualError(t, err, `invalid value for channel variable RECORD_TITLE: "a;b"`)
+
+	// The subscription of the failed recording was removed
+	received := server.received()
+	assert.Equal(t, "nixevent plain RECORD_START RECORD_STOP", received[len(received)-1])
+}
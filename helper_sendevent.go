@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+
+	"github.com/shuguocloud/eslgo/command"
+)
+
+// SIPMessage - The headers mod_sofia reads from the NOTIFY, SEND_MESSAGE and SEND_INFO events it turns into SIP requests
+type SIPMessage struct {
+	Profile     string            // The sofia profile to send from, e.g. internal
+	User        string            // The user to send to, with Host. Not needed when UUID is set
+	Host        string            // The domain of the user
+	UUID        string            // Sends the request in the dialog of this channel
+	ContentType string            // The Content-Type of the body, e.g. text/plain or application/simple-message-summary
+	EventString string            // The Event header of a NOTIFY, e.g. check-sync or message-summary
+	CallID      string            // The Call-ID of the subscription a NOTIFY belongs to
+	ToURI       string            // Overrides the To of the request
+	FromURI     string            // Overrides the From of the request
+	Headers     map[string]string // Other event headers, e.g. content-disposition or extra-headers
+	Body        string
+}
+
+// FireCustomEvent - Fires a CUSTOM event with the subclass through sendevent, listeners of the subclass receive it like
+// any FreeSWITCH event
+func (c *Conn) FireCustomEvent(ctx context.Context, subclass string, headers map[string]string, body string) error {
+	if subclass == "" {
+		return errors.New("sendevent: empty subclass")
+	}
+	event := make(textproto.MIMEHeader, len(headers)+1)
+	for key, value := range headers {
+		event.Set(key, value)
+	}
+	event.Set("Event-Subclass", subclass)
+	return c.sendEvent(ctx, "CUSTOM", event, body)
+}
+
+// SendNotify - Has mod_sofia send a SIP NOTIFY, e.g. a check-sync to reboot a phone or a message-summary for voicemail
+func (c *Conn) SendNotify(ctx context.Context, msg SIPMessage) error {
+	if msg.EventString == "" {
+		return errors.New("sendevent NOTIFY: empty event string")
+	}
+	return c.sendSIPEvent(ctx, "NOTIFY", msg)
+}
+
+// SendSIPMessage - Has mod_sofia send a SIP MESSAGE to a user or in the dialog of a channel
+func (c *Conn) SendSIPMessage(ctx context.Context, msg SIPMessage) error {
+	return c.sendSIPEvent(ctx, "SEND_MESSAGE", msg)
+}
+
+// SendInfo - Has mod_sofia send a SIP INFO, usually in the dialog of a channel
+func (c *Conn) SendInfo(ctx context.Context, msg SIPMessage) error {
+	return c.sendSIPEvent(ctx, "SEND_INFO", msg)
+}
+
+func (c *Conn) sendSIPEvent(ctx context.Context, name string, msg SIPMessage) error {
+	if msg.Profile == "" {
+		return errors.New("sendevent " + name + ": empty profile")
+	}
+	if msg.UUID == "" && (msg.User == "" || msg.Host == "") {
+		return errors.New("sendevent " + name + ": user and host or uuid required")
+	}
+
+	headers := make(textproto.MIMEHeader, len(msg.Headers)+9)
+	for key, value := range msg.Headers {
+		headers.Set(key, value)
+	}
+	for key, value := range map[string]string{
+		"profile":      msg.Profile,
+		"user":         msg.User,
+		"host":         msg.Host,
+		"uuid":         msg.UUID,
+		"content-type": msg.ContentType,
+		"event-string": msg.EventString,
+		"call-id":      msg.CallID,
+		"to-uri":       msg.ToURI,
+		"from-uri":     msg.FromURI,
+	} {
+		if value != "" {
+			headers.Set(key, value)
+		}
+	}
+	return c.sendEvent(ctx, name, headers, msg.Body)
+}
+
+// Sends the event with sendevent, FreeSWITCH replies +OK with the UUID of the event
+func (c *Conn) sendEvent(ctx context.Context, name string, headers textproto.MIMEHeader, body string) error {
+	response, err := c.SendCommand(ctx, &command.SendEvent{Name: name, Headers: headers, Body: body})
+	if err != nil {
+		return err
+	}
+	if !response.IsOk() {
+		return errors.New("sendevent " + name + ": " + response.GetReply())
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn_SendEvents(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, func(cmd string) string {
+		if strings.Contains(cmd, "Profile: missing") {
+			return "Content-Type: command/reply\r\nReply-Text: -ERR invalid profile\r\n\r\n"
+		}
+		return "Content-Type: command/reply\r\nReply-Text: +OK 8b9a2e0c-5b5e-4b8f-9f4c-1f1f9b2f6c3a\r\n\r\n"
+	})
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, connection.FireCustomEvent(ctx, "myapp::ready", map[string]string{"Node": "a"}, "hello"))
+	require.NoError(t, connection.SendNotify(ctx, SIPMessage{Profile: "internal", User: "1000", Host: "example.com", EventString: "check-sync", ContentType: "application/simple-message-summary"}))
+	require.NoError(t, connection.SendSIPMessage(ctx, SIPMessage{Profile: "internal", UUID: "abc", ContentType: "text/plain", Body: "Hi"}))
+	require.NoError(t, connection.SendInfo(ctx, SIPMessage{Profile: "internal", UUID: "abc", ContentType: "application/dtmf-relay", Headers: map[string]string{"content-disposition": "signal"}, Body: "Signal=5\r\nDuration=160"}))
+	assert.Equal(t, []string{
+		"sendevent CUSTOM\nContent-Length: 5\nEvent-Subclass: myapp::ready\nNode: a\n\nhello",
+		"sendevent NOTIFY\nContent-Type: application/simple-message-summary\nEvent-String: check-sync\nHost: example.com\nProfile: internal\nUser: 1000",
+		"sendevent SEND_MESSAGE\nContent-Length: 2\nContent-Type: text/plain\nProfile: internal\nUuid: abc\n\nHi",
+		"sendevent SEND_INFO\nContent-Disposition: signal\nContent-Length: 22\nContent-Type: application/dtmf-relay\nProfile: internal\nUuid: abc\n\nSignal=5\r\nDuration=160",
+	}, server.received())
+
+	assert.EqualError(t, connection.SendSIPMessage(ctx, SIPMessage{Profile: "missing", UUID: "abc"}), "sendevent SEND_MESSAGE: -ERR invalid profile")
+	assert.EqualError(t, connection.FireCustomEvent(ctx, "", nil, ""), "sendevent: empty subclass")
+	assert.EqualError(t, connection.SendNotify(ctx, SIPMessage{Profile: "internal", User: "1000", Host: "example.com"}), "sendevent NOTIFY: empty event string")
+	assert.EqualError(t, connection.SendInfo(ctx, SIPMessage{Profile: "internal", User: "1000"}), "sendevent SEND_INFO: user and host or uuid required")
+	assert.EqualError(t, connection.SendInfo(ctx, SIPMessage{UUID: "abc"}), "sendevent SEND_INFO: empty profile")
+	assert.Len(t, server.received(), 5)
+}
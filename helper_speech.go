@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SayType - What the text passed to the say app is, e.g. a number or a date
+type SayType string
+
+const (
+	SayNumber             SayType = "NUMBER"
+	SayItems              SayType = "ITEMS"
+	SayPersons            SayType = "PERSONS"
+	SayMessages           SayType = "MESSAGES"
+	SayCurrency           SayType = "CURRENCY"
+	SayTimeMeasurement    SayType = "TIME_MEASUREMENT"
+	SayCurrentDate        SayType = "CURRENT_DATE"
+	SayCurrentTime        SayType = "CURRENT_TIME"
+	SayCurrentDateTime    SayType = "CURRENT_DATE_TIME"
+	SayShortDateTime      SayType = "SHORT_DATE_TIME"
+	SayTelephoneNumber    SayType = "TELEPHONE_NUMBER"
+	SayTelephoneExtension SayType = "TELEPHONE_EXTENSION"
+	SayURL                SayType = "URL"
+	SayIPAddress          SayType = "IP_ADDRESS"
+	SayEmailAddress       SayType = "EMAIL_ADDRESS"
+	SayPostalAddress      SayType = "POSTAL_ADDRESS"
+	SayAccountNumber      SayType = "ACCOUNT_NUMBER"
+	SayNameSpelled        SayType = "NAME_SPELLED"
+	SayNamePhonetic       SayType = "NAME_PHONETIC"
+)
+
+// SayMethod - How the say app reads the text, e.g. 123 pronounced is "one hundred twenty three" and iterated "one two three"
+type SayMethod string
+
+const (
+	SayMethodNA             SayMethod = "N/A"
+	SayMethodPronounced     SayMethod = "PRONOUNCED"
+	SayMethodIterated       SayMethod = "ITERATED"
+	SayMethodCounted        SayMethod = "COUNTED"
+	SayMethodPronouncedYear SayMethod = "PRONOUNCED_YEAR"
+)
+
+// SayGender - The grammatical gender used by languages that inflect numbers
+type SayGender string
+
+const (
+	SayFeminine  SayGender = "FEMININE"
+	SayMasculine SayGender = "MASCULINE"
+	SayNeuter    SayGender = "NEUTER"
+)
+
+var sayTypes = map[SayType]bool{
+	SayNumber: true, SayItems: true, SayPersons: true, SayMessages: true, SayCurrency: true, SayTimeMeasurement: true,
+	SayCurrentDate: true, SayCurrentTime: true, SayCurrentDateTime: true, SayShortDateTime: true, SayTelephoneNumber: true,
+	SayTelephoneExtension: true, SayURL: true, SayIPAddress: true, SayEmailAddress: true, SayPostalAddress: true,
+	SayAccountNumber: true, SayNameSpelled: true, SayNamePhonetic: true,
+}
+
+var sayMethods = map[SayMethod]bool{
+	SayMethodNA: true, SayMethodPronounced: true, SayMethodIterated: true, SayMethodCounted: true, SayMethodPronouncedYear: true,
+}
+
+var sayGenders = map[SayGender]bool{
+	SayFeminine: true, SayMasculine: true, SayNeuter: true,
+}
+
+// SpeakOptions - Used to build the arguments of the mod_dptools speak app
+type SpeakOptions struct {
+	Engine string // The TTS module, e.g. flite or unimrcp. When empty the tts_engine and tts_voice channel variables are used
+	Voice  string // The voice of the engine, e.g. kal. Required when Engine is set
+	Text   string // The text to speak, passed as is apart from line breaks read as spaces. Can only contain | when Engine is set
+	SSML   bool   // Text is an SSML document, which must start with <speak
+}
+
+// SayOptions - Used to build the arguments of the mod_dptools say app
+type SayOptions struct {
+	Language string    // The say module, e.g. en or fr
+	Type     SayType   // What the text is
+	Method   SayMethod // How to read it. Defaults to SayMethodPronounced
+	Gender   SayGender // Optional
+	Text     string    // The value to say, e.g. 42 or an email address
+}
+
+// Arguments - Validates the options and builds the speak app arguments, engine|voice|text
+func (o SpeakOptions) Arguments() (string, error) {
+	text := strings.TrimSpace(o.Text)
+	if text == "" {
+		return "", errors.New("speak: no text")
+	}
+	if (o.Engine == "") != (o.Voice == "") {
+		return "", errors.New("speak: engine and voice must be set together")
+	}
+	if strings.ContainsAny(o.Engine+o.Voice, "| \t\r\n") {
+		return "", fmt.Errorf("speak: invalid engine or voice %q %q", o.Engine, o.Voice)
+	}
+	if o.SSML && !strings.HasPrefix(text, "<speak") {
+		return "", errors.New("speak: SSML text must start with <speak")
+	}
+
+	// The speak app only splits engine and voice off the arguments and takes the rest unstripped, so the text is never
+	// unescaped. Without an engine a | in the text would be taken for the voice separator.
+	text = strings.Join(strings.Fields(text), " ")
+	if o.Engine == "" {
+		if strings.Contains(text, "|") {
+			return "", errors.New("speak: text can only contain | when engine and voice are set")
+		}
+		return text, nil
+	}
+	return o.Engine + "|" + o.Voice + "|" + text, nil
+}
+
+// Arguments - Validates the options and builds the say app arguments, <language> <type> <method> [<gender>] <text>
+func (o SayOptions) Arguments() (string, error) {
+	if o.Language == "" || strings.ContainsAny(o.Language, " \t\r\n") {
+		return "", fmt.Errorf("say: invalid language %q", o.Language)
+	}
+	if !sayTypes[o.Type] {
+		return "", fmt.Errorf("say: invalid type %q", o.Type)
+	}
+	method := o.Method
+	if method == "" {
+		method = SayMethodPronounced
+	}
+	if !sayMethods[method] {
+		return "", fmt.Errorf("say: invalid method %q", method)
+	}
+	if o.Gender != "" && !sayGenders[o.Gender] {
+		return "", fmt.Errorf("say: invalid gender %q", o.Gender)
+	}
+	if strings.TrimSpace(o.Text) == "" {
+		return "", errors.New("say: no text")
+	}
+	if strings.ContainsAny(o.Text, "\r\n") {
+		return "", errors.New("say: text contains a line break")
+	}
+
+	args := []string{o.Language, string(o.Type), string(method)}
+	if o.Gender != "" {
+		args = append(args, string(o.Gender))
+	}
+	text := escapeSayArgument(o.Text)
+	if strings.ContainsAny(text, " \t") {
+		// Keeps the text in a single argument so its first word is not taken for the gender
+		text = "'" + text + "'"
+	}
+	return strings.Join(append(args, text), " "), nil
+}
+
+// SpeakWithOptions - Executes the mod_dptools speak app with typed options
+func (c *Conn) SpeakWithOptions(ctx context.Context, uuid string, opts SpeakOptions, times int) (*RawResponse, error) {
+	args, err := opts.Arguments()
+	if err != nil {
+		return nil, err
+	}
+	return c.audioCommand(ctx, "speak", uuid, args, times, true)
+}
+
+// SpeakWithOptionsAsync - Executes the mod_dptools speak app with typed options in async mode
+func (c *Conn) SpeakWithOptionsAsync(ctx context.Context, uuid string, opts SpeakOptions, times int) (*RawResponse, error) {
+	args, err := opts.Arguments()
+	if err != nil {
+		return nil, err
+	}
+	return c.audioCommand(ctx, "speak", uuid, args, times, false)
+}
+
+// SayWithOptions - Executes the mod_dptools say app with typed options
+func (c *Conn) SayWithOptions(ctx context.Context, uuid string, opts SayOptions, times int) (*RawResponse, error) {
+	args, err := opts.Arguments()
+	if err != nil {
+		return nil, err
+	}
+	return c.audioCommand(ctx, "say", uuid, args, times, true)
+}
+
+// SayWithOptionsAsync - Executes the mod_dptools say app with typed options in async mode
+func (c *Conn) SayWithOptionsAsync(ctx context.Context, uuid string, opts SayOptions, times int) (*RawResponse, error) {
+	args, err := opts.Arguments()
+	if err != nil {
+		return nil, err
+	}
+	return c.audioCommand(ctx, "say", uuid, args, times, false)
+}
+
+// Escapes the quotes and backslashes the say app unescapes when it splits its arguments on spaces
+func escapeSayArgument(arg string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(arg)
+}
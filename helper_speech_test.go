@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpeakOptions_Arguments(t *testing.T) {
+	for name, test := range map[string]struct {
+		opts SpeakOptions
+		args string
+		err  string
+	}{
+		"engine":        {SpeakOptions{Engine: "flite", Voice: "kal", Text: "Hello world"}, "flite|kal|Hello world", ""},
+		"variables":     {SpeakOptions{Text: "Hello\r\n  world"}, "Hello world", ""},
+		"unescaped":     {SpeakOptions{Text: `It's \o/`}, `It's \o/`, ""},
+		"pipe":          {SpeakOptions{Engine: "flite", Voice: "kal", Text: "1|2"}, "flite|kal|1|2", ""},
+		"pipe no voice": {SpeakOptions{Text: "1|2"}, "", "speak: text can only contain | when engine and voice are set"},
+		"ssml":          {SpeakOptions{Engine: "unimrcp", Voice: "Joanna", Text: "<speak>Hi</speak>", SSML: true}, "unimrcp|Joanna|<speak>Hi</speak>", ""},
+		"ssml quotes": {
+			SpeakOptions{Engine: "unimrcp", Voice: "Joanna", Text: "<speak version='1.0' xml:lang=\"en-US\">It's <break time='1s'/>me</speak>", SSML: true},
+			"unimrcp|Joanna|<speak version='1.0' xml:lang=\"en-US\">It's <break time='1s'/>me</speak>", "",
+		},
+		"no text":      {SpeakOptions{Engine: "flite", Voice: "kal"}, "", "speak: no text"},
+		"no voice":     {SpeakOptions{Engine: "flite", Text: "Hi"}, "", "speak: engine and voice must be set together"},
+		"bad engine":   {SpeakOptions{Engine: "fl|ite", Voice: "kal", Text: "Hi"}, "", `speak: invalid engine or voice "fl|ite" "kal"`},
+		"invalid ssml": {SpeakOptions{Text: "Hi", SSML: true}, "", "speak: SSML text must start with <speak"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args, err := test.opts.Arguments()
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.args, args)
+		})
+	}
+}
+
+func TestSayOptions_Arguments(t *testing.T) {
+	for name, test := range map[string]struct {
+		opts SayOptions
+		args string
+		err  string
+	}{
+		"default method": {SayOptions{Language: "en", Type: SayNumber, Text: "42"}, "en NUMBER PRONOUNCED 42", ""},
+		"gender":         {SayOptions{Language: "fr", Type: SayNumber, Method: SayMethodCounted, Gender: SayFeminine, Text: "1"}, "fr NUMBER COUNTED FEMININE 1", ""},
+		"spaces":         {SayOptions{Language: "en", Type: SayPostalAddress, Method: SayMethodNA, Text: "1 Main St"}, "en POSTAL_ADDRESS N/A '1 Main St'", ""},
+		"escaped":        {SayOptions{Language: "en", Type: SayNameSpelled, Method: SayMethodIterated, Text: "O'Brien"}, `en NAME_SPELLED ITERATED O\'Brien`, ""},
+		"language":       {SayOptions{Type: SayNumber, Text: "1"}, "", `say: invalid language ""`},
+		"type":           {SayOptions{Language: "en", Type: "number", Text: "1"}, "", `say: invalid type "number"`},
+		"method":         {SayOptions{Language: "en", Type: SayNumber, Method: "loudly", Text: "1"}, "", `say: invalid method "loudly"`},
+		"bad gender":     {SayOptions{Language: "en", Type: SayNumber, Gender: "other", Text: "1"}, "", `say: invalid gender "other"`},
+		"no text":        {SayOptions{Language: "en", Type: SayNumber}, "", "say: no text"},
+		"line break":     {SayOptions{Language: "en", Type: SayNumber, Text: "1\n2"}, "", "say: text contains a line break"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args, err := test.opts.Arguments()
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.args, args)
+		})
+	}
+}
+
+func TestConn_SayWithOptions(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connection.SayWithOptions(ctx, "abc", SayOptions{Language: "en", Type: SayCurrency, Text: "12.50"}, 2)
+	require.NoError(t, err)
+	_, err = connection.SpeakWithOptionsAsync(ctx, "abc", SpeakOptions{Engine: "flite", Voice: "kal", Text: "Goodbye"}, 1)
+	require.NoError(t, err)
+	_, err = connection.SpeakWithOptions(ctx, "abc", SpeakOptions{}, 1)
+	assert.EqualError(t, err, "speak: no text")
+
+	received := server.received()
+	require.Len(t, received, 2)
+	say := strings.Split(received[0], "\n")
+	assert.Equal(t, "sendmsg abc", say[0])
+	assert.Contains(t, say, "event-lock: true")
+	assert.Contains(t, say, "Execute-App-Name: say")
+	assert.Contains(t, say, "Execute-App-Arg: en CURRENCY PRONOUNCED 12.50")
+	assert.Contains(t, say, "Loops: 2")
+	speak := strings.Split(received[1], "\n")
+	assert.NotContains(t, speak, "event-lock: true")
+	assert.Contains(t, speak, "Execute-App-Name: speak")
+	assert.Contains(t, speak, "Execute-App-Arg: flite|kal|Goodbye")
+}
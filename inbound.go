@@ -50,12 +50,17 @@ func (opts InboundOptions) Dial(address string) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	return opts.connect(c)
+}
+
+// Sets up an inbound ESL connection over an established network connection and authenticates
+func (opts InboundOptions) connect(c net.Conn) (*Conn, error) {
 	connection := newConnection(c, false, opts.Options)
 
 	// First auth
-	<-connection.responseChannels[TypeAuthRequest]
+	<-connection.responseChannel(TypeAuthRequest)
 	authCtx, cancel := context.WithTimeout(connection.runningContext, opts.AuthTimeout)
-	err = connection.doAuth(authCtx, command.Auth{Password: opts.Password})
+	err := connection.doAuth(authCtx, command.Auth{Password: opts.Password})
 	cancel()
 	if err != nil {
 		// Try to gracefully disconnect, we have the wrong password.
@@ -65,7 +70,7 @@ func (opts InboundOptions) Dial(address string) (*Conn, error) {
 		}
 		return nil, err
 	} else {
-		connection.logger.Info("Successfully authenticated %s\n", connection.conn.RemoteAddr())
+		connection.log(LevelInfo, "Successfully authenticated")
 	}
 
 	// Inbound only handlers
@@ -77,7 +82,7 @@ func (opts InboundOptions) Dial(address string) (*Conn, error) {
 
 func (c *Conn) disconnectLoop(onDisconnect func()) {
 	select {
-	case <-c.responseChannels[TypeDisconnect]:
+	case <-c.responseChannel(TypeDisconnect):
 		c.Close()
 		if onDisconnect != nil {
 			onDisconnect()
@@ -91,17 +96,17 @@ func (c *Conn) disconnectLoop(onDisconnect func()) {
 func (c *Conn) authLoop(auth command.Auth, authTimeout time.Duration) {
 	for {
 		select {
-		case <-c.responseChannels[TypeAuthRequest]:
+		case <-c.responseChannel(TypeAuthRequest):
 			authCtx, cancel := context.WithTimeout(c.runningContext, authTimeout)
 			err := c.doAuth(authCtx, auth)
 			cancel()
 			if err != nil {
-				c.logger.Warn("Failed to auth %e\n", err)
+				c.log(LevelWarn, "Failed to auth", "error", err)
 				// Close the connection, we have the wrong password
 				c.ExitAndClose()
 				return
 			} else {
-				c.logger.Info("Successfully authenticated %s\n", c.conn.RemoteAddr())
+				c.log(LevelInfo, "Successfully authenticated")
 			}
 		case <-c.runningContext.Done():
 			return
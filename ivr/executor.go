@@ -0,0 +1,284 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package ivr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command/call"
+)
+
+var (
+	// ErrHungUp - Returned by Run when the channel hung up before the IVR ended
+	ErrHungUp = errors.New("ivr: channel hung up")
+	// ErrMaxRetries - Returned by Run when a menu without a Failure action ran out of retries
+	ErrMaxRetries = errors.New("ivr: max retries reached")
+)
+
+// The channel variable play_and_get_digits stores the digits in, invalid digits are stored in ivr_digits_invalid
+const digitsVariable = "ivr_digits"
+
+const (
+	defaultTimeout     = 5 * time.Second
+	defaultTerminators = "#"
+	defaultCause       = "NORMAL_CLEARING"
+)
+
+// Callback - Called for ActionCallback actions with the digits that chose the option. The returned action is run next,
+// an empty action ends the IVR
+type Callback func(ctx context.Context, session *Session, digits string) (Action, error)
+
+// Session - The call an IVR is running on, passed to callbacks
+type Session struct {
+	Conn  *eslgo.Conn
+	UUID  string
+	Menu  *Menu        // The menu the option was chosen in
+	Event *eslgo.Event // The CHANNEL_EXECUTE_COMPLETE event of the digit collection, carries the channel variables
+}
+
+// Result - How the caller went through the IVR
+type Result struct {
+	Menus  []string // The name of every menu entered, in order
+	Digits []string // Every entry collected, invalid ones included and empty for timeouts
+	Action Action   // The action that ended the IVR
+}
+
+type runner struct {
+	ivr    *IVR
+	conn   *eslgo.Conn
+	uuid   string
+	events <-chan *eslgo.Event
+	stack  []string
+	result Result
+}
+
+// Run - Drives the channel through the menus from the start menu until a transfer, a hangup, a callback ending the IVR
+// or a failure. Usually called from an outbound session handler. CHANNEL_EXECUTE_COMPLETE and CHANNEL_HANGUP are
+// subscribed to while the IVR runs, the channel is matched on the client so the Unique-ID filters of the connection
+// are left as they are.
+func (ivr *IVR) Run(ctx context.Context, conn *eslgo.Conn, uuid string) (Result, error) {
+	if err := ivr.Validate(); err != nil {
+		return Result{}, err
+	}
+
+	sub := eslgo.EventSubscription{Events: []string{"CHANNEL_EXECUTE_COMPLETE", "CHANNEL_HANGUP"}, UniqueIDs: []string{uuid}, ClientSideFilter: true}
+	events, stop, err := conn.SubscribeEvents(ctx, sub, eslgo.SubscribeOptions{Buffer: 64})
+	if err != nil {
+		return Result{}, err
+	}
+	defer stop()
+
+	r := &runner{ivr: ivr, conn: conn, uuid: uuid, events: events}
+	name := ivr.Start
+	for name != "" {
+		menu := ivr.Menus[name]
+		r.result.Menus = append(r.result.Menus, name)
+		action, digits, event, err := r.collect(ctx, menu)
+		if err != nil {
+			return r.result, err
+		}
+		name, err = r.perform(ctx, menu, action, digits, event)
+		if err != nil {
+			return r.result, err
+		}
+	}
+	return r.result, nil
+}
+
+// Plays the greeting and collects digits until a valid option is chosen or the retries are exhausted
+func (r *runner) collect(ctx context.Context, menu *Menu) (Action, string, *eslgo.Event, error) {
+	for attempt := 0; ; attempt++ {
+		event, err := r.execute(ctx, "play_and_get_digits", digitsArguments(menu))
+		if err != nil {
+			return Action{}, "", nil, err
+		}
+		digits := event.GetHeader("variable_" + digitsVariable)
+		if action, ok := menu.Options[digits]; ok {
+			r.result.Digits = append(r.result.Digits, digits)
+			return action, digits, event, nil
+		}
+
+		invalid := event.GetHeader("variable_" + digitsVariable + "_invalid")
+		if digits == "" {
+			digits = invalid
+		}
+		r.result.Digits = append(r.result.Digits, digits)
+		prompt := menu.TimeoutPrompt
+		if digits != "" {
+			prompt = menu.InvalidPrompt
+		}
+		if err := r.play(ctx, prompt); err != nil {
+			return Action{}, "", nil, err
+		}
+		if attempt < menu.MaxRetries {
+			continue
+		}
+		if menu.Failure == nil {
+			return Action{}, "", nil, fmt.Errorf("%w in menu %s", ErrMaxRetries, menu.Name)
+		}
+		return *menu.Failure, digits, event, nil
+	}
+}
+
+// Runs the action and returns the name of the next menu, empty when the IVR ended
+func (r *runner) perform(ctx context.Context, menu *Menu, action Action, digits string, event *eslgo.Event) (string, error) {
+	if err := r.play(ctx, action.Prompt); err != nil {
+		return "", err
+	}
+
+	switch action.Type {
+	case ActionSubmenu:
+		r.stack = append(r.stack, menu.Name)
+		return action.Menu, nil
+	case ActionBack:
+		if len(r.stack) == 0 {
+			return menu.Name, nil
+		}
+		previous := r.stack[len(r.stack)-1]
+		r.stack = r.stack[:len(r.stack)-1]
+		return previous, nil
+	case ActionTransfer:
+		r.result.Action = action
+		return "", r.conn.Transfer(ctx, r.uuid, action.Destination, eslgo.TransferOptions{Dialplan: action.Dialplan, Context: action.Context})
+	case ActionSet:
+		response, err := r.conn.SendCommand(ctx, &call.Set{UUID: r.uuid, Key: action.Variable, Value: action.Value, Sync: true})
+		if err != nil {
+			return "", err
+		}
+		if !response.IsOk() {
+			return "", errors.New("ivr: set " + action.Variable + ": " + response.GetReply())
+		}
+		if action.Then != nil {
+			return r.perform(ctx, menu, *action.Then, digits, event)
+		}
+		return menu.Name, nil
+	case ActionCallback:
+		callback := action.Func
+		if callback == nil {
+			callback = r.ivr.Callbacks[action.Callback]
+		}
+		next, err := callback(ctx, &Session{Conn: r.conn, UUID: r.uuid, Menu: menu, Event: event}, digits)
+		if err != nil {
+			return "", err
+		}
+		if next.Type == "" {
+			r.result.Action = action
+			return "", nil
+		}
+		if err := r.ivr.validateAction(next); err != nil {
+			return "", fmt.Errorf("ivr: callback %s: %w", action.Callback, err)
+		}
+		return r.perform(ctx, menu, next, digits, event)
+	case ActionHangup:
+		r.result.Action = action
+		cause := action.Cause
+		if cause == "" {
+			cause = defaultCause
+		}
+		return "", r.conn.HangupCall(ctx, r.uuid, call.HangupCause(cause))
+	}
+	return "", fmt.Errorf("ivr: unknown action type %q", action.Type)
+}
+
+// Plays the prompt if there is one and waits for it to finish
+func (r *runner) play(ctx context.Context, prompt string) error {
+	if prompt == "" {
+		return nil
+	}
+	_, err := r.execute(ctx, "playback", prompt)
+	return err
+}
+
+// Executes the app on the channel and waits for its CHANNEL_EXECUTE_COMPLETE event
+func (r *runner) execute(ctx context.Context, app, args string) (*eslgo.Event, error) {
+	appUUID := eslgo.NewUUID()
+	response, err := r.conn.SendCommand(ctx, &call.Execute{UUID: r.uuid, AppName: app, AppArgs: args, AppUUID: appUUID})
+	if err != nil {
+		return nil, err
+	}
+	if !response.IsOk() {
+		return nil, errors.New("ivr: " + app + ": " + response.GetReply())
+	}
+	for {
+		select {
+		case event, ok := <-r.events:
+			if !ok {
+				return nil, errors.New("ivr: connection closed")
+			}
+			if event.GetName() == "CHANNEL_HANGUP" {
+				return nil, ErrHungUp
+			}
+			if event.GetHeader("Application-UUID") == appUUID {
+				return event, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Builds the play_and_get_digits arguments: min max tries timeout terminators file invalid_file var_name regexp digit_timeout
+func digitsArguments(menu *Menu) string {
+	options := make([]string, 0, len(menu.Options))
+	maxDigits := menu.MaxDigits
+	for digits := range menu.Options {
+		options = append(options, regexp.QuoteMeta(digits))
+		if menu.MaxDigits == 0 && len(digits) > maxDigits {
+			maxDigits = len(digits)
+		}
+	}
+	sort.Strings(options)
+	minDigits := menu.MinDigits
+	if minDigits <= 0 {
+		minDigits = 1
+	}
+	terminators := menu.Terminators
+	if terminators == "" {
+		terminators = defaultTerminators
+	}
+	timeout := time.Duration(menu.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	digitTimeout := time.Duration(menu.DigitTimeout)
+	if digitTimeout <= 0 {
+		digitTimeout = timeout
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(minDigits),
+		strconv.Itoa(maxDigits),
+		"1",
+		strconv.FormatInt(timeout.Milliseconds(), 10),
+		terminators,
+		quoteArgument(menu.Greeting),
+		"silence_stream://250",
+		digitsVariable,
+		"^(" + strings.Join(options, "|") + ")$",
+		strconv.FormatInt(digitTimeout.Milliseconds(), 10),
+	}, " ")
+}
+
+// Quotes an app argument containing spaces so FreeSWITCH keeps it together, Validate rejects greetings containing quotes
+func quoteArgument(arg string) string {
+	if strings.ContainsAny(arg, " \t") {
+		return "'" + arg + "'"
+	}
+	return arg
+}
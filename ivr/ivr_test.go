@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package ivr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/ivr/ivrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestMenus(t *testing.T) *IVR {
+	ivr, err := LoadFile("testdata/menu.yaml")
+	require.NoError(t, err)
+	ivr.Callbacks = map[string]Callback{
+		"lookup": func(ctx context.Context, session *Session, digits string) (Action, error) {
+			return Action{}, nil
+		},
+	}
+	return ivr
+}
+
+func runSimulated(t *testing.T, ivr *IVR, inputs ...string) (*ivrtest.Simulator, Result, error) {
+	sim, err := ivrtest.NewSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sim.Close() })
+	sim.Press(inputs...)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := ivr.Run(ctx, sim.Conn(), sim.UUID())
+	return sim, result, err
+}
+
+func TestLoadFile(t *testing.T) {
+	ivr, err := LoadFile("testdata/menu.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "main", ivr.Start)
+	main := ivr.Menus["main"]
+	assert.Equal(t, "main", main.Name)
+	assert.Equal(t, Duration(3*time.Second), main.Timeout)
+	assert.Equal(t, 2, main.MaxRetries)
+	assert.Equal(t, Action{Type: ActionTransfer, Destination: "1000", Dialplan: "XML", Context: "default"}, main.Options["2"])
+	assert.Equal(t, &Action{Type: ActionBack}, ivr.Menus["sales"].Options["2"].Then)
+
+	// The callback is registered after loading
+	assert.EqualError(t, ivr.Validate(), `ivr: menu main option 9: callback "lookup" is not registered`)
+	assert.NoError(t, loadTestMenus(t).Validate())
+
+	_, err = LoadFile("testdata/menu.txt")
+	assert.Error(t, err)
+}
+
+func TestLoadJSON(t *testing.T) {
+	ivr, err := LoadJSON([]byte(`{"start":"main","menus":{"main":{"greeting":"main.wav","timeout":"2s","options":{"1":{"type":"hangup"}}}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(2*time.Second), ivr.Menus["main"].Timeout)
+	assert.NoError(t, ivr.Validate())
+
+	_, err = LoadJSON([]byte(`{"start":"main","menus":{"main":{"timeout":"soon"}}}`))
+	assert.Error(t, err)
+}
+
+func TestIVR_Validate(t *testing.T) {
+	for name, test := range map[string]struct {
+		ivr IVR
+		err string
+	}{
+		"start":    {IVR{Start: "missing"}, `ivr: start menu "missing" does not exist`},
+		"options":  {IVR{Start: "main", Menus: map[string]*Menu{"main": {}}}, "ivr: menu main has no options"},
+		"submenu":  {IVR{Start: "main", Menus: map[string]*Menu{"main": {Options: map[string]Action{"1": {Type: ActionSubmenu, Menu: "sales"}}}}}, `ivr: menu main option 1: menu "sales" does not exist`},
+		"transfer": {IVR{Start: "main", Menus: map[string]*Menu{"main": {Options: map[string]Action{"1": {Type: ActionTransfer}}}}}, "ivr: menu main option 1: transfer without destination"},
+		"type":     {IVR{Start: "main", Menus: map[string]*Menu{"main": {Options: map[string]Action{"1": {Type: "dance"}}}}}, `ivr: menu main option 1: unknown action type "dance"`},
+		"greeting": {IVR{Start: "main", Menus: map[string]*Menu{"main": {Greeting: "/sounds/it's me.wav", Options: map[string]Action{"1": {Type: ActionHangup}}}}}, `ivr: menu main greeting contains a quote: "/sounds/it's me.wav"`},
+		"failure":  {IVR{Start: "main", Menus: map[string]*Menu{"main": {Options: map[string]Action{"1": {Type: ActionHangup}}, Failure: &Action{Type: ActionSet}}}}, "ivr: menu main failure: set without variable"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.EqualError(t, test.ivr.Validate(), test.err)
+		})
+	}
+}
+
+func TestDigitsArguments(t *testing.T) {
+	menu := &Menu{Greeting: "say:en number pronounced 42", Options: map[string]Action{"1": {}, "*": {}, "10": {}}}
+	assert.Equal(t, `1 2 1 5000 # 'say:en number pronounced 42' silence_stream://250 ivr_digits ^(1|10|\*)$ 5000`, digitsArguments(menu))
+
+	menu = &Menu{Greeting: "main.wav", MinDigits: 2, MaxDigits: 4, Terminators: "*#", Timeout: Duration(3 * time.Second), DigitTimeout: Duration(time.Second), Options: map[string]Action{"12": {}}}
+	assert.Equal(t, `2 4 1 3000 *# main.wav silence_stream://250 ivr_digits ^(12)$ 1000`, digitsArguments(menu))
+}
+
+func TestIVR_Run_Navigation(t *testing.T) {
+	sim, result, err := runSimulated(t, loadTestMenus(t), "1", "2", "2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main", "sales", "main"}, result.Menus)
+	assert.Equal(t, []string{"1", "2", "2"}, result.Digits)
+	assert.Equal(t, ActionTransfer, result.Action.Type)
+	assert.Equal(t, []string{"ivr/main.wav", "ivr/sales.wav", "ivr/main.wav"}, sim.Played())
+	assert.Equal(t, []string{"set language=fr", "transfer 1000 XML default"}, sim.Actions())
+	assert.Equal(t, "fr", sim.Variables()["language"])
+}
+
+func TestIVR_Run_Retries(t *testing.T) {
+	sim, result, err := runSimulated(t, loadTestMenus(t), "", "5", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "5", ""}, result.Digits)
+	assert.Equal(t, Action{Type: ActionHangup, Cause: "NO_USER_RESPONSE"}, result.Action)
+	assert.Equal(t, []string{
+		"ivr/main.wav", "ivr/timeout.wav",
+		"ivr/main.wav", "ivr/invalid.wav",
+		"ivr/main.wav", "ivr/timeout.wav",
+	}, sim.Played())
+	assert.Equal(t, []string{"hangup NO_USER_RESPONSE"}, sim.Actions())
+}
+
+func TestIVR_Run_Callback(t *testing.T) {
+	var calls []string
+	ivr := &IVR{
+		Start: "main",
+		Menus: map[string]*Menu{
+			"main": {
+				Greeting: "main.wav",
+				Options: map[string]Action{
+					"42": {Type: ActionCallback, Func: func(ctx context.Context, session *Session, digits string) (Action, error) {
+						calls = append(calls, session.UUID+" "+session.Menu.Name+" "+digits)
+						if len(calls) == 1 {
+							return Action{Type: ActionSet, Variable: "account", Value: digits}, nil
+						}
+						return Action{Type: ActionHangup, Prompt: "bye.wav"}, nil
+					}},
+				},
+			},
+		},
+	}
+	sim, result, err := runSimulated(t, ivr, "42", "42")
+	require.NoError(t, err)
+	assert.Equal(t, []string{ivrtest.SimulatorUUID + " main 42", ivrtest.SimulatorUUID + " main 42"}, calls)
+	assert.Equal(t, ActionHangup, result.Action.Type)
+	assert.Equal(t, []string{"main.wav", "main.wav", "bye.wav"}, sim.Played())
+	assert.Equal(t, []string{"set account=42", "hangup NORMAL_CLEARING"}, sim.Actions())
+
+	// Callbacks may also end the IVR or fail it
+	_, result, err = runSimulated(t, loadTestMenus(t), "9")
+	require.NoError(t, err)
+	assert.Equal(t, Action{Type: ActionCallback, Callback: "lookup"}, result.Action)
+	ivr.Menus["main"].Options["42"] = Action{Type: ActionCallback, Func: func(context.Context, *Session, string) (Action, error) {
+		return Action{}, errors.New("lookup failed")
+	}}
+	_, _, err = runSimulated(t, ivr, "42")
+	assert.EqualError(t, err, "lookup failed")
+}
+
+func TestIVR_Run_Failures(t *testing.T) {
+	sim, result, err := runSimulated(t, loadTestMenus(t), "1")
+	assert.True(t, errors.Is(err, ErrHungUp))
+	assert.Equal(t, []string{"main", "sales"}, result.Menus)
+	assert.Equal(t, []string{"hangup ORIGINATOR_CANCEL"}, sim.Actions())
+
+	ivr := &IVR{Start: "main", Menus: map[string]*Menu{"main": {Greeting: "main.wav", Options: map[string]Action{"1": {Type: ActionHangup}}}}}
+	_, _, err = runSimulated(t, ivr, "2")
+	assert.True(t, errors.Is(err, ErrMaxRetries))
+	assert.EqualError(t, err, "ivr: max retries reached in menu main")
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+// Package ivrtest emulates a channel on a fake FreeSWITCH so the menu flows of the ivr package can be unit tested without
+// linking the fake server into programs using ivr.
+package ivrtest
+
+import (
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/esltest"
+)
+
+// SimulatorUUID - The UUID of the channel a Simulator emulates
+const SimulatorUUID = "00000000-0000-4000-8000-000000000001"
+
+// Simulator - Emulates a channel on a fake FreeSWITCH so menu flows can be unit tested. Digits are queued with Press and
+// consumed by play_and_get_digits, the channel hangs up with ORIGINATOR_CANCEL once none are left.
+type Simulator struct {
+	server    *esltest.Server
+	conn      *eslgo.Conn
+	lock      sync.Mutex
+	inputs    []string
+	played    []string
+	actions   []string
+	variables map[string]string
+}
+
+// NewSimulator - Starts a fake FreeSWITCH and connects to it, run the IVR with Conn and UUID
+func NewSimulator() (*Simulator, error) {
+	server, err := esltest.NewServer("ClueCon")
+	if err != nil {
+		return nil, err
+	}
+	sim := &Simulator{
+		server:    server,
+		variables: make(map[string]string),
+	}
+	server.HandleCommand("sendmsg", sim.handleMessage)
+	server.HandleAPI("uuid_transfer", sim.handleTransfer)
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	conn, err := opts.Dial(server.Addr())
+	if err != nil {
+		_ = server.Close()
+		return nil, err
+	}
+	sim.conn = conn
+	return sim, nil
+}
+
+// Conn - The connection to run the IVR on
+func (s *Simulator) Conn() *eslgo.Conn {
+	return s.conn
+}
+
+// UUID - The UUID of the simulated channel
+func (s *Simulator) UUID() string {
+	return SimulatorUUID
+}
+
+// Press - Queues the entries of the next digit collections, an empty entry is a timeout
+func (s *Simulator) Press(inputs ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inputs = append(s.inputs, inputs...)
+}
+
+// Played - Returns every prompt played so far, greetings included
+func (s *Simulator) Played() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.played...)
+}
+
+// Actions - Returns the actions taken on the channel so far, e.g. "set language=en", "transfer 1000 XML default" or "hangup NORMAL_CLEARING"
+func (s *Simulator) Actions() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.actions...)
+}
+
+// Variables - Returns the channel variables set so far
+func (s *Simulator) Variables() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	variables := make(map[string]string, len(s.variables))
+	for key, value := range s.variables {
+		variables[key] = value
+	}
+	return variables
+}
+
+// Close - Closes the connection and the fake FreeSWITCH
+func (s *Simulator) Close() error {
+	s.conn.Close()
+	return s.server.Close()
+}
+
+func (s *Simulator) handleMessage(command string) string {
+	headers, body := parseMessage(command)
+	switch headers.Get("Call-Command") {
+	case "execute":
+		args := headers.Get("Execute-App-Arg")
+		if args == "" {
+			args = body
+		}
+		return s.execute(headers.Get("Execute-App-Name"), args, headers.Get("Event-Uuid"))
+	case "hangup":
+		s.hangup(headers.Get("Hangup-Cause"))
+	}
+	return "+OK"
+}
+
+func (s *Simulator) handleTransfer(args string) string {
+	fields := strings.Fields(args)
+	s.lock.Lock()
+	s.actions = append(s.actions, "transfer "+strings.Join(fields[1:], " "))
+	s.lock.Unlock()
+	return "+OK\n"
+}
+
+func (s *Simulator) execute(app, args, appUUID string) string {
+	s.lock.Lock()
+	switch app {
+	case "playback":
+		s.played = append(s.played, args)
+	case "set":
+		parts := strings.SplitN(args, "=", 2)
+		if len(parts) == 2 {
+			s.variables[parts[0]] = parts[1]
+		}
+		s.actions = append(s.actions, "set "+args)
+	case "play_and_get_digits":
+		fields := splitArguments(args)
+		if len(fields) < 9 {
+			s.lock.Unlock()
+			return "-ERR invalid arguments"
+		}
+		s.played = append(s.played, fields[5])
+		if len(s.inputs) == 0 {
+			s.lock.Unlock()
+			s.hangup("ORIGINATOR_CANCEL")
+			return "+OK"
+		}
+		input := s.inputs[0]
+		s.inputs = s.inputs[1:]
+		delete(s.variables, fields[7])
+		delete(s.variables, fields[7]+"_invalid")
+		if valid, err := regexp.MatchString(fields[8], input); err == nil && valid {
+			s.variables[fields[7]] = input
+		} else if input != "" {
+			s.variables[fields[7]+"_invalid"] = input
+		}
+	}
+	headers := map[string]string{
+		"Event-Name":       "CHANNEL_EXECUTE_COMPLETE",
+		"Unique-ID":        SimulatorUUID,
+		"Application":      app,
+		"Application-Data": args,
+		"Application-UUID": appUUID,
+	}
+	for key, value := range s.variables {
+		headers["variable_"+key] = value
+	}
+	s.lock.Unlock()
+
+	// Sent after the reply like FreeSWITCH does
+	go s.server.SendEvent(esltest.Event{Headers: headers})
+	return "+OK"
+}
+
+func (s *Simulator) hangup(cause string) {
+	s.lock.Lock()
+	s.actions = append(s.actions, "hangup "+cause)
+	s.lock.Unlock()
+	go s.server.SendEvent(esltest.Event{Headers: map[string]string{
+		"Event-Name":   "CHANNEL_HANGUP",
+		"Unique-ID":    SimulatorUUID,
+		"Hangup-Cause": cause,
+	}})
+}
+
+// Splits a sendmsg command received by the fake server into its headers and body
+func parseMessage(command string) (textproto.MIMEHeader, string) {
+	headers := make(textproto.MIMEHeader)
+	parts := strings.SplitN(command, "\n\n", 2)
+	for _, line := range strings.Split(parts[0], "\n")[1:] {
+		if pair := strings.SplitN(line, ": ", 2); len(pair) == 2 {
+			headers.Add(pair[0], pair[1])
+		}
+	}
+	if len(parts) == 2 {
+		return headers, parts[1]
+	}
+	return headers, ""
+}
+
+// Splits app arguments on spaces, keeping arguments quoted by the ivr package together
+func splitArguments(args string) []string {
+	var fields []string
+	var current strings.Builder
+	quoted := false
+	for _, char := range args {
+		switch {
+		case char == '\'':
+			quoted = !quoted
+		case char == ' ' && !quoted:
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(char)
+		}
+	}
+	return append(fields, current.String())
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Package ivr drives calls through declarative menus. Menus are defined in Go or loaded from YAML or JSON, and are
+// run on a channel with play_and_get_digits, usually from an outbound session handler.
+package ivr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ActionType - What happens when an option of a menu is chosen
+type ActionType string
+
+const (
+	ActionSubmenu  ActionType = "submenu"  // Enters Action.Menu
+	ActionBack     ActionType = "back"     // Returns to the menu the current one was entered from
+	ActionTransfer ActionType = "transfer" // Transfers the call to Action.Destination and ends the IVR
+	ActionSet      ActionType = "set"      // Sets the channel variable Action.Variable to Action.Value, then runs Action.Then or repeats the menu
+	ActionCallback ActionType = "callback" // Calls Action.Func or the callback registered as Action.Callback, which returns the next action
+	ActionHangup   ActionType = "hangup"   // Hangs up with Action.Cause and ends the IVR
+)
+
+// Duration - A time.Duration written as a Go duration string such as "5s" in YAML and JSON
+type Duration time.Duration
+
+// UnmarshalText - Parses a Go duration string
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText - Formats the duration as a Go duration string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Action - Run when an option is chosen or a menu fails
+type Action struct {
+	Type        ActionType `json:"type" yaml:"type"`
+	Prompt      string     `json:"prompt,omitempty" yaml:"prompt,omitempty"`           // Optional prompt played before the action
+	Menu        string     `json:"menu,omitempty" yaml:"menu,omitempty"`               // ActionSubmenu
+	Destination string     `json:"destination,omitempty" yaml:"destination,omitempty"` // ActionTransfer, the extension
+	Dialplan    string     `json:"dialplan,omitempty" yaml:"dialplan,omitempty"`       // ActionTransfer, defaults to the dialplan of the channel
+	Context     string     `json:"context,omitempty" yaml:"context,omitempty"`         // ActionTransfer, defaults to the context of the channel
+	Variable    string     `json:"variable,omitempty" yaml:"variable,omitempty"`       // ActionSet
+	Value       string     `json:"value,omitempty" yaml:"value,omitempty"`             // ActionSet
+	Then        *Action    `json:"then,omitempty" yaml:"then,omitempty"`               // ActionSet, the action run after setting the variable
+	Callback    string     `json:"callback,omitempty" yaml:"callback,omitempty"`       // ActionCallback, the name of a callback in IVR.Callbacks
+	Func        Callback   `json:"-" yaml:"-"`                                         // ActionCallback, used instead of Callback for menus defined in Go
+	Cause       string     `json:"cause,omitempty" yaml:"cause,omitempty"`             // ActionHangup, defaults to NORMAL_CLEARING
+}
+
+// Menu - Prompts the caller and maps the digits entered to actions
+type Menu struct {
+	Name          string            `json:"-" yaml:"-"`                                               // Set from the key of the menu in IVR.Menus
+	Greeting      string            `json:"greeting" yaml:"greeting"`                                 // The prompt played while collecting digits, a file, phrase:, say: or tone_stream://
+	InvalidPrompt string            `json:"invalid_prompt,omitempty" yaml:"invalid_prompt,omitempty"` // Played when the digits entered match no option
+	TimeoutPrompt string            `json:"timeout_prompt,omitempty" yaml:"timeout_prompt,omitempty"` // Played when no digits were entered
+	MaxRetries    int               `json:"max_retries,omitempty" yaml:"max_retries,omitempty"`       // How many times the menu is repeated after an invalid or missing entry
+	MinDigits     int               `json:"min_digits,omitempty" yaml:"min_digits,omitempty"`         // Defaults to 1
+	MaxDigits     int               `json:"max_digits,omitempty" yaml:"max_digits,omitempty"`         // Defaults to the length of the longest option
+	Terminators   string            `json:"terminators,omitempty" yaml:"terminators,omitempty"`       // Digits ending the entry early. Defaults to #
+	Timeout       Duration          `json:"timeout,omitempty" yaml:"timeout,omitempty"`               // How long to wait for the first digit after the greeting. Defaults to 5s
+	DigitTimeout  Duration          `json:"digit_timeout,omitempty" yaml:"digit_timeout,omitempty"`   // How long to wait between digits. Defaults to Timeout
+	Options       map[string]Action `json:"options" yaml:"options"`                                   // The action of every valid entry, keyed by the digits
+	Failure       *Action           `json:"failure,omitempty" yaml:"failure,omitempty"`               // Run once the retries are exhausted, Run returns ErrMaxRetries when it is nil
+}
+
+// IVR - A set of menus and the callbacks their actions refer to
+type IVR struct {
+	Start     string              `json:"start" yaml:"start"` // The name of the first menu
+	Menus     map[string]*Menu    `json:"menus" yaml:"menus"`
+	Callbacks map[string]Callback `json:"-" yaml:"-"` // Callbacks referred to by name from ActionCallback actions
+}
+
+// LoadYAML - Loads menus from YAML, register the callbacks they refer to before running them
+func LoadYAML(data []byte) (*IVR, error) {
+	ivr := &IVR{}
+	if err := yaml.Unmarshal(data, ivr); err != nil {
+		return nil, fmt.Errorf("ivr: %w", err)
+	}
+	ivr.nameMenus()
+	return ivr, nil
+}
+
+// LoadJSON - Loads menus from JSON, register the callbacks they refer to before running them
+func LoadJSON(data []byte) (*IVR, error) {
+	ivr := &IVR{}
+	if err := json.Unmarshal(data, ivr); err != nil {
+		return nil, fmt.Errorf("ivr: %w", err)
+	}
+	ivr.nameMenus()
+	return ivr, nil
+}
+
+// LoadFile - Loads menus from a .yaml, .yml or .json file
+func LoadFile(path string) (*IVR, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadYAML(data)
+	case ".json":
+		return LoadJSON(data)
+	}
+	return nil, errors.New("ivr: unsupported file type " + path)
+}
+
+// Validate - Checks that the start menu exists and that every action is complete and refers to existing menus and callbacks
+func (ivr *IVR) Validate() error {
+	ivr.nameMenus()
+	if _, ok := ivr.Menus[ivr.Start]; !ok {
+		return fmt.Errorf("ivr: start menu %q does not exist", ivr.Start)
+	}
+	names := make([]string, 0, len(ivr.Menus))
+	for name := range ivr.Menus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		menu := ivr.Menus[name]
+		// play_and_get_digits splits its arguments on spaces, a quote in the greeting would end the quoted argument
+		if strings.Contains(menu.Greeting, "'") {
+			return fmt.Errorf("ivr: menu %s greeting contains a quote: %q", name, menu.Greeting)
+		}
+		if len(menu.Options) == 0 {
+			return fmt.Errorf("ivr: menu %s has no options", name)
+		}
+		for digits, action := range menu.Options {
+			if digits == "" {
+				return fmt.Errorf("ivr: menu %s has an option without digits", name)
+			}
+			if err := ivr.validateAction(action); err != nil {
+				return fmt.Errorf("ivr: menu %s option %s: %w", name, digits, err)
+			}
+		}
+		if menu.Failure != nil {
+			if err := ivr.validateAction(*menu.Failure); err != nil {
+				return fmt.Errorf("ivr: menu %s failure: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (ivr *IVR) validateAction(action Action) error {
+	switch action.Type {
+	case ActionSubmenu:
+		if _, ok := ivr.Menus[action.Menu]; !ok {
+			return fmt.Errorf("menu %q does not exist", action.Menu)
+		}
+	case ActionBack, ActionHangup:
+	case ActionTransfer:
+		if action.Destination == "" {
+			return errors.New("transfer without destination")
+		}
+	case ActionSet:
+		if action.Variable == "" {
+			return errors.New("set without variable")
+		}
+		if action.Then != nil {
+			return ivr.validateAction(*action.Then)
+		}
+	case ActionCallback:
+		if action.Func == nil && ivr.Callbacks[action.Callback] == nil {
+			return fmt.Errorf("callback %q is not registered", action.Callback)
+		}
+	default:
+		return fmt.Errorf("unknown action type %q", action.Type)
+	}
+	return nil
+}
+
+func (ivr *IVR) nameMenus() {
+	for name, menu := range ivr.Menus {
+		menu.Name = name
+	}
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+)
+
+// ErrConnectionDead - The error a connection is closed with when the liveness monitor missed too many checks, test for it with errors.Is
+var ErrConnectionDead = errors.New("connection dead")
+
+// LivenessMode - How the liveness monitor checks a connection
+type LivenessMode int
+
+const (
+	// LivenessHeartbeat - Expects a HEARTBEAT event every interval, no commands are sent
+	LivenessHeartbeat LivenessMode = iota
+	// LivenessStatus - Sends the status api every interval and expects a reply within the timeout
+	LivenessStatus
+)
+
+// LivenessOptions - Used to configure a liveness monitor
+type LivenessOptions struct {
+	Mode      LivenessMode
+	Interval  time.Duration // How often the connection is checked. A HEARTBEAT check is missed when none was received for longer, keep it above the 20 seconds FreeSWITCH sends HEARTBEAT every by default
+	Timeout   time.Duration // How long the status api may take to reply. Defaults to Interval
+	MaxMisses int           // The connection is declared dead after this many checks in a row were missed
+}
+
+// DefaultLivenessOptions - The default options used for fields left empty
+var DefaultLivenessOptions = LivenessOptions{
+	Mode:      LivenessHeartbeat,
+	Interval:  30 * time.Second,
+	MaxMisses: 3,
+}
+
+// HeartbeatStats - What FreeSWITCH last reported about itself, from a HEARTBEAT event or the status api
+type HeartbeatStats struct {
+	Received     time.Time     // When the last heartbeat or status reply was received, zero if none was
+	Uptime       time.Duration // How long FreeSWITCH has been running
+	SessionCount int           // The number of active sessions
+	IdleCPU      float64       // The idle CPU percentage
+	Version      string        // The FreeSWITCH version
+	Misses       int           // The number of checks missed in a row
+}
+
+// LivenessMonitor - Watches a connection and closes it with ErrConnectionDead when FreeSWITCH stops answering, e.g. when
+// the TCP connection is half-open and the receive loop would otherwise block forever
+type LivenessMonitor struct {
+	conn  *Conn
+	opts  LivenessOptions
+	subID string
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+	lock  sync.Mutex
+	stats HeartbeatStats
+	start time.Time // When the monitor started, heartbeats are expected from then on
+	err   error
+}
+
+// MonitorLiveness - Starts a liveness monitor on the connection, it stops when the connection closes or Stop is called.
+// In LivenessHeartbeat mode the HEARTBEAT events are subscribed to with AddSubscription.
+func (c *Conn) MonitorLiveness(ctx context.Context, opts LivenessOptions) (*LivenessMonitor, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultLivenessOptions.Interval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = opts.Interval
+	}
+	if opts.MaxMisses <= 0 {
+		opts.MaxMisses = DefaultLivenessOptions.MaxMisses
+	}
+
+	monitor := &LivenessMonitor{
+		conn:  c,
+		opts:  opts,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		start: time.Now(),
+	}
+	if opts.Mode == LivenessHeartbeat {
+		id, err := c.AddSubscription(ctx, EventSubscription{Events: []string{"HEARTBEAT"}}, monitor.handleHeartbeat)
+		if err != nil {
+			return nil, err
+		}
+		monitor.subID = id
+	}
+	go monitor.loop()
+	return monitor, nil
+}
+
+// Stats - Returns what FreeSWITCH last reported
+func (m *LivenessMonitor) Stats() HeartbeatStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stats
+}
+
+// Done - Closed once the monitor stopped, either because the connection closed, it was declared dead or Stop was called
+func (m *LivenessMonitor) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err - Returns the error the connection was closed with when it was declared dead, nil otherwise
+func (m *LivenessMonitor) Err() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.err
+}
+
+// Stop - Stops the monitor and removes its HEARTBEAT subscription, the connection is left open
+func (m *LivenessMonitor) Stop() {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+}
+
+func (m *LivenessMonitor) loop() {
+	defer close(m.done)
+	if m.subID != "" {
+		defer func() {
+			ctx, cancel := context.WithTimeout(m.conn.runningContext, 5*time.Second)
+			defer cancel()
+			_ = m.conn.RemoveSubscription(ctx, m.subID)
+		}()
+	}
+
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		case <-m.conn.runningContext.Done():
+			return
+		}
+
+		if m.check() {
+			continue
+		}
+		m.lock.Lock()
+		m.stats.Misses++
+		misses := m.stats.Misses
+		m.lock.Unlock()
+		if misses < m.opts.MaxMisses {
+			continue
+		}
+
+		err := fmt.Errorf("%w: %d liveness checks missed", ErrConnectionDead, misses)
+		m.lock.Lock()
+		m.err = err
+		m.lock.Unlock()
+		m.conn.log(LevelError, "Connection declared dead", "misses", misses)
+		m.conn.closeWithError(err)
+		return
+	}
+}
+
+// Runs a check, returns false if it was missed
+func (m *LivenessMonitor) check() bool {
+	if m.opts.Mode == LivenessHeartbeat {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		// Judged by when the last heartbeat arrived, so heartbeats drifting around the ticks are not counted as missed
+		last := m.stats.Received
+		if last.IsZero() {
+			last = m.start
+		}
+		if time.Since(last) > m.opts.Interval {
+			return false
+		}
+		m.stats.Misses = 0
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(m.conn.runningContext, m.opts.Timeout)
+	defer cancel()
+	response, err := m.conn.SendCommand(ctx, command.API{Command: "status"})
+	if err != nil {
+		return false
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stats = parseStatus(string(response.Body), m.stats)
+	m.stats.Received = time.Now()
+	m.stats.Misses = 0
+	return true
+}
+
+func (m *LivenessMonitor) handleHeartbeat(event *Event) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stats.Received = time.Now()
+	if msec, err := strconv.ParseInt(event.GetHeader("Uptime-msec"), 10, 64); err == nil {
+		m.stats.Uptime = time.Duration(msec) * time.Millisecond
+	}
+	if count, err := strconv.Atoi(event.GetHeader("Session-Count")); err == nil {
+		m.stats.SessionCount = count
+	}
+	if idle, err := strconv.ParseFloat(event.GetHeader("Idle-CPU"), 64); err == nil {
+		m.stats.IdleCPU = idle
+	}
+	if version := event.GetHeader("FreeSWITCH-Version"); version != "" {
+		m.stats.Version = version
+	}
+}
+
+var (
+	statusUptime   = regexp.MustCompile(`(\d+) (year|day|hour|minute|second|millisecond|microsecond)s?`)
+	statusVersion  = regexp.MustCompile(`\(Version ([^ )]+)`)
+	statusSessions = regexp.MustCompile(`(?m)^(\d+) session\(s\) - `)
+	statusIdleCPU  = regexp.MustCompile(`idle cpu [\d.]+/([\d.]+)`)
+)
+
+var uptimeUnits = map[string]time.Duration{
+	"year":        365 * 24 * time.Hour,
+	"day":         24 * time.Hour,
+	"hour":        time.Hour,
+	"minute":      time.Minute,
+	"second":      time.Second,
+	"millisecond": time.Millisecond,
+	"microsecond": time.Microsecond,
+}
+
+// Parses the output of the status api, values missing from it are kept from previous
+func parseStatus(body string, previous HeartbeatStats) HeartbeatStats {
+	stats := previous
+	lines := strings.SplitN(body, "\n", 2)
+	if strings.HasPrefix(lines[0], "UP ") {
+		stats.Uptime = 0
+		for _, match := range statusUptime.FindAllStringSubmatch(lines[0], -1) {
+			value, _ := strconv.Atoi(match[1])
+			stats.Uptime += time.Duration(value) * uptimeUnits[match[2]]
+		}
+	}
+	if match := statusVersion.FindStringSubmatch(body); match != nil {
+		stats.Version = match[1]
+	}
+	if match := statusSessions.FindStringSubmatch(body); match != nil {
+		stats.SessionCount, _ = strconv.Atoi(match[1])
+	}
+	if match := statusIdleCPU.FindStringSubmatch(body); match != nil {
+		stats.IdleCPU, _ = strconv.ParseFloat(match[1], 64)
+	}
+	return stats
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStatusOutput = `UP 0 years, 1 day, 2 hours, 3 minutes, 4 seconds, 5 milliseconds, 6 microseconds
+FreeSWITCH (Version 1.10.7-release git 883d2cb 2021-10-21 21:02:32Z 64bit) is ready
+120 session(s) since startup
+7 session(s) - peak 12, last 5min 9
+0 session(s) per Sec out of max 30, peak 3, last 5min 1
+1000 session(s) max
+min idle cpu 0.00/97.50
+Current Stack Size/Max 240K/8192K
+`
+
+type disconnectObserver struct {
+	NilObserver
+	err atomic.Value
+}
+
+func (o *disconnectObserver) Disconnected(err error) {
+	if err != nil {
+		o.err.Store(err)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	stats := parseStatus(testStatusOutput, HeartbeatStats{Misses: 1})
+	assert.Equal(t, HeartbeatStats{
+		Uptime:       26*time.Hour + 3*time.Minute + 4*time.Second + 5*time.Millisecond + 6*time.Microsecond,
+		SessionCount: 7,
+		IdleCPU:      97.5,
+		Version:      "1.10.7-release",
+		Misses:       1,
+	}, stats)
+}
+
+func TestConn_MonitorLiveness_Status(t *testing.T) {
+	stall := make(chan struct{})
+	defer close(stall)
+	var stalled int32
+	reply := func(cmd string) string {
+		if !strings.HasPrefix(cmd, "api status") {
+			return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+		}
+		if atomic.LoadInt32(&stalled) == 1 {
+			// Like a half-open connection, nothing ever comes back
+			<-stall
+		}
+		return "Content-Type: api/response\r\nContent-Length: " + strconv.Itoa(len(testStatusOutput)) + "\r\n\r\n" + testStatusOutput
+	}
+	observer := &disconnectObserver{}
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	opts.Observer = observer
+	server, connection := newFakeServer(false, opts, reply)
+	defer server.close()
+	defer connection.Close()
+
+	monitor, err := connection.MonitorLiveness(context.Background(), LivenessOptions{Mode: LivenessStatus, Interval: 10 * time.Millisecond, MaxMisses: 2})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return monitor.Stats().SessionCount == 7
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, "1.10.7-release", monitor.Stats().Version)
+	assert.NoError(t, monitor.Err())
+
+	atomic.StoreInt32(&stalled, 1)
+	select {
+	case <-monitor.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not declared dead")
+	}
+	assert.True(t, errors.Is(monitor.Err(), ErrConnectionDead))
+	assert.Equal(t, 2, monitor.Stats().Misses)
+	assert.Eventually(t, func() bool {
+		err, _ := observer.err.Load().(error)
+		return errors.Is(err, ErrConnectionDead)
+	}, 5*time.Second, time.Millisecond)
+	_, err = connection.SendCommand(context.Background(), command.API{Command: "status"})
+	assert.Error(t, err)
+}
+
+func TestConn_MonitorLiveness_Heartbeat(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	monitor, err := connection.MonitorLiveness(ctx, LivenessOptions{Interval: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, "event plain HEARTBEAT", server.received()[0])
+	require.NoError(t, server.sendEvent(map[string]string{
+		"Event-Name":         "HEARTBEAT",
+		"Uptime-msec":        "93784005",
+		"Session-Count":      "3",
+		"Idle-CPU":           "99.250000",
+		"FreeSWITCH-Version": "1.10.7-release",
+	}))
+	assert.Eventually(t, func() bool {
+		return monitor.Stats().SessionCount == 3
+	}, 5*time.Second, time.Millisecond)
+	stats := monitor.Stats()
+	assert.Equal(t, 93784005*time.Millisecond, stats.Uptime)
+	assert.Equal(t, 99.25, stats.IdleCPU)
+	assert.Equal(t, "1.10.7-release", stats.Version)
+	assert.WithinDuration(t, time.Now(), stats.Received, time.Minute)
+
+	// Stopping removes the subscription and leaves the connection open
+	monitor.Stop()
+	assert.Equal(t, "nixevent plain HEARTBEAT", server.received()[1])
+	assert.NoError(t, monitor.Err())
+
+	// Without heartbeats the connection is declared dead
+	monitor, err = connection.MonitorLiveness(ctx, LivenessOptions{Interval: 10 * time.Millisecond, MaxMisses: 3})
+	require.NoError(t, err)
+	select {
+	case <-monitor.Done():
+	case <-ctx.Done():
+		t.Fatal("connection not declared dead")
+	}
+	assert.True(t, strings.HasPrefix(monitor.Err().Error(), "connection dead: 3 liveness checks missed"))
+}
+
+func TestConn_MonitorLiveness_HeartbeatDrift(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	monitor, err := connection.MonitorLiveness(context.Background(), LivenessOptions{Interval: 50 * time.Millisecond, MaxMisses: 1})
+	require.NoError(t, err)
+	defer monitor.Stop()
+
+	// Heartbeats arriving late relative to the ticks are not missed as long as they come within the interval
+	for i := 0; i < 10; i++ {
+		time.Sleep(30 * time.Millisecond)
+		require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT"}))
+	}
+	assert.NoError(t, monitor.Err())
+	assert.Equal(t, 0, monitor.Stats().Misses)
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// LogLine - A FreeSWITCH log line, sent on the connection after enabling logs with command.Log
+type LogLine struct {
+	Level    int    // The FreeSWITCH log level, 0 (console) to 7 (debug)
+	Channel  string // The text channel the line was logged on
+	File     string // The source file that logged the line
+	Function string // The source function that logged the line
+	Line     int    // The source line that logged the line
+	UUID     string // The channel UUID the line is about, empty for lines that are not about a channel
+	Text     string // The log message
+}
+
+// LogListener - A function called for every log line received
+type LogListener func(line *LogLine)
+
+func readLogLine(response *RawResponse) *LogLine {
+	level, _ := strconv.Atoi(response.GetHeader("Log-Level"))
+	line, _ := strconv.Atoi(response.GetHeader("Log-Line"))
+	return &LogLine{
+		Level:    level,
+		Channel:  response.GetHeader("Text-Channel"),
+		File:     response.GetHeader("Log-File"),
+		Function: response.GetHeader("Log-Func"),
+		Line:     line,
+		UUID:     response.GetHeader("User-Data"),
+		Text:     string(response.Body),
+	}
+}
+
+// RegisterLogListener - Registers a new listener for log lines, enable logs with command.Log to receive them. Returns the registered listener ID used to remove it.
+func (c *Conn) RegisterLogListener(listener LogListener) string {
+	c.eventListenerLock.Lock()
+	defer c.eventListenerLock.Unlock()
+
+	c.eventListenerCounter++
+	id := fmt.Sprintf("%d", c.eventListenerCounter)
+	c.logListeners[id] = listener
+	return id
+}
+
+// RemoveLogListener - Removes the log listener with the listener ID returned from RegisterLogListener
+func (c *Conn) RemoveLogListener(id string) {
+	c.eventListenerLock.Lock()
+	defer c.eventListenerLock.Unlock()
+
+	delete(c.logListeners, id)
+}
+
+// Log lines are delivered in order from the receive loop so listeners must not block
+func (c *Conn) callLogListeners(response *RawResponse) {
+	line := readLogLine(response)
+
+	c.eventListenerLock.RLock()
+	defer c.eventListenerLock.RUnlock()
+	for _, listener := range c.logListeners {
+		listener(line)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConn_RegisterLogListener(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer connection.Close()
+	defer server.close()
+
+	lines := make(chan *LogLine, 1)
+	id := connection.RegisterLogListener(func(line *LogLine) {
+		lines <- line
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connection.SendCommand(ctx, command.Log{Enabled: true, Level: 7})
+	assert.NoError(t, err)
+
+	text := "2020-01-01 00:00:00.000000 [DEBUG] switch_core_state_machine.c:581 (sofia/internal/1000) Running State Change CS_ROUTING\n"
+	assert.NoError(t, server.write("Content-Type: log/data\nContent-Length: "+strconv.Itoa(len(text))+"\nLog-Level: 7\nText-Channel: 3\nLog-File: switch_core_state_machine.c\nLog-Func: switch_core_session_run\nLog-Line: 581\nUser-Data: 4a5b7a96-9d74-11ea-a42f-2b2ca4d7a5c2\n\n"+text))
+
+	select {
+	case line := <-lines:
+		assert.Equal(t, &LogLine{
+			Level:    7,
+			Channel:  "3",
+			File:     "switch_core_state_machine.c",
+			Function: "switch_core_session_run",
+			Line:     581,
+			UUID:     "4a5b7a96-9d74-11ea-a42f-2b2ca4d7a5c2",
+			Text:     text,
+		}, line)
+	case <-ctx.Done():
+		t.Fatal("log line not received")
+	}
+
+	// The connection keeps working after log lines and removed listeners are no longer called
+	connection.RemoveLogListener(id)
+	assert.NoError(t, server.write("Content-Type: log/data\nContent-Length: 3\nLog-Level: 7\n\nbye"))
+	response, err := connection.SendCommand(ctx, command.Log{Enabled: false})
+	assert.NoError(t, err)
+	assert.True(t, response.IsOk())
+	assert.Empty(t, lines)
+}
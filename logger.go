@@ -1,7 +1,9 @@
 package eslgo
 
 import (
+	"fmt"
 	"log"
+	"strings"
 )
 
 type Logger interface {
@@ -15,23 +17,150 @@ type NilLogger struct{}
 type NormalLogger struct{}
 
 func (l NormalLogger) Debug(format string, args ...interface{}) {
-	log.Print("DEBUG: ")
-	log.Printf(format, args...)
+	log.Printf("DEBUG: "+format, args...)
 }
 func (l NormalLogger) Info(format string, args ...interface{}) {
-	log.Print("INFO: ")
-	log.Printf(format, args...)
+	log.Printf("INFO: "+format, args...)
 }
 func (l NormalLogger) Warn(format string, args ...interface{}) {
-	log.Print("WARN: ")
-	log.Printf(format, args...)
+	log.Printf("WARN: "+format, args...)
 }
 func (l NormalLogger) Error(format string, args ...interface{}) {
-	log.Print("ERROR: ")
-	log.Printf(format, args...)
+	log.Printf("ERROR: "+format, args...)
 }
 
 func (l NilLogger) Debug(string, ...interface{}) {}
 func (l NilLogger) Info(string, ...interface{})  {}
 func (l NilLogger) Warn(string, ...interface{})  {}
 func (l NilLogger) Error(string, ...interface{}) {}
+
+// LogLevel - The severity of a structured log message
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// StructuredLogger - A logger for messages with alternating key/value fields, e.g. Log(LevelInfo, "Connected", "remote_addr", addr).
+// Connections automatically add fields such as remote_addr, direction, channel_uuid and command to their messages.
+type StructuredLogger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// PrintfLogger - Adapts a printf style Logger to a StructuredLogger, fields are appended to the message as key=value
+type PrintfLogger struct {
+	Logger Logger
+}
+
+func (l PrintfLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	line := formatKeyvals(msg, keyvals)
+	switch level {
+	case LevelDebug:
+		l.Logger.Debug("%s\n", line)
+	case LevelInfo:
+		l.Logger.Info("%s\n", line)
+	case LevelWarn:
+		l.Logger.Warn("%s\n", line)
+	default:
+		l.Logger.Error("%s\n", line)
+	}
+}
+
+// WithFields - Returns a StructuredLogger that adds the key/value fields to every message
+func WithFields(logger StructuredLogger, keyvals ...interface{}) StructuredLogger {
+	return fieldLogger{logger: logger, fields: keyvals}
+}
+
+type fieldLogger struct {
+	logger StructuredLogger
+	fields []interface{}
+}
+
+func (l fieldLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.logger.Log(level, msg, append(append([]interface{}{}, l.fields...), keyvals...)...)
+}
+
+// Formats a message and its fields as "msg key=value key2=value2", values containing spaces are quoted
+func formatKeyvals(msg string, keyvals []interface{}) string {
+	var builder strings.Builder
+	builder.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		builder.WriteString(" ")
+		builder.WriteString(fmt.Sprint(keyvals[i]))
+		builder.WriteString("=")
+		if i+1 >= len(keyvals) {
+			builder.WriteString("MISSING")
+			break
+		}
+		value := fmt.Sprint(keyvals[i+1])
+		if strings.ContainsAny(value, " \t\r\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		builder.WriteString(value)
+	}
+	return builder.String()
+}
+
+// Picks the logger for library internal messages from the options, the StructuredLogger takes priority
+func (opts Options) structuredLogger() StructuredLogger {
+	if opts.StructuredLogger != nil {
+		return opts.StructuredLogger
+	}
+	if opts.Logger == nil {
+		return PrintfLogger{Logger: NilLogger{}}
+	}
+	return PrintfLogger{Logger: opts.Logger}
+}
+
+// Hides the password of auth and userauth commands so command messages can be logged safely
+func redactCommand(message string) string {
+	switch commandVerb(message) {
+	case "auth":
+		return "auth " + redactedPassword
+	case "userauth":
+		user := strings.TrimPrefix(message, "userauth ")
+		if index := strings.Index(user, ":"); index >= 0 {
+			user = user[:index]
+		}
+		return "userauth " + user + ":" + redactedPassword
+	}
+	return message
+}
+
+const redactedPassword = "********"
+
+// Logs a library internal message with the connection fields attached
+func (c *Conn) log(level LogLevel, msg string, keyvals ...interface{}) {
+	fields, _ := c.logFields.Load().([]interface{})
+	c.logger.Log(level, msg, append(append([]interface{}{}, fields...), keyvals...)...)
+}
+
+// Sets the fields attached to every message logged by the connection
+func (c *Conn) setLogFields(channelUUID string) {
+	direction := "inbound"
+	if c.outbound {
+		direction = "outbound"
+	}
+	fields := []interface{}{"remote_addr", c.conn.RemoteAddr().String(), "direction", direction}
+	if channelUUID != "" {
+		fields = append(fields, "channel_uuid", channelUUID)
+	}
+	c.logFields.Store(fields)
+}
@@ -0,0 +1,53 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogLogger - Adapts a log/slog Handler to a StructuredLogger
+type SlogLogger struct {
+	Handler slog.Handler
+}
+
+// NewSlogLogger - Creates a StructuredLogger writing to the slog handler, e.g. NewSlogLogger(slog.Default().Handler())
+func NewSlogLogger(handler slog.Handler) StructuredLogger {
+	return SlogLogger{Handler: handler}
+}
+
+func (l SlogLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	slogLevel := slogLevel(level)
+	ctx := context.Background()
+	if !l.Handler.Enabled(ctx, slogLevel) {
+		return
+	}
+	record := slog.NewRecord(time.Now(), slogLevel, msg, 0)
+	record.Add(keyvals...)
+	_ = l.Handler.Handle(ctx, record)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
@@ -0,0 +1,41 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	handler := slog.NewTextHandler(&buffer, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+	logger := NewSlogLogger(handler)
+
+	logger.Log(LevelDebug, "Sending command", "command", "api")
+	assert.Empty(t, buffer.String())
+	logger.Log(LevelWarn, "Error receiving message", "remote_addr", "127.0.0.1:8021", "direction", "inbound")
+	assert.Equal(t, "level=WARN msg=\"Error receiving message\" remote_addr=127.0.0.1:8021 direction=inbound\n", buffer.String())
+}
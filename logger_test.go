@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/stretchr/testify/assert"
+)
+
+type testLogEntry struct {
+	level   LogLevel
+	msg     string
+	keyvals []interface{}
+}
+
+type testStructuredLogger struct {
+	lock    sync.Mutex
+	entries []testLogEntry
+}
+
+func (l *testStructuredLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, testLogEntry{level: level, msg: msg, keyvals: keyvals})
+}
+
+func (l *testStructuredLogger) find(msg string) (testLogEntry, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			return entry, true
+		}
+	}
+	return testLogEntry{}, false
+}
+
+func TestNormalLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	log.SetOutput(&buffer)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	NormalLogger{}.Warn("hello %s\n", "world")
+	assert.Equal(t, "WARN: hello world\n", buffer.String())
+}
+
+func TestPrintfLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	log.SetOutput(&buffer)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	WithFields(PrintfLogger{Logger: NormalLogger{}}, "remote_addr", "127.0.0.1:8021").Log(LevelInfo, "Connected", "reason", "new call", "attempt", 2)
+	assert.Equal(t, "INFO: Connected remote_addr=127.0.0.1:8021 reason=\"new call\" attempt=2\n", buffer.String())
+}
+
+func TestConn_StructuredLogger(t *testing.T) {
+	logger := &testStructuredLogger{}
+	opts := DefaultOptions
+	opts.StructuredLogger = logger
+	opts.LogCommands = true
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connection.SendCommand(ctx, command.Auth{Password: "ClueCon"})
+	assert.Nil(t, err)
+	_, err = connection.SendCommand(ctx, command.Auth{User: "1000@default", Password: "ClueCon"})
+	assert.Nil(t, err)
+
+	entry, ok := logger.find("Sending command")
+	assert.True(t, ok)
+	assert.Equal(t, LevelDebug, entry.level)
+	assert.Equal(t, []interface{}{"remote_addr", "pipe", "direction", "inbound", "command", "auth", "message", "auth ********"}, entry.keyvals)
+	for _, entry := range logger.entries {
+		assert.NotContains(t, fmt.Sprint(entry.keyvals...), "ClueCon")
+	}
+	assert.Equal(t, "userauth 1000@default:********", redactCommand(command.Auth{User: "1000@default", Password: "ClueCon"}.BuildMessage()))
+	assert.False(t, strings.Contains(fmt.Sprintf("%v %#v", command.Auth{Password: "ClueCon"}, command.Auth{Password: "ClueCon"}), "ClueCon"))
+}
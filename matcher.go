@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"net/textproto"
+	"regexp"
+	"sort"
+)
+
+// EventMatcher - Decides which events are delivered to a listener registered with RegisterMatchListener
+type EventMatcher interface {
+	Match(event *Event) bool
+}
+
+// EventMatcherFunc - Adapter to use an ordinary function as an EventMatcher
+type EventMatcherFunc func(event *Event) bool
+
+// Match - Calls f(event)
+func (f EventMatcherFunc) Match(event *Event) bool {
+	return f(event)
+}
+
+// namedMatcher - Implemented by matchers that can only match a known set of event names.
+// Listeners using these matchers are indexed by event name so dispatch does not have to try every listener.
+// A nil result means the matcher can match any event name.
+type namedMatcher interface {
+	eventNames() []string
+}
+
+type eventNameMatcher []string
+type subclassMatcher []string
+type headerMatcher struct {
+	header string
+	value  string
+}
+type headerRegexpMatcher struct {
+	header string
+	regexp *regexp.Regexp
+}
+type allMatcher []EventMatcher
+type anyMatcher []EventMatcher
+type notMatcher struct {
+	matcher EventMatcher
+}
+
+// MatchEventName - Matches events with any of the provided Event-Name values
+func MatchEventName(names ...string) EventMatcher {
+	return eventNameMatcher(names)
+}
+
+// MatchSubclass - Matches CUSTOM events with any of the provided Event-Subclass values
+func MatchSubclass(subclasses ...string) EventMatcher {
+	return subclassMatcher(subclasses)
+}
+
+// MatchHeader - Matches events where the header is equal to value
+func MatchHeader(header, value string) EventMatcher {
+	return headerMatcher{header: header, value: value}
+}
+
+// MatchHeaderRegexp - Matches events where the header matches the regular expression
+func MatchHeaderRegexp(header string, re *regexp.Regexp) EventMatcher {
+	return headerRegexpMatcher{header: header, regexp: re}
+}
+
+// MatchAll - Matches events that match every one of the provided matchers
+func MatchAll(matchers ...EventMatcher) EventMatcher {
+	return allMatcher(matchers)
+}
+
+// MatchAny - Matches events that match at least one of the provided matchers
+func MatchAny(matchers ...EventMatcher) EventMatcher {
+	return anyMatcher(matchers)
+}
+
+// MatchNot - Matches events that do not match the provided matcher
+func MatchNot(matcher EventMatcher) EventMatcher {
+	return notMatcher{matcher: matcher}
+}
+
+func (m eventNameMatcher) Match(event *Event) bool {
+	return containsString(m, event.GetName())
+}
+
+func (m eventNameMatcher) eventNames() []string {
+	return append([]string{}, m...)
+}
+
+func (m subclassMatcher) Match(event *Event) bool {
+	return event.GetName() == "CUSTOM" && containsString(m, event.GetHeader("Event-Subclass"))
+}
+
+func (m subclassMatcher) eventNames() []string {
+	return []string{"CUSTOM"}
+}
+
+func (m headerMatcher) Match(event *Event) bool {
+	return event.HasHeader(m.header) && event.GetHeader(m.header) == m.value
+}
+
+func (m headerMatcher) eventNames() []string {
+	if isEventNameHeader(m.header) {
+		return []string{m.value}
+	}
+	return nil
+}
+
+func (m headerRegexpMatcher) Match(event *Event) bool {
+	return event.HasHeader(m.header) && m.regexp.MatchString(event.GetHeader(m.header))
+}
+
+func (m allMatcher) Match(event *Event) bool {
+	for _, matcher := range m {
+		if !matcher.Match(event) {
+			return false
+		}
+	}
+	return true
+}
+
+// Any event matching all of the matchers must have a name allowed by each of the named ones
+func (m allMatcher) eventNames() []string {
+	var names []string
+	for _, matcher := range m {
+		childNames := matcherEventNames(matcher)
+		if childNames == nil {
+			continue
+		}
+		if names == nil {
+			names = childNames
+			continue
+		}
+		intersection := []string{}
+		for _, name := range names {
+			if containsString(childNames, name) {
+				intersection = append(intersection, name)
+			}
+		}
+		names = intersection
+	}
+	return names
+}
+
+func (m anyMatcher) Match(event *Event) bool {
+	for _, matcher := range m {
+		if matcher.Match(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only indexable when every alternative is
+func (m anyMatcher) eventNames() []string {
+	names := []string{}
+	for _, matcher := range m {
+		childNames := matcherEventNames(matcher)
+		if childNames == nil {
+			return nil
+		}
+		for _, name := range childNames {
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m notMatcher) Match(event *Event) bool {
+	return !m.matcher.Match(event)
+}
+
+// Returns the event names a matcher is limited to, or nil if it could match any event
+func matcherEventNames(matcher EventMatcher) []string {
+	if named, ok := matcher.(namedMatcher); ok {
+		return named.eventNames()
+	}
+	return nil
+}
+
+func isEventNameHeader(header string) bool {
+	return textproto.CanonicalMIMEHeaderKey(header) == "Event-Name"
+}
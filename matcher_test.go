@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventMatcher_Match(t *testing.T) {
+	event := &Event{Headers: map[string][]string{
+		"Event-Name":            {"CUSTOM"},
+		"Event-Subclass":        {"sofia::register"},
+		"Caller-Caller-Id-Name": {"John%20Doe"},
+	}}
+
+	assert.True(t, MatchEventName("CHANNEL_ANSWER", "CUSTOM").Match(event))
+	assert.False(t, MatchEventName("CHANNEL_ANSWER").Match(event))
+	assert.True(t, MatchSubclass("sofia::register").Match(event))
+	assert.True(t, MatchHeader("Caller-Caller-ID-Name", "John Doe").Match(event))
+	assert.True(t, MatchHeaderRegexp("Caller-Caller-ID-Name", regexp.MustCompile("^John")).Match(event))
+	assert.False(t, MatchHeaderRegexp("Missing", regexp.MustCompile(".*")).Match(event))
+	assert.True(t, MatchAll(MatchSubclass("sofia::register"), MatchHeader("Caller-Caller-ID-Name", "John Doe")).Match(event))
+	assert.False(t, MatchAll(MatchSubclass("sofia::register"), MatchHeader("Caller-Caller-ID-Name", "Jane Doe")).Match(event))
+	assert.True(t, MatchAny(MatchEventName("CHANNEL_ANSWER"), MatchSubclass("sofia::register")).Match(event))
+	assert.True(t, MatchNot(MatchEventName("CHANNEL_ANSWER")).Match(event))
+}
+
+func TestEventMatcher_eventNames(t *testing.T) {
+	assert.Equal(t, []string{"A", "B"}, matcherEventNames(MatchEventName("A", "B")))
+	assert.Equal(t, []string{"CUSTOM"}, matcherEventNames(MatchSubclass("sofia::register")))
+	assert.Equal(t, []string{"A"}, matcherEventNames(MatchHeader("event-name", "A")))
+	assert.Nil(t, matcherEventNames(MatchHeader("Unique-ID", "A")))
+	assert.Equal(t, []string{"B"}, matcherEventNames(MatchAll(MatchEventName("A", "B"), MatchHeader("Unique-ID", "A"), MatchEventName("B", "C"))))
+	assert.Equal(t, []string{"A", "CUSTOM"}, matcherEventNames(MatchAny(MatchEventName("A"), MatchSubclass("sofia::register"))))
+	assert.Nil(t, matcherEventNames(MatchAny(MatchEventName("A"), MatchHeader("Unique-ID", "A"))))
+	assert.Nil(t, matcherEventNames(MatchNot(MatchEventName("A"))))
+}
+
+func TestConn_RegisterMatchListener(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	received := make(chan *Event, 2)
+	id := connection.RegisterMatchListener(MatchEventName("CHANNEL_ANSWER"), func(event *Event) {
+		received <- event
+	})
+	unindexed := connection.RegisterMatchListener(MatchHeader("Unique-ID", "abc"), func(event *Event) {})
+	assert.Len(t, connection.matchListenerIndex["CHANNEL_ANSWER"], 1)
+	assert.Len(t, connection.matchListenerIndex[""], 1)
+
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_HANGUP"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_ANSWER"}))
+	select {
+	case event := <-received:
+		assert.Equal(t, "CHANNEL_ANSWER", event.GetName())
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	connection.RemoveMatchListener(id)
+	connection.RemoveMatchListener(unindexed)
+	assert.Empty(t, connection.matchListeners)
+	assert.Empty(t, connection.matchListenerIndex)
+}
+
+func TestConn_Once(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := connection.Once(ctx, MatchAll(MatchEventName("DTMF"), MatchHeader("Unique-ID", "abc")))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "DTMF", "Unique-ID": "def", "DTMF-Digit": "1"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "DTMF", "Unique-ID": "abc", "DTMF-Digit": "2"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "DTMF", "Unique-ID": "abc", "DTMF-Digit": "3"}))
+
+	event, ok := <-events
+	assert.True(t, ok)
+	assert.Equal(t, "2", event.GetHeader("DTMF-Digit"))
+	_, ok = <-events
+	assert.False(t, ok)
+
+	// Cancelling the context closes the channel without an event
+	cancelled, cancelOnce := context.WithCancel(context.Background())
+	events = connection.Once(cancelled, MatchEventName("NEVER"))
+	cancelOnce()
+	_, ok = <-events
+	assert.False(t, ok)
+}
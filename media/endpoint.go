@@ -0,0 +1,302 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Package media receives and sends the audio of a call over the sockets FreeSWITCH opens for the unicast call command.
+// By default FreeSWITCH streams signed 16 bit linear PCM (L16) in host byte order at the sample rate of the channel.
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command/call"
+)
+
+// FlagNative - Streams the audio in the codec of the channel instead of L16
+const FlagNative = "native"
+
+// ErrNoPeer - Returned by Write on a UDP endpoint before FreeSWITCH sent any audio, its address is learnt from the first frame
+var ErrNoPeer = errors.New("media: no audio received from FreeSWITCH yet")
+
+// Options - Used to configure an Endpoint
+type Options struct {
+	Network    string   // udp or tcp, FreeSWITCH connects to the endpoint when tcp is used. Defaults to udp
+	Listen     string   // The local address the endpoint listens on. Defaults to 127.0.0.1:0
+	Advertise  string   // The address FreeSWITCH sends audio to, needed when listening on an unspecified address or behind NAT. Defaults to the listen address
+	FreeSWITCH string   // The local address FreeSWITCH binds for the stream. Defaults to 127.0.0.1:0, FreeSWITCH picks the port
+	Flags      []string // Unicast flags, e.g. FlagNative
+	FrameSize  int      // The maximum size of the UDP datagrams written to FreeSWITCH. Defaults to 320 bytes, 20ms of L16 at 8kHz
+	Buffer     int      // The number of received UDP frames queued for Read, frames received while it is full are dropped. Defaults to 100
+}
+
+// DefaultOptions - The default options used for fields left empty
+var DefaultOptions = Options{
+	Network:    "udp",
+	Listen:     "127.0.0.1:0",
+	FreeSWITCH: "127.0.0.1:0",
+	FrameSize:  320,
+	Buffer:     100,
+}
+
+// Endpoint - The local side of a unicast media stream. Read returns the audio of the channel and Write sends audio
+// to FreeSWITCH, which uses it as the audio read from the channel. Read and Write may be used from different goroutines.
+type Endpoint struct {
+	opts Options
+
+	// udp
+	packetConn *net.UDPConn
+	frames     chan []byte
+	pending    []byte
+	peer       atomic.Value // net.Addr of FreeSWITCH
+	dropped    uint64
+
+	// tcp
+	listener net.Listener
+	stream   net.Conn
+	accepted chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen - Opens the local socket of an endpoint, see Start to also ask FreeSWITCH to stream a channel to it
+func Listen(opts Options) (*Endpoint, error) {
+	if opts.Network == "" {
+		opts.Network = DefaultOptions.Network
+	}
+	if opts.Listen == "" {
+		opts.Listen = DefaultOptions.Listen
+	}
+	if opts.FreeSWITCH == "" {
+		opts.FreeSWITCH = DefaultOptions.FreeSWITCH
+	}
+	if opts.FrameSize <= 0 {
+		opts.FrameSize = DefaultOptions.FrameSize
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultOptions.Buffer
+	}
+
+	endpoint := &Endpoint{opts: opts, closed: make(chan struct{})}
+	switch opts.Network {
+	case "udp":
+		addr, err := net.ResolveUDPAddr("udp", opts.Listen)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.packetConn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.frames = make(chan []byte, opts.Buffer)
+		go endpoint.receiveLoop()
+	case "tcp":
+		var err error
+		endpoint.listener, err = net.Listen("tcp", opts.Listen)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.accepted = make(chan struct{})
+		go endpoint.acceptLoop()
+	default:
+		return nil, errors.New("media: unsupported network " + opts.Network)
+	}
+	return endpoint, nil
+}
+
+// Start - Opens an endpoint and sends the unicast command streaming the channel to it
+func Start(ctx context.Context, conn *eslgo.Conn, uuid string, opts Options) (*Endpoint, error) {
+	endpoint, err := Listen(opts)
+	if err != nil {
+		return nil, err
+	}
+	unicast, err := endpoint.Command(uuid)
+	if err != nil {
+		endpoint.Close()
+		return nil, err
+	}
+	response, err := conn.SendCommand(ctx, unicast)
+	if err == nil && !response.IsOk() {
+		err = errors.New("media: unicast failed: " + response.GetReply())
+	}
+	if err != nil {
+		endpoint.Close()
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// Addr - Returns the local address the endpoint listens on
+func (e *Endpoint) Addr() net.Addr {
+	if e.packetConn != nil {
+		return e.packetConn.LocalAddr()
+	}
+	return e.listener.Addr()
+}
+
+// Command - Returns the unicast command streaming the channel to the endpoint
+func (e *Endpoint) Command(uuid string) (call.Unicast, error) {
+	advertise := e.opts.Advertise
+	if advertise == "" {
+		advertise = e.Addr().String()
+	}
+	remote, err := e.resolve(advertise)
+	if err != nil {
+		return call.Unicast{}, err
+	}
+	local, err := e.resolve(e.opts.FreeSWITCH)
+	if err != nil {
+		return call.Unicast{}, err
+	}
+	return call.Unicast{
+		UUID:   uuid,
+		Local:  local,
+		Remote: remote,
+		Flags:  strings.Join(e.opts.Flags, ","),
+	}, nil
+}
+
+// Dropped - Returns the number of UDP frames dropped because Read did not keep up
+func (e *Endpoint) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Read - Reads the audio received from FreeSWITCH, returns io.EOF once the endpoint is closed or FreeSWITCH ended a TCP stream
+func (e *Endpoint) Read(p []byte) (int, error) {
+	if e.packetConn == nil {
+		stream, err := e.waitStream()
+		if err != nil {
+			return 0, err
+		}
+		return stream.Read(p)
+	}
+
+	if len(e.pending) == 0 {
+		frame, ok := <-e.frames
+		if !ok {
+			return 0, io.EOF
+		}
+		e.pending = frame
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// Write - Sends audio to FreeSWITCH, over UDP it is split in datagrams of at most Options.FrameSize bytes
+func (e *Endpoint) Write(p []byte) (int, error) {
+	if e.packetConn == nil {
+		stream, err := e.waitStream()
+		if err != nil {
+			return 0, err
+		}
+		return stream.Write(p)
+	}
+
+	peer, ok := e.peer.Load().(net.Addr)
+	if !ok {
+		return 0, ErrNoPeer
+	}
+	written := 0
+	for written < len(p) {
+		end := written + e.opts.FrameSize
+		if end > len(p) {
+			end = len(p)
+		}
+		n, err := e.packetConn.WriteTo(p[written:end], peer)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close - Closes the socket of the endpoint, FreeSWITCH stops streaming when the channel is hung up or moved to another app
+func (e *Endpoint) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		if e.packetConn != nil {
+			err = e.packetConn.Close()
+			return
+		}
+		// The listener is already closed when a stream was accepted, Accept returns once it is closed so waiting for it
+		// makes sure a stream accepted meanwhile is closed too
+		_ = e.listener.Close()
+		<-e.accepted
+		if e.stream != nil {
+			err = e.stream.Close()
+		}
+	})
+	return err
+}
+
+// Receives the UDP frames from FreeSWITCH, the address of FreeSWITCH is learnt from them
+func (e *Endpoint) receiveLoop() {
+	defer close(e.frames)
+	buffer := make([]byte, 65536)
+	for {
+		n, addr, err := e.packetConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		e.peer.Store(addr)
+		frame := append([]byte(nil), buffer[:n]...)
+		select {
+		case e.frames <- frame:
+		default:
+			atomic.AddUint64(&e.dropped, 1)
+		}
+	}
+}
+
+// Accepts the TCP connection FreeSWITCH opens for the stream
+func (e *Endpoint) acceptLoop() {
+	defer close(e.accepted)
+	stream, err := e.listener.Accept()
+	if err != nil {
+		return
+	}
+	select {
+	case <-e.closed:
+		_ = stream.Close()
+	default:
+		e.stream = stream
+	}
+	// Only one stream is served by an endpoint
+	_ = e.listener.Close()
+}
+
+func (e *Endpoint) waitStream() (net.Conn, error) {
+	select {
+	case <-e.accepted:
+		if e.stream == nil {
+			return nil, io.EOF
+		}
+		return e.stream, nil
+	case <-e.closed:
+		return nil, io.EOF
+	}
+}
+
+func (e *Endpoint) resolve(address string) (net.Addr, error) {
+	if e.opts.Network == "tcp" {
+		return net.ResolveTCPAddr("tcp", address)
+	}
+	return net.ResolveUDPAddr("udp", address)
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package media
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpoint_UDP(t *testing.T) {
+	endpoint, err := Listen(Options{FrameSize: 4})
+	require.NoError(t, err)
+	defer endpoint.Close()
+
+	// Nothing can be sent before FreeSWITCH streamed audio
+	_, err = endpoint.Write([]byte{1, 2})
+	assert.Equal(t, ErrNoPeer, err)
+
+	freeswitch, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer freeswitch.Close()
+	_, err = freeswitch.WriteTo([]byte{1, 2, 3, 4, 5, 6}, endpoint.Addr())
+	require.NoError(t, err)
+	_, err = freeswitch.WriteTo([]byte{7, 8}, endpoint.Addr())
+	require.NoError(t, err)
+
+	audio := make([]byte, 8)
+	_, err = io.ReadFull(endpoint, audio)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, audio)
+
+	// Written audio is split in frames sent back to the address the audio came from
+	n, err := endpoint.Write([]byte{9, 8, 7, 6, 5, 4})
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	require.NoError(t, freeswitch.SetReadDeadline(time.Now().Add(5*time.Second)))
+	frame := make([]byte, 16)
+	n, err = freeswitch.Read(frame)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{9, 8, 7, 6}, frame[:n])
+	n, err = freeswitch.Read(frame)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{5, 4}, frame[:n])
+
+	require.NoError(t, endpoint.Close())
+	_, err = endpoint.Read(audio)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEndpoint_TCP(t *testing.T) {
+	endpoint, err := Listen(Options{Network: "tcp"})
+	require.NoError(t, err)
+	defer endpoint.Close()
+
+	freeswitch, err := net.Dial("tcp", endpoint.Addr().String())
+	require.NoError(t, err)
+	defer freeswitch.Close()
+	_, err = freeswitch.Write([]byte("audio"))
+	require.NoError(t, err)
+
+	audio := make([]byte, 5)
+	_, err = io.ReadFull(endpoint, audio)
+	require.NoError(t, err)
+	assert.Equal(t, "audio", string(audio))
+
+	_, err = endpoint.Write([]byte("reply"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(freeswitch, audio)
+	require.NoError(t, err)
+	assert.Equal(t, "reply", string(audio))
+
+	require.NoError(t, freeswitch.Close())
+	_, err = endpoint.Read(audio)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEndpoint_TCP_Close(t *testing.T) {
+	// Closing right after FreeSWITCH connected closes the stream whether or not it was accepted yet
+	for i := 0; i < 20; i++ {
+		endpoint, err := Listen(Options{Network: "tcp"})
+		require.NoError(t, err)
+		freeswitch, err := net.Dial("tcp", endpoint.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, endpoint.Close())
+
+		require.NoError(t, freeswitch.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err = freeswitch.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		freeswitch.Close()
+	}
+}
+
+func TestStart(t *testing.T) {
+	server, err := esltest.NewServer("secret")
+	require.NoError(t, err)
+	defer server.Close()
+	sendmsg := make(chan string, 1)
+	server.HandleCommand("sendmsg", func(command string) string {
+		sendmsg <- command
+		if strings.HasPrefix(command, "sendmsg missing") {
+			return "-ERR invalid session id [missing]"
+		}
+		return "+OK"
+	})
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	opts.Password = "secret"
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	defer conn.ExitAndClose()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	endpoint, err := Start(ctx, conn, "abc", Options{Flags: []string{FlagNative}, FreeSWITCH: "127.0.0.1:7000"})
+	require.NoError(t, err)
+	defer endpoint.Close()
+
+	command := <-sendmsg
+	port := strconv.Itoa(endpoint.Addr().(*net.UDPAddr).Port)
+	for _, header := range []string{
+		"sendmsg abc",
+		"Call-Command: unicast",
+		"Local-Ip: 127.0.0.1",
+		"Local-Port: 7000",
+		"Remote-Ip: 127.0.0.1",
+		"Remote-Port: " + port,
+		"Transport: udp",
+		"Flags: native",
+	} {
+		assert.Contains(t, command, header)
+	}
+
+	_, err = Start(ctx, conn, "missing", Options{})
+	assert.EqualError(t, err, "media: unicast failed: -ERR invalid session id [missing]")
+	<-sendmsg
+}
+
+func TestEndpoint_Dropped(t *testing.T) {
+	endpoint, err := Listen(Options{Buffer: 1})
+	require.NoError(t, err)
+	defer endpoint.Close()
+
+	freeswitch, err := net.Dial("udp", endpoint.Addr().String())
+	require.NoError(t, err)
+	defer freeswitch.Close()
+	for i := 0; i < 3; i++ {
+		_, err = freeswitch.Write(bytes.Repeat([]byte{byte(i)}, 2))
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return endpoint.Dropped() == 2
+	}, 5*time.Second, time.Millisecond)
+
+	frame := make([]byte, 4)
+	n, err := endpoint.Read(frame)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0}, frame[:n])
+}
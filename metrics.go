@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsCollector - An in memory Observer that aggregates connection metrics. It can be shared by many connections, the
+// metrics are then totals over all of them, and exported in the Prometheus text format with WritePrometheus or by serving
+// it as an http.Handler.
+type MetricsCollector struct {
+	lock             sync.Mutex
+	commands         map[string]*CommandMetrics
+	replies          map[replyKey]uint64
+	events           map[string]uint64
+	listenersRunning int
+	listenerPanics   uint64
+	reconnects       uint64
+	disconnects      uint64
+}
+
+// CommandMetrics - Aggregated statistics for a single command verb
+type CommandMetrics struct {
+	Count        uint64
+	Errors       uint64
+	TotalLatency time.Duration
+}
+
+// MetricsSnapshot - A point in time copy of the metrics in a MetricsCollector
+type MetricsSnapshot struct {
+	Commands         map[string]CommandMetrics
+	Replies          map[string]uint64 // Keyed by "<content type> ok" or "<content type> error"
+	Events           map[string]uint64
+	ListenersRunning int // Event listener calls in progress, not events waiting to be dispatched
+	ListenerPanics   uint64
+	Reconnects       uint64
+	Disconnects      uint64
+}
+
+type replyKey struct {
+	contentType string
+	ok          bool
+}
+
+// NewMetricsCollector - Creates an empty MetricsCollector, set it as Options.Observer to start collecting
+func NewMetricsCollector() *MetricsCollector {
+	return &MetricsCollector{
+		commands: make(map[string]*CommandMetrics),
+		replies:  make(map[replyKey]uint64),
+		events:   make(map[string]uint64),
+	}
+}
+
+func (m *MetricsCollector) CommandSent(name string, latency time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	metrics, ok := m.commands[name]
+	if !ok {
+		metrics = &CommandMetrics{}
+		m.commands[name] = metrics
+	}
+	metrics.Count++
+	metrics.TotalLatency += latency
+	if err != nil {
+		metrics.Errors++
+	}
+}
+
+func (m *MetricsCollector) ReplyReceived(contentType string, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.replies[replyKey{contentType: contentType, ok: ok}]++
+}
+
+func (m *MetricsCollector) EventReceived(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.events[name]++
+}
+
+func (m *MetricsCollector) ListenerCallsChanged(delta int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.listenersRunning += delta
+}
+
+func (m *MetricsCollector) ListenerPanic(interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.listenerPanics++
+}
+
+func (m *MetricsCollector) Reconnected() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.reconnects++
+}
+
+func (m *MetricsCollector) Disconnected(error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.disconnects++
+}
+
+// Snapshot - Returns a copy of the current metrics
+func (m *MetricsCollector) Snapshot() MetricsSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Commands:         make(map[string]CommandMetrics, len(m.commands)),
+		Replies:          make(map[string]uint64, len(m.replies)),
+		Events:           make(map[string]uint64, len(m.events)),
+		ListenersRunning: m.listenersRunning,
+		ListenerPanics:   m.listenerPanics,
+		Reconnects:       m.reconnects,
+		Disconnects:      m.disconnects,
+	}
+	for name, metrics := range m.commands {
+		snapshot.Commands[name] = *metrics
+	}
+	for key, count := range m.replies {
+		snapshot.Replies[key.contentType+" "+replyResult(key.ok)] = count
+	}
+	for name, count := range m.events {
+		snapshot.Events[name] = count
+	}
+	return snapshot
+}
+
+// WritePrometheus - Writes the current metrics in the Prometheus text exposition format
+func (m *MetricsCollector) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	writer := bufio.NewWriter(w)
+
+	writeMetricHeader(writer, "eslgo_commands_total", "counter", "Commands sent to FreeSWITCH.")
+	for _, name := range sortedKeys(snapshot.Commands) {
+		metrics := snapshot.Commands[name]
+		fmt.Fprintf(writer, "eslgo_commands_total{command=\"%s\",result=\"ok\"} %d\n", escapeLabel(name), metrics.Count-metrics.Errors)
+		fmt.Fprintf(writer, "eslgo_commands_total{command=\"%s\",result=\"error\"} %d\n", escapeLabel(name), metrics.Errors)
+	}
+	writeMetricHeader(writer, "eslgo_command_duration_seconds", "summary", "Time from sending a command to receiving its reply.")
+	for _, name := range sortedKeys(snapshot.Commands) {
+		metrics := snapshot.Commands[name]
+		fmt.Fprintf(writer, "eslgo_command_duration_seconds_sum{command=\"%s\"} %g\n", escapeLabel(name), metrics.TotalLatency.Seconds())
+		fmt.Fprintf(writer, "eslgo_command_duration_seconds_count{command=\"%s\"} %d\n", escapeLabel(name), metrics.Count)
+	}
+
+	writeMetricHeader(writer, "eslgo_replies_total", "counter", "Replies received from FreeSWITCH.")
+	replyKeys := make([]string, 0, len(snapshot.Replies))
+	for key := range snapshot.Replies {
+		replyKeys = append(replyKeys, key)
+	}
+	sort.Strings(replyKeys)
+	for _, key := range replyKeys {
+		split := strings.LastIndex(key, " ")
+		fmt.Fprintf(writer, "eslgo_replies_total{type=\"%s\",result=\"%s\"} %d\n", escapeLabel(key[:split]), key[split+1:], snapshot.Replies[key])
+	}
+
+	writeMetricHeader(writer, "eslgo_events_total", "counter", "Events received from FreeSWITCH.")
+	eventNames := make([]string, 0, len(snapshot.Events))
+	for name := range snapshot.Events {
+		eventNames = append(eventNames, name)
+	}
+	sort.Strings(eventNames)
+	for _, name := range eventNames {
+		fmt.Fprintf(writer, "eslgo_events_total{event=\"%s\"} %d\n", escapeLabel(name), snapshot.Events[name])
+	}
+
+	writeMetricHeader(writer, "eslgo_listeners_running", "gauge", "Event listener calls in progress over all connections.")
+	fmt.Fprintf(writer, "eslgo_listeners_running %d\n", snapshot.ListenersRunning)
+	writeMetricHeader(writer, "eslgo_listener_panics_total", "counter", "Event listener panics recovered.")
+	fmt.Fprintf(writer, "eslgo_listener_panics_total %d\n", snapshot.ListenerPanics)
+	writeMetricHeader(writer, "eslgo_reconnects_total", "counter", "Connections re-established.")
+	fmt.Fprintf(writer, "eslgo_reconnects_total %d\n", snapshot.Reconnects)
+	writeMetricHeader(writer, "eslgo_disconnects_total", "counter", "Connections closed.")
+	fmt.Fprintf(writer, "eslgo_disconnects_total %d\n", snapshot.Disconnects)
+
+	return writer.Flush()
+}
+
+// ServeHTTP - Serves the metrics in the Prometheus text exposition format, e.g. http.Handle("/metrics", collector)
+func (m *MetricsCollector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func replyResult(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "error"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func sortedKeys(commands map[string]CommandMetrics) []string {
+	keys := make([]string, 0, len(commands))
+	for key := range commands {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
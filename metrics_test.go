@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	collector := NewMetricsCollector()
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	opts.Observer = collector
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := connection.SendCommand(ctx, command.API{Command: "status"})
+	assert.Nil(t, err)
+
+	panicked := make(chan struct{})
+	connection.RegisterEventListener(EventListenAll, func(event *Event) {
+		defer close(panicked)
+		panic("listener failure")
+	})
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT"}))
+	select {
+	case <-panicked:
+	case <-ctx.Done():
+		t.Fatal("listener not called")
+	}
+	connection.Close()
+
+	// The panic is recorded after the listener unwinds
+	assert.Eventually(t, func() bool {
+		return collector.Snapshot().ListenerPanics == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	snapshot := collector.Snapshot()
+	assert.Equal(t, uint64(1), snapshot.Commands["api"].Count)
+	assert.Equal(t, uint64(0), snapshot.Commands["api"].Errors)
+	assert.Equal(t, uint64(1), snapshot.Replies["command/reply ok"])
+	assert.Equal(t, uint64(1), snapshot.Events["HEARTBEAT"])
+	assert.Equal(t, 0, snapshot.ListenersRunning)
+	assert.Equal(t, uint64(1), snapshot.Disconnects)
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	assert.Contains(t, string(body), "# TYPE eslgo_commands_total counter\n")
+	assert.Contains(t, string(body), "eslgo_commands_total{command=\"api\",result=\"ok\"} 1\n")
+	assert.Contains(t, string(body), "eslgo_command_duration_seconds_count{command=\"api\"} 1\n")
+	assert.Contains(t, string(body), "eslgo_replies_total{type=\"command/reply\",result=\"ok\"} 1\n")
+	assert.Contains(t, string(body), "eslgo_events_total{event=\"HEARTBEAT\"} 1\n")
+	assert.Contains(t, string(body), "eslgo_listener_panics_total 1\n")
+	assert.Contains(t, string(body), "eslgo_disconnects_total 1\n")
+}
+
+func TestMetricsCollector_Shared(t *testing.T) {
+	collector := NewMetricsCollector()
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	opts.Observer = collector
+
+	// Listeners of both connections block so the depth is the total of calls in progress
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		server, connection := newFakeServer(false, opts, nil)
+		defer server.close()
+		defer connection.Close()
+		connection.RegisterEventListener(EventListenAll, func(event *Event) {
+			started <- struct{}{}
+			<-release
+		})
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT"}))
+	}
+	<-started
+	<-started
+	assert.Equal(t, 2, collector.Snapshot().ListenersRunning)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return collector.Snapshot().ListenersRunning == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(2), collector.Snapshot().Events["HEARTBEAT"])
+}
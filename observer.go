@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"strings"
+	"time"
+)
+
+// Observer - Notified about what a connection is doing, used for metrics and observability.
+// Methods are called from the connection goroutines so they must be safe for concurrent use and must not block.
+type Observer interface {
+	CommandSent(name string, latency time.Duration, err error) // A command finished, name is the command verb such as api, bgapi or sendmsg
+	ReplyReceived(contentType string, ok bool)                 // A command/reply or api/response was received, ok is true for +OK replies
+	EventReceived(name string)                                 // An event was received and parsed
+	ListenerCallsChanged(delta int)                            // An event listener call started (+1) or finished (-1), events waiting to be dispatched are not counted
+	ListenerPanic(recovered interface{})                       // An event listener panicked, the panic was recovered
+	Reconnected()                                              // A lost connection was replaced, called by the cluster package as a Conn never reconnects by itself
+	Disconnected(err error)                                    // The connection was closed, err is the receive error that caused it if any
+}
+
+// NilObserver - An Observer that ignores everything, embed it to only implement some of the methods
+type NilObserver struct{}
+
+func (NilObserver) CommandSent(string, time.Duration, error) {}
+func (NilObserver) ReplyReceived(string, bool)               {}
+func (NilObserver) EventReceived(string)                     {}
+func (NilObserver) ListenerCallsChanged(int)                 {}
+func (NilObserver) ListenerPanic(interface{})                {}
+func (NilObserver) Reconnected()                             {}
+func (NilObserver) Disconnected(error)                       {}
+
+// Calls an event listener keeping track of the listener calls in progress and recovering from panics so one bad listener cannot take down the connection
+func (c *Conn) runListener(listener EventListener, event *Event) {
+	c.observer.ListenerCallsChanged(1)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.log(LevelError, "Event listener panic", "panic", recovered)
+			c.observer.ListenerPanic(recovered)
+		}
+		c.observer.ListenerCallsChanged(-1)
+	}()
+	listener(event)
+}
+
+// Returns the first word of a command message, e.g. "api" for "api status"
+func commandVerb(message string) string {
+	if index := strings.IndexAny(message, " \r\n"); index >= 0 {
+		return message[:index]
+	}
+	return message
+}
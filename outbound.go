@@ -14,6 +14,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/shuguocloud/eslgo/command"
@@ -23,10 +24,22 @@ type OutboundHandler func(ctx context.Context, conn *Conn, connectResponse *RawR
 
 // OutboundOptions - Used to open a new listener for outbound ESL connections from FreeSWITCH
 type OutboundOptions struct {
-	Options                       // Generic common options to both Inbound and Outbound Conn
-	Network         string        // The network type to listen on, should be tcp, tcp4, or tcp6
-	ConnectTimeout  time.Duration // How long should we wait for FreeSWITCH to respond to our "connect" command. 5 seconds is a sane default.
-	ConnectionDelay time.Duration // How long should we wait after connection to start sending commands. 25ms is the recommended default otherwise we can close the connection before FreeSWITCH finishes starting it on their end. https://github.com/signalwire/freeswitch/pull/636
+	Options                         // Generic common options to both Inbound and Outbound Conn
+	Network         string          // The network type to listen on, should be tcp, tcp4, or tcp6
+	ConnectTimeout  time.Duration   // How long should we wait for FreeSWITCH to respond to our "connect" command. 5 seconds is a sane default.
+	ConnectionDelay time.Duration   // How long should we wait after connection to start sending commands. 25ms is the recommended default otherwise we can close the connection before FreeSWITCH finishes starting it on their end. https://github.com/signalwire/freeswitch/pull/636
+	MyEvents        bool            // Send "myevents plain" after connecting so every event of the session channel is delivered
+	Linger          time.Duration   // Send "linger" after connecting so FreeSWITCH keeps the socket open this long after the hangup and post-hangup events such as CHANNEL_HANGUP_COMPLETE still arrive. The session then lasts until FreeSWITCH closes it instead of exiting when the handler returns. Rounded up to whole seconds, 0 disables it
+	Hooks           OutboundHooks   // Optional callbacks following the lifecycle of every session
+	NewRecorder     RecorderFactory // Optional, creates the recorder of every accepted connection e.g. with CreateRecording. It is closed with the connection. Options.Recorder can not be used since a recording holds a single connection
+}
+
+// OutboundHooks - Optional callbacks following the lifecycle of an outbound session. They are called from the connection goroutines and must not block.
+type OutboundHooks struct {
+	OnConnected        func(conn *Conn, connectResponse *RawResponse)     // The session is connected, myevents and linger are set up and the handler is about to be called
+	OnHangup           func(conn *Conn, event *Event)                     // The CHANNEL_HANGUP event of the session channel was received, requires its events e.g. with MyEvents
+	OnDisconnectNotice func(conn *Conn, linger bool, notice *RawResponse) // FreeSWITCH sent text/disconnect-notice, linger is true for Content-Disposition: linger when events still follow until FreeSWITCH closes the socket
+	OnClosed           func(conn *Conn, err error)                        // The connection was closed, err is the receive error that caused it if any
 }
 
 // DefaultOutboundOptions - The default options used for creating the outbound connection
@@ -48,62 +61,147 @@ func ListenAndServe(address string, handler OutboundHandler) error {
 
 // ListenAndServe - Open a new listener for outbound ESL connections from FreeSWITCH with provided options and handle them with the specified handler
 func (opts OutboundOptions) ListenAndServe(address string, handler OutboundHandler) error {
+	if opts.Recorder != nil {
+		return errors.New("a recorder can not be shared by outbound connections, use NewRecorder")
+	}
 	listener, err := net.Listen(opts.Network, address)
 	if err != nil {
 		return err
 	}
-	if opts.Logger != nil {
-		opts.Logger.Info("Listening for new ESL connections on %s\n", listener.Addr().String())
-	}
+	logger := opts.structuredLogger()
+	logger.Log(LevelInfo, "Listening for new ESL connections", "address", listener.Addr().String())
 	for {
 		c, err := listener.Accept()
 		if err != nil {
 			break
 		}
-		conn := newConnection(c, true, opts.Options)
-
-		conn.logger.Info("New outbound connection from %s\n", c.RemoteAddr().String())
-		go conn.dummyLoop()
-		// Does not call the handler directly to ensure closing cleanly
-		go conn.outboundHandle(handler, opts.ConnectionDelay, opts.ConnectTimeout)
+		opts.accept(c, handler)
 	}
 
-	if opts.Logger != nil {
-		opts.Logger.Info("Outbound server shutting down")
-	}
+	logger.Log(LevelInfo, "Outbound server shutting down", "address", listener.Addr().String())
 	return errors.New("connection closed")
 }
 
-func (c *Conn) outboundHandle(handler OutboundHandler, connectionDelay, connectTimeout time.Duration) {
-	ctx, cancel := context.WithTimeout(c.runningContext, connectTimeout)
+// Creates the recorder of a newly accepted connection if requested and starts handling it
+func (opts OutboundOptions) accept(c net.Conn, handler OutboundHandler) *Conn {
+	if opts.NewRecorder != nil {
+		recorder, err := opts.NewRecorder(c.RemoteAddr())
+		if err != nil {
+			// The call is still handled, only without a recording
+			opts.structuredLogger().Log(LevelWarn, "Error creating recorder", "remote", c.RemoteAddr().String(), "error", err)
+		} else {
+			recorder.closeWithConn = true
+			opts.Recorder = recorder
+		}
+	}
+	return opts.serve(c, handler)
+}
+
+// Sets up an outbound ESL connection from FreeSWITCH and starts handling it
+func (opts OutboundOptions) serve(c net.Conn, handler OutboundHandler) *Conn {
+	conn := newConnection(c, true, opts.Options)
+	conn.hooks = opts.Hooks
+
+	conn.log(LevelInfo, "New outbound connection")
+	go conn.dummyLoop()
+	// Does not call the handler directly to ensure closing cleanly
+	go conn.outboundHandle(handler, opts)
+	return conn
+}
+
+func (c *Conn) outboundHandle(handler OutboundHandler, opts OutboundOptions) {
+	ctx, cancel := context.WithTimeout(c.runningContext, opts.ConnectTimeout)
 	response, err := c.SendCommand(ctx, command.Connect{})
+	if err == nil {
+		err = c.setupSession(ctx, response, opts)
+	}
 	cancel()
 	if err != nil {
-		c.logger.Warn("Error connecting to %s error %s", c.conn.RemoteAddr().String(), err.Error())
+		c.log(LevelWarn, "Error connecting", "error", err)
 		// Try closing cleanly first
 		c.Close() // Not ExitAndClose since this error connection is most likely from communication failure
 		return
 	}
-	handler(c.runningContext, c, response)
+	c.setLogFields(response.ChannelUUID())
+	sessionContext, sessionSpan := c.startSessionSpan(response.ChannelUUID())
+	if sessionSpan != nil {
+		defer sessionSpan.End(nil)
+	}
+	if c.hooks.OnConnected != nil {
+		c.hooks.OnConnected(c, response)
+	}
+	handler(sessionContext, c, response)
+
+	if opts.Linger > 0 {
+		// Keep the session until the channel hangs up and FreeSWITCH closes the socket once the remaining events were
+		// sent, dummyLoop closes our end after the linger time
+		<-c.runningContext.Done()
+		return
+	}
 	// XXX This is ugly, the issue with short lived async sockets on our end is if they complete too fast we can actually
 	// close the connection before FreeSWITCH is in a state to close the connection on their end. 25ms is an magic value
 	// found by testing to have no failures on my test system. I started at 1 second and reduced as far as I could go.
 	// TODO This actually may be fixed: https://github.com/signalwire/freeswitch/pull/636
-	time.Sleep(connectionDelay)
+	time.Sleep(opts.ConnectionDelay)
 	c.ExitAndClose()
 }
 
+// Sends the myevents and linger commands requested by the options and starts watching for the hangup of the session channel
+func (c *Conn) setupSession(ctx context.Context, response *RawResponse, opts OutboundOptions) error {
+	if c.hooks.OnHangup != nil {
+		var once sync.Once
+		c.RegisterMatchListener(MatchAll(MatchEventName("CHANNEL_HANGUP"), MatchHeader("Unique-ID", response.ChannelUUID())), func(event *Event) {
+			once.Do(func() {
+				c.hooks.OnHangup(c, event)
+			})
+		})
+	}
+	if opts.MyEvents {
+		reply, err := c.SendCommand(ctx, command.MyEvents{Format: "plain"})
+		if err != nil {
+			return err
+		}
+		if !reply.IsOk() {
+			return errors.New("myevents response is not okay: " + reply.GetReply())
+		}
+		if err := c.trackAllEvents(ctx); err != nil {
+			return err
+		}
+	}
+	if opts.Linger > 0 {
+		seconds := (opts.Linger + time.Second - 1) / time.Second
+		reply, err := c.SendCommand(ctx, command.Linger{Enabled: true, Seconds: seconds})
+		if err != nil {
+			return err
+		}
+		if !reply.IsOk() {
+			return errors.New("linger response is not okay: " + reply.GetReply())
+		}
+	}
+	return nil
+}
+
 func (c *Conn) dummyLoop() {
 	select {
-	case <-c.responseChannels[TypeDisconnect]:
-		c.logger.Info("Disconnect outbound connection", c.conn.RemoteAddr())
-		if c.closeDelay >= 0 {
-			time.AfterFunc(c.closeDelay*time.Second, func() {
+	case notice := <-c.responseChannel(TypeDisconnect):
+		c.log(LevelInfo, "Disconnect outbound connection")
+		if notice == nil {
+			return
+		}
+		// The receive loop sends a notice with an Error header when the socket was closed without one
+		if c.hooks.OnDisconnectNotice != nil && !notice.HasHeader("Error") {
+			c.hooks.OnDisconnectNotice(c, notice.GetHeader("Content-Disposition") == "linger", notice)
+		}
+		c.writeLock.Lock()
+		closeDelay := c.closeDelay
+		c.writeLock.Unlock()
+		if closeDelay >= 0 {
+			time.AfterFunc(closeDelay*time.Second, func() {
 				c.Close()
 			})
 		}
-	case <-c.responseChannels[TypeAuthRequest]:
-		c.logger.Debug("Ignoring auth request on outbound connection", c.conn.RemoteAddr())
+	case <-c.responseChannel(TypeAuthRequest):
+		c.log(LevelDebug, "Ignoring auth request on outbound connection")
 	case <-c.runningContext.Done():
 		return
 	}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Answers connect like FreeSWITCH does for a socket app started in async full mode
+func outboundReply(cmd string) string {
+	if cmd == "connect" {
+		return "Content-Type: command/reply\r\nReply-Text: +OK\r\nUnique-ID: abc\r\nSocket-Mode: async\r\nControl: full\r\n\r\n"
+	}
+	return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+}
+
+func TestOutboundOptions_Lifecycle(t *testing.T) {
+	var lock sync.Mutex
+	var lifecycle []string
+	record := func(step string) {
+		lock.Lock()
+		defer lock.Unlock()
+		lifecycle = append(lifecycle, step)
+	}
+	hungUp := make(chan struct{})
+	closed := make(chan struct{})
+
+	opts := DefaultOutboundOptions
+	opts.Logger = NilLogger{}
+	opts.MyEvents = true
+	opts.Linger = 500 * time.Millisecond
+	opts.Hooks = OutboundHooks{
+		OnConnected: func(conn *Conn, response *RawResponse) {
+			assert.True(t, response.IsAsync())
+			assert.True(t, response.IsFullControl())
+			record("connected")
+		},
+		OnHangup: func(conn *Conn, event *Event) {
+			record("hangup " + event.GetHeader("Hangup-Cause"))
+			close(hungUp)
+		},
+		OnDisconnectNotice: func(conn *Conn, linger bool, notice *RawResponse) {
+			record("disconnect notice linger=" + strconv.FormatBool(linger))
+		},
+		OnClosed: func(conn *Conn, err error) {
+			record("closed")
+			close(closed)
+		},
+	}
+
+	server, client := startFakeServer(outboundReply)
+	defer server.close()
+	completed := make(chan *Event, 1)
+	started := make(chan struct{})
+	opts.serve(client, func(ctx context.Context, conn *Conn, response *RawResponse) {
+		conn.RegisterMatchListener(MatchEventName("CHANNEL_HANGUP_COMPLETE"), func(event *Event) {
+			completed <- event
+		})
+		record("handler")
+		close(started)
+		<-hungUp
+	})
+
+	<-started
+	assert.Equal(t, []string{"connect", "myevents plain", "linger 1"}, server.received())
+	require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "abc", "Hangup-Cause": "NORMAL_CLEARING"}))
+	<-hungUp
+	notice := "Lingering for 1 seconds.\n"
+	require.NoError(t, server.write("Content-Type: text/disconnect-notice\r\nController-Channel-UUID: abc\r\nContent-Disposition: linger\r\nContent-Length: "+strconv.Itoa(len(notice))+"\r\n\r\n"+notice))
+
+	// Events sent after the hangup still reach the session before it closes
+	require.NoError(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_HANGUP_COMPLETE", "Unique-ID": "abc"}))
+	select {
+	case event := <-completed:
+		assert.Equal(t, "abc", event.GetHeader("Unique-ID"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("CHANNEL_HANGUP_COMPLETE not received")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"connected", "handler", "hangup NORMAL_CLEARING", "disconnect notice linger=true", "closed"}, lifecycle)
+	// The session waited for FreeSWITCH instead of sending exit
+	for _, cmd := range server.received() {
+		assert.False(t, strings.HasPrefix(cmd, "exit"))
+	}
+}
+
+func TestOutboundOptions_ExitAfterHandler(t *testing.T) {
+	closed := make(chan struct{})
+	opts := DefaultOutboundOptions
+	opts.Logger = NilLogger{}
+	opts.Hooks.OnClosed = func(conn *Conn, err error) {
+		close(closed)
+	}
+
+	server, client := startFakeServer(outboundReply)
+	defer server.close()
+	opts.serve(client, func(ctx context.Context, conn *Conn, response *RawResponse) {})
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed")
+	}
+	assert.Equal(t, []string{"connect", "exit"}, server.received())
+}
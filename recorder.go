@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Recording format
+ *
+ * A recording is a sequence of entries, one for every message sent or received on the connection, in the order they happened.
+ * Each entry is a header line followed by the message bytes and a newline:
+ *
+ *   <direction> <timestamp> <length>\n
+ *   <length bytes of message data>\n
+ *
+ * direction is ">" for data sent to FreeSWITCH and "<" for data received from FreeSWITCH.
+ * timestamp is the time the message was sent or received in RFC 3339 format with nanoseconds.
+ * length is the decimal number of bytes of message data.
+ *
+ * Sent data is the command exactly as written including the trailing \r\n\r\n, except auth passwords which are redacted.
+ * Received data is the response headers, sorted by name and separated by \n, a blank line and then the body if it has one.
+ */
+
+// RecordedMessage - A single message sent or received on a recorded connection
+type RecordedMessage struct {
+	Sent bool      // True for data sent to FreeSWITCH, false for data received from FreeSWITCH
+	Time time.Time // When the message was sent or received
+	Data []byte    // The raw message data
+}
+
+// Recorder - Writes every message sent and received on a connection to a recording, set it as Options.Recorder for an
+// inbound connection or create one per connection with OutboundOptions.NewRecorder.
+// See ReadRecording and InboundOptions.Replay/OutboundOptions.Replay to play a recording back.
+type Recorder struct {
+	lock          sync.Mutex
+	writer        *bufio.Writer
+	closer        io.Closer
+	err           error
+	closeWithConn bool // Created by OutboundOptions.NewRecorder, the connection closes it
+}
+
+// RecorderFactory - Creates the recorder of a connection accepted from remote, see OutboundOptions.NewRecorder
+type RecorderFactory func(remote net.Addr) (*Recorder, error)
+
+// NewRecorder - Creates a Recorder that writes the recording to w. A recording holds a single connection, so a recorder
+// must only be used by one connection.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{writer: bufio.NewWriter(w)}
+}
+
+// CreateRecording - Creates or truncates the file at path and returns a Recorder writing to it. Close the recorder when done.
+func CreateRecording(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	recorder := NewRecorder(file)
+	recorder.closer = file
+	return recorder, nil
+}
+
+// Err - Returns the first error encountered writing the recording, recording stops after an error
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// Close - Flushes the recording and closes the file if it was created with CreateRecording
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err := r.writer.Flush()
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+		r.closer = nil
+	}
+	return err
+}
+
+func (r *Recorder) recordSent(message string) {
+	r.record(true, []byte(redactCommand(message)+EndOfMessage))
+}
+
+func (r *Recorder) recordReceived(response *RawResponse) {
+	r.record(false, encodeRawResponse(response))
+}
+
+func (r *Recorder) record(sent bool, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return
+	}
+	direction := "<"
+	if sent {
+		direction = ">"
+	}
+	if _, err := fmt.Fprintf(r.writer, "%s %s %d\n", direction, time.Now().UTC().Format(time.RFC3339Nano), len(data)); err != nil {
+		r.err = err
+		return
+	}
+	r.writer.Write(data)
+	r.writer.WriteByte('\n')
+	// Flush every entry so the recording is complete even if the process dies
+	r.err = r.writer.Flush()
+}
+
+// ReadRecording - Reads every message from a recording written by a Recorder
+func ReadRecording(r io.Reader) ([]RecordedMessage, error) {
+	reader := bufio.NewReader(r)
+	var messages []RecordedMessage
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || (fields[0] != ">" && fields[0] != "<") {
+			return messages, errors.New("invalid recording entry: " + strings.TrimSpace(line))
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return messages, err
+		}
+		length, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return messages, err
+		}
+		data := make([]byte, length+1)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return messages, err
+		}
+		messages = append(messages, RecordedMessage{
+			Sent: fields[0] == ">",
+			Time: timestamp,
+			Data: data[:length],
+		})
+	}
+}
+
+// Encodes a response the way FreeSWITCH sends it on the wire, with sorted headers
+func encodeRawResponse(response *RawResponse) []byte {
+	keys := make([]string, 0, len(response.Headers))
+	for key := range response.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	for _, key := range keys {
+		for _, value := range response.Headers[key] {
+			builder.WriteString(key)
+			builder.WriteString(": ")
+			builder.WriteString(value)
+			builder.WriteString("\n")
+		}
+	}
+	builder.WriteString("\n")
+	builder.Write(response.Body)
+	return []byte(builder.String())
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordingReply(cmd string) string {
+	if strings.HasPrefix(cmd, "api") {
+		return "Content-Type: api/response\nContent-Length: 8\n\nUP 0 0 0"
+	}
+	return "Content-Type: command/reply\nReply-Text: +OK accepted\n\n"
+}
+
+// Runs a short inbound session: authenticate, run an api command, execute an app and receive an event
+func runRecordedSession(t *testing.T, connection *Conn) *Event {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := connection.Once(ctx, MatchEventName("CHANNEL_ANSWER"))
+	response, err := connection.SendCommand(ctx, command.API{Command: "status"})
+	assert.Nil(t, err)
+	assert.Equal(t, "UP 0 0 0", string(response.Body))
+	_, err = connection.SendCommand(ctx, &call.Execute{UUID: "abc", AppName: "playback", AppArgs: "test.wav"})
+	assert.Nil(t, err)
+	return <-events
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	opts := DefaultInboundOptions
+	opts.Logger = NilLogger{}
+	opts.Recorder = recorder
+
+	server, client := startFakeServer(recordingReply)
+	go func() {
+		_ = server.write("Content-Type: auth/request\n\n")
+	}()
+	connection, err := opts.connect(client)
+	if !assert.Nil(t, err) {
+		return
+	}
+	go func() {
+		// Wait for the commands so the event is recorded last
+		assert.Eventually(t, func() bool { return len(server.received()) == 3 }, 5*time.Second, time.Millisecond)
+		_ = server.sendEvent(map[string]string{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "abc"})
+	}()
+	event := runRecordedSession(t, connection)
+	require.NotNil(t, event, "CHANNEL_ANSWER not received")
+	assert.Equal(t, "abc", event.GetHeader("Unique-ID"))
+	connection.Close()
+	server.close()
+	assert.Nil(t, recorder.Close())
+	assert.NotContains(t, recording.String(), "ClueCon")
+
+	messages, err := ReadRecording(bytes.NewReader(recording.Bytes()))
+	assert.Nil(t, err)
+	assert.Len(t, messages, 8)
+	assert.False(t, messages[0].Sent)
+	assert.Equal(t, "Content-Type: auth/request\n\n", string(messages[0].Data))
+	assert.True(t, messages[1].Sent)
+	assert.Equal(t, "auth ********\r\n\r\n", string(messages[1].Data))
+
+	// Replaying gives the same results without a server
+	opts.Recorder = nil
+	replayed, replay, err := opts.Replay(bytes.NewReader(recording.Bytes()))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer replayed.Close()
+	event = runRecordedSession(t, replayed)
+	require.NotNil(t, event, "replayed CHANNEL_ANSWER not received")
+	assert.Equal(t, "abc", event.GetHeader("Unique-ID"))
+	<-replay.Done()
+	assert.Nil(t, replay.Err())
+}
+
+func TestOutboundOptions_NewRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eslgo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var recorders []*Recorder
+	closed := make(chan struct{}, 2)
+	opts := DefaultOutboundOptions
+	opts.Logger = NilLogger{}
+	opts.NewRecorder = func(remote net.Addr) (*Recorder, error) {
+		recorder, err := CreateRecording(filepath.Join(dir, strconv.Itoa(len(recorders))+".rec"))
+		recorders = append(recorders, recorder)
+		return recorder, err
+	}
+	opts.Hooks.OnClosed = func(conn *Conn, err error) {
+		closed <- struct{}{}
+	}
+
+	// Every connection gets its own recording, closed with the connection
+	for _, uuid := range []string{"abc", "def"} {
+		server, client := startFakeServer(func(cmd string) string {
+			return strings.Replace(outboundReply(cmd), "Unique-ID: abc", "Unique-ID: "+uuid, 1)
+		})
+		opts.accept(client, func(ctx context.Context, conn *Conn, response *RawResponse) {})
+		<-closed
+		server.close()
+	}
+	require.Len(t, recorders, 2)
+	for i, uuid := range []string{"abc", "def"} {
+		assert.Nil(t, recorders[i].closer)
+		data, err := ioutil.ReadFile(filepath.Join(dir, strconv.Itoa(i)+".rec"))
+		require.NoError(t, err)
+		messages, err := ReadRecording(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.True(t, len(messages) >= 2)
+		assert.Equal(t, "connect\r\n\r\n", string(messages[0].Data))
+		assert.Contains(t, string(messages[1].Data), "Unique-Id: "+uuid)
+	}
+
+	// A single recorder can not hold the connections of a server
+	opts.Recorder = NewRecorder(ioutil.Discard)
+	assert.Error(t, opts.ListenAndServe("127.0.0.1:0", nil))
+}
+
+func TestReplayConn_Mismatch(t *testing.T) {
+	replay := NewReplayConn([]RecordedMessage{
+		{Sent: true, Data: []byte("api status\r\n\r\n")},
+		{Data: []byte("Content-Type: api/response\nContent-Length: 2\n\nOK")},
+	})
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	connection := newConnection(replay, false, opts)
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connection.SendCommand(ctx, command.API{Command: "version"})
+	assert.Nil(t, err)
+	<-replay.Done()
+	assert.NotNil(t, replay.Err())
+}
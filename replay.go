@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ReplayConn - A net.Conn that plays back the FreeSWITCH side of a recording. Received messages are written to the connection
+// in order, each one only after the commands recorded before it were sent, so replays are deterministic.
+type ReplayConn struct {
+	net.Conn
+	server net.Conn
+	lock   sync.Mutex
+	err    error
+	done   chan struct{}
+}
+
+// NewReplayConn - Creates a connection that plays back the recorded messages. The FreeSWITCH side stays open after the
+// last message was played until the client closes the connection, so the events played last are still delivered.
+func NewReplayConn(messages []RecordedMessage) *ReplayConn {
+	server, client := net.Pipe()
+	replay := &ReplayConn{
+		Conn:   client,
+		server: server,
+		done:   make(chan struct{}),
+	}
+	go replay.play(messages)
+	return replay
+}
+
+// Err - Returns the first difference between the commands sent and the recording, nil if they all matched
+func (r *ReplayConn) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// Done - Returns a channel closed once every recorded message was played back
+func (r *ReplayConn) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *ReplayConn) play(messages []RecordedMessage) {
+	reader := bufio.NewReader(r.server)
+	r.playMessages(reader, messages)
+	close(r.done)
+	// Commands sent after the end of the recording are discarded until the client closes the connection
+	_, _ = io.Copy(ioutil.Discard, reader)
+	_ = r.server.Close()
+}
+
+func (r *ReplayConn) playMessages(reader *bufio.Reader, messages []RecordedMessage) {
+	for i, message := range messages {
+		if !message.Sent {
+			if _, err := r.server.Write(message.Data); err != nil {
+				r.fail(fmt.Errorf("message %d: %w", i, err))
+				return
+			}
+			continue
+		}
+		sent, err := readCommandMessage(reader)
+		if err != nil {
+			r.fail(fmt.Errorf("message %d: expected command %q: %w", i, message.Data, err))
+			return
+		}
+		if normalizeCommand(redactCommand(sent)) != normalizeCommand(string(message.Data)) {
+			r.fail(fmt.Errorf("message %d: expected command %q got %q", i, message.Data, redactCommand(sent)))
+		}
+	}
+}
+
+func (r *ReplayConn) fail(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Replay - Plays back a recording of an inbound connection, including authentication, and returns the connection
+// along with the ReplayConn used to check the commands sent matched the recording
+func (opts InboundOptions) Replay(recording io.Reader) (*Conn, *ReplayConn, error) {
+	messages, err := ReadRecording(recording)
+	if err != nil {
+		return nil, nil, err
+	}
+	replay := NewReplayConn(messages)
+	conn, err := opts.connect(replay)
+	return conn, replay, err
+}
+
+// Replay - Plays back a recording of an outbound connection, calling the handler just like ListenAndServe would for a new connection
+func (opts OutboundOptions) Replay(recording io.Reader, handler OutboundHandler) (*Conn, *ReplayConn, error) {
+	messages, err := ReadRecording(recording)
+	if err != nil {
+		return nil, nil, err
+	}
+	replay := NewReplayConn(messages)
+	return opts.serve(replay, handler), replay, nil
+}
+
+// Reads a single command as sent by Conn.SendCommand, including the body of commands like sendmsg that set a Content-Length
+func readCommandMessage(reader *bufio.Reader) (string, error) {
+	var builder strings.Builder
+	length := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(line)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if builder.Len() <= 2 {
+				// Skip the terminator sent after a command body
+				builder.Reset()
+				continue
+			}
+			break
+		}
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && textproto.CanonicalMIMEHeaderKey(parts[0]) == "Content-Length" {
+			length, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	}
+	if length > 0 {
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return "", err
+		}
+		builder.Write(body)
+		builder.WriteString(EndOfMessage)
+	}
+	return builder.String(), nil
+}
+
+// Sorts the header lines of a command so commands built from maps compare equal regardless of header order
+func normalizeCommand(message string) string {
+	message = strings.TrimRight(message, "\r\n")
+	parts := strings.SplitN(message, "\r\n\r\n", 2)
+	lines := strings.Split(parts[0], "\r\n")
+	sort.Strings(lines[1:])
+	parts[0] = strings.Join(lines, "\r\n")
+	return strings.Join(parts, "\r\n\r\n")
+}
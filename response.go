@@ -27,6 +27,7 @@ const (
 	TypeAPIResponse = `api/response`
 	TypeAuthRequest = `auth/request`
 	TypeDisconnect  = `text/disconnect-notice`
+	TypeLogData     = `log/data`
 )
 
 // RawResponse This struct contains all response data from FreeSWITCH
@@ -56,6 +57,9 @@ func (c *Conn) readResponse() (*RawResponse, error) {
 		}
 	}
 
+	if c.recorder != nil {
+		c.recorder.recordReceived(response)
+	}
 	return response, nil
 }
 
@@ -78,6 +82,17 @@ func (r RawResponse) ChannelUUID() string {
 	return r.GetHeader("Unique-ID")
 }
 
+// IsAsync Helper to check if an outbound session was started by the socket app in async mode, uses the Socket-Mode header of the connect response
+func (r RawResponse) IsAsync() bool {
+	return r.GetHeader("Socket-Mode") == "async"
+}
+
+// IsFullControl Helper to check if an outbound session was started by the socket app in full mode, allowing api commands and events
+// of other channels. Uses the Control header of the connect response
+func (r RawResponse) IsFullControl() bool {
+	return r.GetHeader("Control") == "full"
+}
+
 // HasHeader Helper to check if the RawResponse has a header
 func (r RawResponse) HasHeader(header string) bool {
 	_, ok := r.Headers[textproto.CanonicalMIMEHeaderKey(header)]
@@ -0,0 +1,321 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSinkBufferFull - Reported to SinkOptions.OnDrop for events dropped because the sink buffer was full
+var ErrSinkBufferFull = errors.New("sink buffer full")
+
+// EventSink - A destination events are forwarded to by an EventBridge, see JSONLinesSink, WebhookSink and ChannelSink
+type EventSink interface {
+	// WriteEvents - Delivers a batch of events in the order they were received. Returning an error retries the whole batch.
+	WriteEvents(ctx context.Context, events []*Event) error
+	// Close - Flushes anything buffered by the sink and releases its resources
+	Close() error
+}
+
+// SinkOptions - Used to configure how an EventBridge delivers events to a sink
+type SinkOptions struct {
+	Filter        EventMatcher                     // Only events accepted by the filter are sent to the sink. Can be nil to send all events.
+	Buffer        int                              // How many events can be queued for the sink. Events received while the buffer is full are dropped instead of blocking the connection.
+	BatchSize     int                              // The maximum number of events passed to a single WriteEvents call.
+	BatchInterval time.Duration                    // How long to wait for a batch to fill up. Zero sends whatever is queued right away.
+	MaxRetries    int                              // How many times a failed batch is retried before it is dropped.
+	RetryBackoff  time.Duration                    // How long to wait before the first retry, doubled for every following retry.
+	MaxBackoff    time.Duration                    // The longest wait between retries.
+	DrainTimeout  time.Duration                    // How long RemoveSink and Close wait for queued events to be delivered before cancelling the delivery.
+	OnDrop        func(events []*Event, err error) // An optional function called with events dropped because the buffer was full or every retry failed.
+}
+
+// DefaultSinkOptions - Sensible options for a sink, also used by EventBridge.AddSink for fields left zero
+var DefaultSinkOptions = SinkOptions{
+	Buffer:       1024,
+	BatchSize:    1,
+	MaxRetries:   3,
+	RetryBackoff: 100 * time.Millisecond,
+	MaxBackoff:   5 * time.Second,
+	DrainTimeout: 5 * time.Second,
+}
+
+// SinkStats - Delivery counters for a single sink
+type SinkStats struct {
+	Queued    int    // Events waiting to be delivered
+	Delivered uint64 // Events delivered successfully
+	Dropped   uint64 // Events dropped because the buffer was full or every retry failed
+	Retries   uint64 // Failed WriteEvents calls that were retried
+}
+
+// EventBridge - Fans events received on a connection out to multiple sinks. Every sink has its own buffer and delivery
+// goroutine, so a slow or failing sink never holds up the connection or the other sinks.
+type EventBridge struct {
+	conn       *Conn
+	lock       sync.Mutex
+	sinks      map[string]*bridgeSink
+	listenerID string
+	ctx        context.Context
+	cancel     func()
+	closed     bool
+}
+
+type bridgeSink struct {
+	sink      EventSink
+	opts      SinkOptions
+	queue     chan *Event
+	done      chan struct{}
+	ctx       context.Context // Cancelled when the sink is removed and its queue could not be drained in time
+	cancel    func()
+	delivered uint64
+	dropped   uint64
+	retries   uint64
+}
+
+// NewEventBridge - Attaches a bridge to the connection. Note: the bridge only forwards events FreeSWITCH already sends, see AddSubscription or EnableEvents.
+func NewEventBridge(conn *Conn) *EventBridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	bridge := &EventBridge{
+		conn:   conn,
+		sinks:  make(map[string]*bridgeSink),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	// Called inline from the dispatch loop so events are queued in the order they were received, must never block
+	bridge.listenerID = conn.registerMatchListener(EventMatcherFunc(func(*Event) bool { return true }), bridge.dispatch, true)
+	return bridge
+}
+
+// AddSink - Starts forwarding events to the sink under the name, zero Buffer, BatchSize, backoff and DrainTimeout fields of opts
+// are taken from DefaultSinkOptions
+func (b *EventBridge) AddSink(name string, sink EventSink, opts SinkOptions) error {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultSinkOptions.Buffer
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultSinkOptions.BatchSize
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultSinkOptions.RetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultSinkOptions.MaxBackoff
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultSinkOptions.DrainTimeout
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return errors.New("event bridge closed")
+	}
+	if _, ok := b.sinks[name]; ok {
+		return errors.New("sink already added: " + name)
+	}
+	ctx, cancel := context.WithCancel(b.ctx)
+	added := &bridgeSink{
+		sink:   sink,
+		opts:   opts,
+		queue:  make(chan *Event, opts.Buffer),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	b.sinks[name] = added
+	go b.deliver(added)
+	return nil
+}
+
+// RemoveSink - Stops forwarding events to the sink, delivers the events already queued and closes it. Events still queued
+// after the DrainTimeout of the sink are dropped and the WriteEvents call in progress has its context cancelled.
+func (b *EventBridge) RemoveSink(name string) error {
+	b.lock.Lock()
+	removed, ok := b.sinks[name]
+	delete(b.sinks, name)
+	b.lock.Unlock()
+	if !ok {
+		return errors.New("no sink named " + name)
+	}
+	close(removed.queue)
+	drain := time.NewTimer(removed.opts.DrainTimeout)
+	defer drain.Stop()
+	select {
+	case <-removed.done:
+	case <-drain.C:
+		b.conn.log(LevelWarn, "Sink did not drain in time, dropping queued events", "sink", name, "queued", len(removed.queue))
+		removed.cancel()
+		<-removed.done
+	}
+	removed.cancel()
+	return removed.sink.Close()
+}
+
+// Stats - Returns the delivery counters of every sink by name
+func (b *EventBridge) Stats() map[string]SinkStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stats := make(map[string]SinkStats, len(b.sinks))
+	for name, sink := range b.sinks {
+		stats[name] = SinkStats{
+			Queued:    len(sink.queue),
+			Delivered: atomic.LoadUint64(&sink.delivered),
+			Dropped:   atomic.LoadUint64(&sink.dropped),
+			Retries:   atomic.LoadUint64(&sink.retries),
+		}
+	}
+	return stats
+}
+
+// Close - Detaches the bridge from the connection, delivers the events already queued and closes every sink.
+// Returns the first error returned by a sink's Close.
+func (b *EventBridge) Close() error {
+	b.conn.RemoveMatchListener(b.listenerID)
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return nil
+	}
+	b.closed = true
+	names := make([]string, 0, len(b.sinks))
+	for name := range b.sinks {
+		names = append(names, name)
+	}
+	b.lock.Unlock()
+
+	// Drained in parallel so sinks that do not keep up only hold Close for a single DrainTimeout
+	errs := make([]error, len(names))
+	var wait sync.WaitGroup
+	for i, name := range names {
+		wait.Add(1)
+		go func(i int, name string) {
+			defer wait.Done()
+			errs[i] = b.RemoveSink(name)
+		}(i, name)
+	}
+	wait.Wait()
+	b.cancel()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Queues the event for every sink whose filter accepts it. Called from the dispatch loop with the listener lock held.
+func (b *EventBridge) dispatch(event *Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, sink := range b.sinks {
+		if sink.opts.Filter != nil && !sink.opts.Filter.Match(event) {
+			continue
+		}
+		select {
+		case sink.queue <- event:
+		default:
+			atomic.AddUint64(&sink.dropped, 1)
+			b.conn.log(LevelWarn, "Sink buffer full, dropped event", "event", event.GetName())
+			if sink.opts.OnDrop != nil {
+				sink.opts.OnDrop([]*Event{event}, ErrSinkBufferFull)
+			}
+		}
+	}
+}
+
+// Delivers the queued events of a sink in batches until its queue is closed
+func (b *EventBridge) deliver(sink *bridgeSink) {
+	defer close(sink.done)
+	for {
+		event, ok := <-sink.queue
+		if !ok {
+			return
+		}
+		batch := []*Event{event}
+		open := true
+		var timeout <-chan time.Time
+		if sink.opts.BatchInterval > 0 {
+			timeout = time.After(sink.opts.BatchInterval)
+		}
+
+	fill:
+		for len(batch) < sink.opts.BatchSize {
+			if timeout == nil {
+				// No interval, only take what is already queued
+				select {
+				case event, open = <-sink.queue:
+				default:
+					break fill
+				}
+			} else {
+				select {
+				case event, open = <-sink.queue:
+				case <-timeout:
+					break fill
+				}
+			}
+			if !open {
+				break
+			}
+			batch = append(batch, event)
+		}
+
+		b.writeBatch(sink, batch)
+		if !open {
+			return
+		}
+	}
+}
+
+// Writes a batch to the sink, retrying with exponential backoff before dropping it. Once the delivery is cancelled
+// batches are dropped without calling the sink.
+func (b *EventBridge) writeBatch(sink *bridgeSink, batch []*Event) {
+	backoff := sink.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := sink.ctx.Err()
+		if err == nil {
+			err = sink.sink.WriteEvents(sink.ctx, batch)
+		}
+		if err == nil {
+			atomic.AddUint64(&sink.delivered, uint64(len(batch)))
+			return
+		}
+		if attempt >= sink.opts.MaxRetries || sink.ctx.Err() != nil {
+			b.dropBatch(sink, batch, err)
+			return
+		}
+		atomic.AddUint64(&sink.retries, 1)
+		b.conn.log(LevelWarn, "Sink failed, retrying", "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-sink.ctx.Done():
+			b.dropBatch(sink, batch, sink.ctx.Err())
+			return
+		}
+		backoff *= 2
+		if backoff > sink.opts.MaxBackoff {
+			backoff = sink.opts.MaxBackoff
+		}
+	}
+}
+
+func (b *EventBridge) dropBatch(sink *bridgeSink, batch []*Event, err error) {
+	atomic.AddUint64(&sink.dropped, uint64(len(batch)))
+	b.conn.log(LevelError, "Sink failed, dropped events", "events", len(batch), "error", err)
+	if sink.opts.OnDrop != nil {
+		sink.opts.OnDrop(batch, err)
+	}
+}
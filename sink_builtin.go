@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// JSONLinesSink - Writes every event as a JSON object on its own line, encoded with Event.EncodeJSON in the layout FreeSWITCH
+// uses for json events.
+type JSONLinesSink struct {
+	lock   sync.Mutex
+	writer *bufio.Writer
+	closer io.Closer
+}
+
+// NewJSONLinesSink - Creates a sink writing JSON Lines to w
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{writer: bufio.NewWriter(w)}
+}
+
+// CreateJSONLinesFile - Creates a sink appending JSON Lines to the file at path, the file is created if needed and closed with the sink
+func CreateJSONLinesFile(path string) (*JSONLinesSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewJSONLinesSink(file)
+	sink.closer = file
+	return sink, nil
+}
+
+func (s *JSONLinesSink) WriteEvents(ctx context.Context, events []*Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, event := range events {
+		data, err := event.EncodeJSON()
+		if err != nil {
+			return err
+		}
+		s.writer.Write(data)
+		s.writer.WriteByte('\n')
+	}
+	// Flush every batch so a retried batch is never half written
+	return s.writer.Flush()
+}
+
+func (s *JSONLinesSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.writer.Flush()
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+		s.closer = nil
+	}
+	return err
+}
+
+// WebhookSink - POSTs every batch to a URL as a JSON array of events in the JSONLinesSink layout. Responses other than 2xx are errors so the batch is retried.
+type WebhookSink struct {
+	URL     string       // The URL batches are posted to
+	Client  *http.Client // The client used for requests, http.DefaultClient when nil
+	Headers http.Header  // Extra headers added to every request, e.g. Authorization
+}
+
+// NewWebhookSink - Creates a sink posting batches to the URL with http.DefaultClient
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url}
+}
+
+func (s *WebhookSink) WriteEvents(ctx context.Context, events []*Event) error {
+	// Joined by hand, json.Marshal would escape the <, > and & EncodeJSON leaves as they are
+	encoded := make([][]byte, len(events))
+	for i, event := range events {
+		data, err := event.EncodeJSON()
+		if err != nil {
+			return err
+		}
+		encoded[i] = data
+	}
+	data := append(append([]byte{'['}, bytes.Join(encoded, []byte{','})...), ']')
+
+	request, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	for key, values := range s.Headers {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", s.URL, response.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+// ChannelSink - Sends events to a Go channel, blocking until they are received so the sink buffer absorbs slow readers.
+// The channel is not closed by the sink.
+type ChannelSink struct {
+	channel chan<- *Event
+}
+
+// NewChannelSink - Creates a sink sending events to the channel
+func NewChannelSink(channel chan<- *Event) *ChannelSink {
+	return &ChannelSink{channel: channel}
+}
+
+func (s *ChannelSink) WriteEvents(ctx context.Context, events []*Event) error {
+	for _, event := range events {
+		select {
+		case s.channel <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *ChannelSink) Close() error {
+	return nil
+}
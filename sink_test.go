@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBridge_FanOut(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	bridge := NewEventBridge(connection)
+	var lines bytes.Buffer
+	assert.Nil(t, bridge.AddSink("file", NewJSONLinesSink(&lines), DefaultSinkOptions))
+	answered := make(chan *Event, 10)
+	assert.Nil(t, bridge.AddSink("answered", NewChannelSink(answered), SinkOptions{Filter: MatchEventName("CHANNEL_ANSWER")}))
+	assert.Error(t, bridge.AddSink("file", NewJSONLinesSink(&lines), DefaultSinkOptions))
+
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_CREATE", "Unique-ID": "abc"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "abc"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "abc", "Hangup-Cause": "NORMAL%20CLEARING"}))
+	assert.Eventually(t, func() bool {
+		stats := bridge.Stats()
+		return stats["file"].Delivered == 3 && stats["answered"].Delivered == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	assert.Nil(t, bridge.Close())
+	assert.Equal(t, "{\"Event-Name\":\"CHANNEL_CREATE\",\"Unique-Id\":\"abc\"}\n"+
+		"{\"Event-Name\":\"CHANNEL_ANSWER\",\"Unique-Id\":\"abc\"}\n"+
+		"{\"Event-Name\":\"CHANNEL_HANGUP\",\"Hangup-Cause\":\"NORMAL CLEARING\",\"Unique-Id\":\"abc\"}\n", lines.String())
+	assert.Equal(t, "CHANNEL_ANSWER", (<-answered).GetName())
+	assert.Empty(t, bridge.Stats())
+	assert.Error(t, bridge.AddSink("late", NewChannelSink(answered), DefaultSinkOptions))
+}
+
+func TestEventBridge_WebhookRetries(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	var requests int32
+	var lock sync.Mutex
+	var received [][]map[string]string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		// Fail the first request so the batch is retried
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []map[string]string
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&batch))
+		lock.Lock()
+		received = append(received, batch)
+		lock.Unlock()
+	}))
+	defer webhook.Close()
+
+	sink := NewWebhookSink(webhook.URL)
+	sink.Headers = http.Header{"Authorization": {"Bearer token"}}
+	bridge := NewEventBridge(connection)
+	defer bridge.Close()
+	assert.Nil(t, bridge.AddSink("webhook", sink, SinkOptions{BatchSize: 2, BatchInterval: time.Second, MaxRetries: 2, RetryBackoff: time.Millisecond}))
+
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": "1"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": "2"}))
+	assert.Eventually(t, func() bool {
+		return bridge.Stats()["webhook"].Delivered == 2
+	}, 5*time.Second, 5*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, [][]map[string]string{{
+		{"Event-Name": "HEARTBEAT", "Event-Sequence": "1"},
+		{"Event-Name": "HEARTBEAT", "Event-Sequence": "2"},
+	}}, received)
+	assert.Equal(t, SinkStats{Delivered: 2, Retries: 1}, bridge.Stats()["webhook"])
+}
+
+func TestEventBridge_Drops(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	var dropped []string
+	var lock sync.Mutex
+	onDrop := func(events []*Event, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, event := range events {
+			dropped = append(dropped, event.GetHeader("Event-Sequence")+" "+err.Error())
+		}
+	}
+
+	// A sink that always fails drops every batch once its retries are used up
+	failing := NewWebhookSink("http://127.0.0.1:0/unreachable")
+	// A reader that never reads blocks the sink so its buffer fills up
+	blocked := make(chan *Event)
+	bridge := NewEventBridge(connection)
+	assert.Nil(t, bridge.AddSink("failing", failing, SinkOptions{MaxRetries: 1, RetryBackoff: time.Millisecond, OnDrop: onDrop}))
+	assert.Nil(t, bridge.AddSink("blocked", NewChannelSink(blocked), SinkOptions{Buffer: 1}))
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": strconv.Itoa(i)}))
+	}
+	assert.Eventually(t, func() bool {
+		stats := bridge.Stats()
+		return stats["failing"].Dropped == 5 && stats["blocked"].Dropped >= 3
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(5), bridge.Stats()["failing"].Retries)
+
+	// Unblock the channel sink so Close can deliver what is still queued
+	go func() {
+		for range blocked {
+		}
+	}()
+	assert.Nil(t, bridge.Close())
+	close(blocked)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, dropped, 5)
+	assert.Contains(t, dropped[0], "0 ")
+}
+
+func TestEventBridge_CloseStuckSink(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	var dropped int32
+	onDrop := func(events []*Event, err error) {
+		assert.Equal(t, context.Canceled, err)
+		atomic.AddInt32(&dropped, int32(len(events)))
+	}
+	// Nobody reads the channel, the sink blocks on its first event and the rest stay queued
+	stopped := make(chan *Event)
+	bridge := NewEventBridge(connection)
+	assert.Nil(t, bridge.AddSink("stopped", NewChannelSink(stopped), SinkOptions{DrainTimeout: 50 * time.Millisecond, RetryBackoff: time.Hour, OnDrop: onDrop}))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": strconv.Itoa(i)}))
+	}
+	assert.Eventually(t, func() bool {
+		return bridge.Stats()["stopped"].Queued == 2
+	}, 5*time.Second, 5*time.Millisecond)
+
+	closed := make(chan error)
+	go func() {
+		closed <- bridge.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dropped))
+}
+
+func TestJSONLinesSink_Encoding(t *testing.T) {
+	// Events are written the way Event.EncodeJSON encodes them, repeated headers as arrays and the body in _body
+	var lines bytes.Buffer
+	sink := NewJSONLinesSink(&lines)
+	event := &Event{
+		Headers: map[string][]string{"Event-Name": {"CUSTOM"}, "Variable_list": {"a%20b", "<c>"}},
+		Body:    []byte("hello"),
+	}
+	assert.Nil(t, sink.WriteEvents(context.Background(), []*Event{event}))
+	assert.Nil(t, sink.Close())
+	encoded, err := event.EncodeJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded)+"\n", lines.String())
+	assert.Equal(t, `{"Content-Length":"5","Event-Name":"CUSTOM","Variable_list":["a b","<c>"],"_body":"hello"}`+"\n", lines.String())
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package sofia
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+// GatewayState - The registration state of a gateway as reported by mod_sofia
+type GatewayState string
+
+const (
+	GatewayUnregistered GatewayState = "UNREGED"
+	GatewayTrying       GatewayState = "TRYING"
+	GatewayRegister     GatewayState = "REGISTER"
+	GatewayRegistered   GatewayState = "REGED"
+	GatewayUnregister   GatewayState = "UNREGISTER"
+	GatewayFailed       GatewayState = "FAILED"
+	GatewayFailWait     GatewayState = "FAIL_WAIT"
+	GatewayExpired      GatewayState = "EXPIRED"
+	GatewayNoReg        GatewayState = "NOREG"
+	GatewayDown         GatewayState = "DOWN"
+	GatewayTimeout      GatewayState = "TIMEOUT"
+)
+
+// Gateway - The last known state of a sofia gateway
+type Gateway struct {
+	Name       string
+	Profile    string
+	State      GatewayState
+	PingStatus string    // UP, DOWN or INVALID when the gateway is pinged with OPTIONS
+	Status     int       // The SIP status of the last failed registration, if any
+	Phrase     string    // The SIP reason phrase of the last failed registration, if any
+	Updated    time.Time // When the state was last reported
+}
+
+// GatewayChange - Passed to Options.OnGateway when the state of a gateway changes, Previous is zero for new gateways
+type GatewayChange struct {
+	Previous Gateway
+	Current  Gateway
+}
+
+// IsUp - Reports if calls can be sent through the gateway, it is registered or does not need to be and is not failing pings
+func (g Gateway) IsUp() bool {
+	if g.PingStatus == "DOWN" {
+		return false
+	}
+	return g.State == GatewayRegistered || g.State == GatewayNoReg
+}
+
+// Builds a gateway from a sofia::gateway_state event
+func gatewayFromEvent(event *eslgo.Event) Gateway {
+	gateway := Gateway{
+		Name:       event.GetHeader("Gateway"),
+		Profile:    event.GetHeader("profile-name"),
+		State:      GatewayState(event.GetHeader("State")),
+		PingStatus: event.GetHeader("Ping-Status"),
+		Phrase:     event.GetHeader("Phrase"),
+		Updated:    eventTime(event),
+	}
+	gateway.Status, _ = strconv.Atoi(event.GetHeader("Status"))
+	return gateway
+}
+
+// xmlGateways - The output of sofia xmlstatus gateway
+type xmlGateways struct {
+	Gateways []struct {
+		Name    string `xml:"name"`
+		Profile string `xml:"profile"`
+		State   string `xml:"state"`
+		Status  string `xml:"status"`
+	} `xml:"gateway"`
+}
+
+func parseGateways(body []byte, now time.Time) ([]Gateway, error) {
+	var parsed xmlGateways
+	if err := decodeXML(body, &parsed); err != nil {
+		return nil, err
+	}
+	gateways := make([]Gateway, 0, len(parsed.Gateways))
+	for _, entry := range parsed.Gateways {
+		gateways = append(gateways, Gateway{
+			Name:       entry.Name,
+			Profile:    entry.Profile,
+			State:      GatewayState(strings.TrimSpace(entry.State)),
+			PingStatus: strings.TrimSpace(entry.Status),
+			Updated:    now,
+		})
+	}
+	return gateways, nil
+}
+
+// Parses the profile names from the sofia status table, aliases and gateways are skipped
+func parseProfiles(body string) []string {
+	var profiles []string
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "profile" {
+			profiles = append(profiles, fields[0])
+		}
+	}
+	return profiles
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+
+// Package sofia tracks SIP registrations and gateway states of mod_sofia from its CUSTOM events, bootstrapped from the
+// sofia status commands, and reports changes through callbacks.
+package sofia
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shuguocloud/eslgo"
+)
+
+// Registration - A SIP registration of a user agent on a sofia profile
+type Registration struct {
+	Profile     string
+	User        string // The registered user without the realm, e.g. 1000
+	Realm       string // The domain the user registered to
+	Contact     string
+	CallID      string // The Call-ID of the REGISTER, FreeSWITCH keys registrations by it
+	NetworkIP   string
+	NetworkPort string
+	UserAgent   string
+	Status      string    // e.g. Registered(UDP)
+	Expires     time.Time // When the registration expires unless it is refreshed
+}
+
+// ChangeType - What happened to a registration, see RegistrationChange
+type ChangeType int
+
+const (
+	Registered   ChangeType = iota // A new registration or a refresh of an existing one
+	Unregistered                   // The user agent removed the registration
+	Expired                        // FreeSWITCH expired the registration because it was not refreshed
+)
+
+// RegistrationChange - Passed to Options.OnRegistration when a registration is added, refreshed or removed
+type RegistrationChange struct {
+	Type         ChangeType
+	Registration Registration
+}
+
+// AOR - Returns the address of record user@realm of the registration
+func (r Registration) AOR() string {
+	return r.User + "@" + r.Realm
+}
+
+func (t ChangeType) String() string {
+	switch t {
+	case Registered:
+		return "registered"
+	case Unregistered:
+		return "unregistered"
+	case Expired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// Builds a registration from a sofia::register, sofia::unregister or sofia::expire event. The events do not agree on
+// header names, register and unregister use from-user and from-host while expire uses user and host
+func registrationFromEvent(event *eslgo.Event) Registration {
+	registration := Registration{
+		Profile:     event.GetHeader("profile-name"),
+		User:        firstHeader(event, "from-user", "user", "username"),
+		Realm:       firstHeader(event, "from-host", "host", "realm"),
+		Contact:     event.GetHeader("contact"),
+		CallID:      event.GetHeader("call-id"),
+		NetworkIP:   event.GetHeader("network-ip"),
+		NetworkPort: event.GetHeader("network-port"),
+		UserAgent:   event.GetHeader("user-agent"),
+		Status:      event.GetHeader("status"),
+	}
+	if seconds, err := strconv.Atoi(event.GetHeader("expires")); err == nil && seconds > 0 {
+		registration.Expires = eventTime(event).Add(time.Duration(seconds) * time.Second)
+	}
+	return registration
+}
+
+// The time the event was fired from Event-Date-Timestamp in microseconds since the epoch, or now if it is missing
+func eventTime(event *eslgo.Event) time.Time {
+	usec, err := strconv.ParseInt(event.GetHeader("Event-Date-Timestamp"), 10, 64)
+	if err != nil || usec <= 0 {
+		return time.Now()
+	}
+	return time.Unix(0, usec*int64(time.Microsecond))
+}
+
+func firstHeader(event *eslgo.Event, names ...string) string {
+	for _, name := range names {
+		if value := event.GetHeader(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// xmlRegistrations - The output of sofia xmlstatus profile <name> reg
+type xmlRegistrations struct {
+	Registrations []struct {
+		CallID      string `xml:"call-id"`
+		User        string `xml:"user"`
+		Contact     string `xml:"contact"`
+		Agent       string `xml:"agent"`
+		Status      string `xml:"status"`
+		NetworkIP   string `xml:"network-ip"`
+		NetworkPort string `xml:"network-port"`
+		AuthUser    string `xml:"sip-auth-user"`
+		AuthRealm   string `xml:"sip-auth-realm"`
+	} `xml:"registrations>registration"`
+}
+
+var expiresSeconds = regexp.MustCompile(`expsecs\((\d+)\)`)
+
+// Parses the registrations of a profile, the status contains the seconds left until they expire
+func parseRegistrations(profile string, body []byte, now time.Time) ([]Registration, error) {
+	var parsed xmlRegistrations
+	if err := decodeXML(body, &parsed); err != nil {
+		return nil, err
+	}
+	registrations := make([]Registration, 0, len(parsed.Registrations))
+	for _, entry := range parsed.Registrations {
+		user, realm := entry.AuthUser, entry.AuthRealm
+		if parts := strings.SplitN(entry.User, "@", 2); len(parts) == 2 {
+			user, realm = parts[0], parts[1]
+		}
+		registration := Registration{
+			Profile:     profile,
+			User:        user,
+			Realm:       realm,
+			Contact:     entry.Contact,
+			CallID:      entry.CallID,
+			NetworkIP:   entry.NetworkIP,
+			NetworkPort: entry.NetworkPort,
+			UserAgent:   entry.Agent,
+			Status:      entry.Status,
+		}
+		if i := strings.Index(registration.Status, " exp("); i >= 0 {
+			registration.Status = registration.Status[:i]
+		}
+		if match := expiresSeconds.FindStringSubmatch(entry.Status); match != nil {
+			seconds, _ := strconv.Atoi(match[1])
+			registration.Expires = now.Add(time.Duration(seconds) * time.Second)
+		}
+		registrations = append(registrations, registration)
+	}
+	return registrations, nil
+}
+
+// Decodes the XML output of a sofia command, FreeSWITCH declares it as ISO-8859-1
+func decodeXML(body []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "iso-8859-1", "latin1":
+			return latin1Reader{bufio.NewReader(input)}, nil
+		}
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+	return decoder.Decode(v)
+}
+
+// latin1Reader - Converts ISO-8859-1 to UTF-8, every byte is the code point of the same value
+type latin1Reader struct {
+	reader io.ByteScanner
+}
+
+func (r latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b >= utf8.RuneSelf && n+2 > len(p) {
+			// Encoded in two bytes, it is kept for the next read
+			_ = r.reader.UnreadByte()
+			break
+		}
+		n += utf8.EncodeRune(p[n:], rune(b))
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Returns the key a registration is stored by, the Call-ID when known
+func registrationKey(r Registration) string {
+	if r.CallID != "" {
+		return r.CallID
+	}
+	return r.AOR() + ";" + r.Contact
+}
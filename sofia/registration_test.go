@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package sofia
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatin1Reader(t *testing.T) {
+	data, err := ioutil.ReadAll(latin1Reader{bufio.NewReader(strings.NewReader("caf\xe9 \xfc"))})
+	assert.NoError(t, err)
+	assert.Equal(t, "café ü", string(data))
+
+	// Small buffers still make progress, a character that does not fit is kept for the next read
+	reader := latin1Reader{bufio.NewReader(strings.NewReader("a\xe9"))}
+	buffer := make([]byte, 1)
+	n, err := reader.Read(buffer)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, err)
+	_, err = reader.Read(buffer)
+	assert.Equal(t, io.ErrShortBuffer, err)
+	buffer = make([]byte, 2)
+	n, err = reader.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "é", string(buffer[:n]))
+}
@@ -0,0 +1,330 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package sofia
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/command"
+)
+
+// The CUSTOM event subclasses the tracker consumes
+const (
+	SubclassRegister     = "sofia::register"
+	SubclassUnregister   = "sofia::unregister"
+	SubclassExpire       = "sofia::expire"
+	SubclassGatewayState = "sofia::gateway_state"
+)
+
+// Options - Used to configure a Tracker
+type Options struct {
+	OnRegistration func(change RegistrationChange) // Optional, called when a registration is added, refreshed or removed
+	OnGateway      func(change GatewayChange)      // Optional, called when the state of a gateway changes
+}
+
+// Tracker - Keeps the SIP registrations and gateway states of mod_sofia. Callbacks are called one at a time in the order
+// the changes were applied and may query the tracker, but must not call HandleEvent or Bootstrap.
+type Tracker struct {
+	opts          Options
+	lock          sync.RWMutex
+	notifyLock    sync.Mutex
+	registrations map[string]Registration // By registrationKey
+	gateways      map[string]Gateway      // By name
+	attached      []attachment
+}
+
+// attachment - A connection the tracker receives events from
+type attachment struct {
+	stop func()
+	done chan struct{}
+}
+
+// NewTracker - Creates an empty tracker, see Attach and Bootstrap
+func NewTracker(opts Options) *Tracker {
+	return &Tracker{
+		opts:          opts,
+		registrations: make(map[string]Registration),
+		gateways:      make(map[string]Gateway),
+	}
+}
+
+// Attach - Subscribes to the sofia events on the connection and then bootstraps from it, the subscription is kept if bootstrapping fails
+func (t *Tracker) Attach(ctx context.Context, conn *eslgo.Conn) error {
+	sub := eslgo.EventSubscription{Subclasses: []string{SubclassRegister, SubclassUnregister, SubclassExpire, SubclassGatewayState}}
+	events, stop, err := conn.SubscribeEvents(ctx, sub, eslgo.SubscribeOptions{Buffer: 1024})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			t.HandleEvent(event)
+		}
+	}()
+	t.lock.Lock()
+	t.attached = append(t.attached, attachment{stop: stop, done: done})
+	t.lock.Unlock()
+	return t.Bootstrap(ctx, conn)
+}
+
+// Bootstrap - Loads the current registrations of every profile and the gateway states with the sofia status commands,
+// registrations and gateways no longer listed are removed
+func (t *Tracker) Bootstrap(ctx context.Context, conn *eslgo.Conn) error {
+	status, err := sofiaCommand(ctx, conn, "status")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	profiles := parseProfiles(status)
+	var registrations []Registration
+	for _, profile := range profiles {
+		body, err := sofiaCommand(ctx, conn, "xmlstatus profile "+profile+" reg")
+		if err != nil {
+			return err
+		}
+		parsed, err := parseRegistrations(profile, []byte(body), now)
+		if err != nil {
+			return fmt.Errorf("sofia: registrations of profile %s: %w", profile, err)
+		}
+		registrations = append(registrations, parsed...)
+	}
+	body, err := sofiaCommand(ctx, conn, "xmlstatus gateway")
+	if err != nil {
+		return err
+	}
+	gateways, err := parseGateways([]byte(body), now)
+	if err != nil {
+		return fmt.Errorf("sofia: gateways: %w", err)
+	}
+
+	t.notifyLock.Lock()
+	defer t.notifyLock.Unlock()
+	t.lock.Lock()
+	var registrationChanges []RegistrationChange
+	loaded := make(map[string]bool, len(registrations))
+	for _, registration := range registrations {
+		key := registrationKey(registration)
+		loaded[key] = true
+		if _, ok := t.registrations[key]; !ok {
+			registrationChanges = append(registrationChanges, RegistrationChange{Type: Registered, Registration: registration})
+		}
+		t.registrations[key] = registration
+	}
+	for key, registration := range t.registrations {
+		if !loaded[key] && containsString(profiles, registration.Profile) {
+			delete(t.registrations, key)
+			registrationChanges = append(registrationChanges, RegistrationChange{Type: Unregistered, Registration: registration})
+		}
+	}
+
+	var gatewayChanges []GatewayChange
+	listed := make(map[string]bool, len(gateways))
+	for _, gateway := range gateways {
+		listed[gateway.Name] = true
+		if change, ok := t.updateGateway(gateway); ok {
+			gatewayChanges = append(gatewayChanges, change)
+		}
+	}
+	for name, gateway := range t.gateways {
+		if !listed[name] {
+			delete(t.gateways, name)
+			gatewayChanges = append(gatewayChanges, GatewayChange{Previous: gateway})
+		}
+	}
+	t.lock.Unlock()
+
+	for _, change := range registrationChanges {
+		t.notifyRegistration(change)
+	}
+	for _, change := range gatewayChanges {
+		t.notifyGateway(change)
+	}
+	return nil
+}
+
+// HandleEvent - Applies a sofia::register, sofia::unregister, sofia::expire or sofia::gateway_state event, other events are ignored
+func (t *Tracker) HandleEvent(event *eslgo.Event) {
+	if event.GetName() != "CUSTOM" {
+		return
+	}
+	t.notifyLock.Lock()
+	defer t.notifyLock.Unlock()
+
+	switch event.GetHeader("Event-Subclass") {
+	case SubclassRegister:
+		registration := registrationFromEvent(event)
+		t.lock.Lock()
+		t.registrations[registrationKey(registration)] = registration
+		t.lock.Unlock()
+		t.notifyRegistration(RegistrationChange{Type: Registered, Registration: registration})
+	case SubclassUnregister:
+		t.removeRegistration(registrationFromEvent(event), Unregistered)
+	case SubclassExpire:
+		t.removeRegistration(registrationFromEvent(event), Expired)
+	case SubclassGatewayState:
+		t.lock.Lock()
+		change, changed := t.updateGateway(gatewayFromEvent(event))
+		t.lock.Unlock()
+		if changed {
+			t.notifyGateway(change)
+		}
+	}
+}
+
+// Registrations - Returns every known registration sorted by address of record and contact
+func (t *Tracker) Registrations() []Registration {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	registrations := make([]Registration, 0, len(t.registrations))
+	for _, registration := range t.registrations {
+		registrations = append(registrations, registration)
+	}
+	sortRegistrations(registrations)
+	return registrations
+}
+
+// UserRegistrations - Returns the registrations of user@realm, a user may be registered from several devices
+func (t *Tracker) UserRegistrations(user, realm string) []Registration {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	var registrations []Registration
+	for _, registration := range t.registrations {
+		if registration.User == user && strings.EqualFold(registration.Realm, realm) {
+			registrations = append(registrations, registration)
+		}
+	}
+	sortRegistrations(registrations)
+	return registrations
+}
+
+// IsRegistered - Reports if user@realm has at least one registration
+func (t *Tracker) IsRegistered(user, realm string) bool {
+	return len(t.UserRegistrations(user, realm)) > 0
+}
+
+// Gateways - Returns every known gateway sorted by name
+func (t *Tracker) Gateways() []Gateway {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	gateways := make([]Gateway, 0, len(t.gateways))
+	for _, gateway := range t.gateways {
+		gateways = append(gateways, gateway)
+	}
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].Name < gateways[j].Name })
+	return gateways
+}
+
+// Gateway - Returns the gateway with the name
+func (t *Tracker) Gateway(name string) (Gateway, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	gateway, ok := t.gateways[name]
+	return gateway, ok
+}
+
+// Close - Removes the subscriptions of every attached connection and waits for the events received to be applied
+func (t *Tracker) Close() {
+	t.lock.Lock()
+	attached := t.attached
+	t.attached = nil
+	t.lock.Unlock()
+	for _, attachment := range attached {
+		attachment.stop()
+		<-attachment.done
+	}
+}
+
+// Removes the registration, unregister events may only carry the Call-ID or the user and contact. Caller must hold notifyLock
+func (t *Tracker) removeRegistration(registration Registration, change ChangeType) {
+	t.lock.Lock()
+	key := registrationKey(registration)
+	existing, ok := t.registrations[key]
+	if !ok && registration.CallID != "" {
+		// Registrations loaded without a Call-ID are keyed by address of record and contact
+		key = registration.AOR() + ";" + registration.Contact
+		existing, ok = t.registrations[key]
+	}
+	if ok {
+		delete(t.registrations, key)
+		registration = existing
+	}
+	t.lock.Unlock()
+	if ok {
+		t.notifyRegistration(RegistrationChange{Type: change, Registration: registration})
+	}
+}
+
+// Stores the gateway and reports if its state changed, events without a profile keep the known one. Caller must hold lock
+func (t *Tracker) updateGateway(gateway Gateway) (GatewayChange, bool) {
+	previous, ok := t.gateways[gateway.Name]
+	if gateway.Profile == "" {
+		gateway.Profile = previous.Profile
+	}
+	if gateway.PingStatus == "" {
+		gateway.PingStatus = previous.PingStatus
+	}
+	t.gateways[gateway.Name] = gateway
+	if ok && previous.State == gateway.State && previous.PingStatus == gateway.PingStatus {
+		return GatewayChange{}, false
+	}
+	return GatewayChange{Previous: previous, Current: gateway}, true
+}
+
+func (t *Tracker) notifyRegistration(change RegistrationChange) {
+	if t.opts.OnRegistration != nil {
+		t.opts.OnRegistration(change)
+	}
+}
+
+func (t *Tracker) notifyGateway(change GatewayChange) {
+	if t.opts.OnGateway != nil {
+		t.opts.OnGateway(change)
+	}
+}
+
+// Runs a sofia api command and returns its output, FreeSWITCH reports errors in the body
+func sofiaCommand(ctx context.Context, conn *eslgo.Conn, args string) (string, error) {
+	response, err := conn.SendCommand(ctx, command.API{Command: "sofia", Arguments: args})
+	if err != nil {
+		return "", err
+	}
+	body := string(response.Body)
+	if strings.HasPrefix(body, "-ERR") {
+		return "", fmt.Errorf("sofia %s: %s", args, strings.TrimSpace(body))
+	}
+	return body, nil
+}
+
+func sortRegistrations(registrations []Registration) {
+	sort.Slice(registrations, func(i, j int) bool {
+		if registrations[i].AOR() != registrations[j].AOR() {
+			return registrations[i].AOR() < registrations[j].AOR()
+		}
+		return registrations[i].Contact < registrations[j].Contact
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package sofia
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo"
+	"github.com/shuguocloud/eslgo/esltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStatus = `                     Name	   Type	                                       Data	State
+=================================================================================================
+            external	profile	            sip:mod_sofia@10.0.0.1:5080	RUNNING (0)
+   external::carrier	gateway	                  sip:joeuser@carrier.example	REGED
+            internal	profile	            sip:mod_sofia@10.0.0.1:5060	RUNNING (0)
+            10.0.0.1	  alias	                                   internal	ALIASED
+=================================================================================================
+2 profiles 1 alias
+`
+
+const testInternalRegistrations = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<profile>
+  <registrations>
+    <registration>
+        <call-id>call-1000</call-id>
+        <user>1000@pbx.example</user>
+        <contact>&quot;Alice&quot; &lt;sip:1000@192.168.1.10:5060&gt;</contact>
+        <agent>Phone A</agent>
+        <status>Registered(UDP)(unknown) exp(2020-06-01 12:00:00) expsecs(3600)</status>
+        <ping-status>Reachable</ping-status>
+        <host>pbx</host>
+        <network-ip>192.168.1.10</network-ip>
+        <network-port>5060</network-port>
+        <sip-auth-user>1000</sip-auth-user>
+        <sip-auth-realm>pbx.example</sip-auth-realm>
+    </registration>
+  </registrations>
+</profile>
+`
+
+const testGateways = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<gateways>
+  <gateway>
+    <name>carrier</name>
+    <profile>external</profile>
+    <scheme>Digest</scheme>
+    <realm>carrier.example</realm>
+    <state>REGED</state>
+    <status>UP</status>
+  </gateway>
+  <gateway>
+    <name>backup</name>
+    <profile>external</profile>
+    <state>FAIL_WAIT</state>
+    <status>DOWN</status>
+  </gateway>
+</gateways>
+`
+
+func TestTracker(t *testing.T) {
+	server, err := esltest.NewServer("secret")
+	require.NoError(t, err)
+	defer server.Close()
+	server.HandleAPI("sofia", func(args string) string {
+		switch args {
+		case "status":
+			return testStatus
+		case "xmlstatus profile internal reg":
+			return testInternalRegistrations
+		case "xmlstatus profile external reg":
+			return "<profile><registrations></registrations></profile>"
+		case "xmlstatus gateway":
+			return testGateways
+		}
+		return "-ERR Invalid Profile!"
+	})
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	opts.Password = "secret"
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	defer conn.ExitAndClose()
+
+	var lock sync.Mutex
+	var registrationChanges []RegistrationChange
+	var gatewayChanges []GatewayChange
+	tracker := NewTracker(Options{
+		OnRegistration: func(change RegistrationChange) {
+			lock.Lock()
+			defer lock.Unlock()
+			registrationChanges = append(registrationChanges, change)
+		},
+		OnGateway: func(change GatewayChange) {
+			lock.Lock()
+			defer lock.Unlock()
+			gatewayChanges = append(gatewayChanges, change)
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tracker.Attach(ctx, conn))
+	defer tracker.Close()
+
+	// Bootstrapped from the status commands
+	registrations := tracker.Registrations()
+	require.Len(t, registrations, 1)
+	assert.Equal(t, "internal", registrations[0].Profile)
+	assert.Equal(t, "1000@pbx.example", registrations[0].AOR())
+	assert.Equal(t, `"Alice" <sip:1000@192.168.1.10:5060>`, registrations[0].Contact)
+	assert.Equal(t, "192.168.1.10", registrations[0].NetworkIP)
+	assert.Equal(t, "Registered(UDP)(unknown)", registrations[0].Status)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), registrations[0].Expires, time.Minute)
+	assert.True(t, tracker.IsRegistered("1000", "PBX.example"))
+	carrier, ok := tracker.Gateway("carrier")
+	assert.True(t, ok)
+	assert.True(t, carrier.IsUp())
+	backup, _ := tracker.Gateway("backup")
+	assert.False(t, backup.IsUp())
+	assert.Len(t, gatewayChanges, 2)
+
+	// A second device registers the same user
+	server.SendEvent(esltest.Event{Headers: map[string]string{
+		"Event-Name":           "CUSTOM",
+		"Event-Subclass":       SubclassRegister,
+		"Event-Date-Timestamp": "1591012800000000",
+		"profile-name":         "internal",
+		"from-user":            "1000",
+		"from-host":            "pbx.example",
+		"contact":              "<sip:1000@192.168.1.11:5060>",
+		"call-id":              "call-1000-b",
+		"expires":              "600",
+		"network-ip":           "192.168.1.11",
+		"network-port":         "5060",
+		"user-agent":           "Phone B",
+		"status":               "Registered(UDP)",
+	}})
+	// The gateway comes back up
+	server.SendEvent(esltest.Event{Headers: map[string]string{
+		"Event-Name":     "CUSTOM",
+		"Event-Subclass": SubclassGatewayState,
+		"Gateway":        "backup",
+		"State":          "REGED",
+		"Ping-Status":    "UP",
+	}})
+	// The first device unregisters and the second one expires
+	server.SendEvent(esltest.Event{Headers: map[string]string{
+		"Event-Name":     "CUSTOM",
+		"Event-Subclass": SubclassUnregister,
+		"profile-name":   "internal",
+		"from-user":      "1000",
+		"from-host":      "pbx.example",
+		"call-id":        "call-1000",
+	}})
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(registrationChanges) == 3 && len(gatewayChanges) == 3
+	}, 5*time.Second, time.Millisecond)
+
+	registrations = tracker.UserRegistrations("1000", "pbx.example")
+	require.Len(t, registrations, 1)
+	assert.Equal(t, "Phone B", registrations[0].UserAgent)
+	assert.Equal(t, time.Unix(1591013400, 0), registrations[0].Expires)
+	backup, _ = tracker.Gateway("backup")
+	assert.True(t, backup.IsUp())
+	assert.Equal(t, "external", backup.Profile)
+
+	server.SendEvent(esltest.Event{Headers: map[string]string{
+		"Event-Name":     "CUSTOM",
+		"Event-Subclass": SubclassExpire,
+		"profile-name":   "internal",
+		"user":           "1000",
+		"host":           "pbx.example",
+		"call-id":        "call-1000-b",
+	}})
+	assert.Eventually(t, func() bool { return !tracker.IsRegistered("1000", "pbx.example") }, 5*time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []ChangeType{Registered, Registered, Unregistered, Expired}, []ChangeType{
+		registrationChanges[0].Type, registrationChanges[1].Type, registrationChanges[2].Type, registrationChanges[3].Type,
+	})
+	assert.Equal(t, "Phone A", registrationChanges[2].Registration.UserAgent, "the removed registration is reported in full")
+	assert.Equal(t, GatewayFailWait, gatewayChanges[2].Previous.State)
+	assert.Equal(t, GatewayRegistered, gatewayChanges[2].Current.State)
+}
+
+func TestTracker_Bootstrap_Error(t *testing.T) {
+	server, err := esltest.NewServer("secret")
+	require.NoError(t, err)
+	defer server.Close()
+
+	opts := eslgo.DefaultInboundOptions
+	opts.Logger = eslgo.NilLogger{}
+	opts.Password = "secret"
+	conn, err := opts.Dial(server.Addr())
+	require.NoError(t, err)
+	defer conn.ExitAndClose()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = NewTracker(Options{}).Bootstrap(ctx, conn)
+	assert.EqualError(t, err, "sofia status: -ERR sofia Command not found!")
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SubscribeOptions - Used to configure channel based event subscriptions
+type SubscribeOptions struct {
+	Buffer int                // The size of the event channel buffer. Events received while the buffer is full are dropped instead of blocking the connection.
+	OnDrop func(event *Event) // An optional function called with every event dropped because the buffer was full. Called from the dispatch loop so it must not block
+}
+
+// DefaultSubscribeOptions - The default options used by Subscribe
+var DefaultSubscribeOptions = SubscribeOptions{
+	Buffer: 128,
+}
+
+// Subscribe - Delivers every event accepted by the filter into the returned buffered channel, in the order they were received.
+// The channel is closed when the context ends, the connection closes, or the returned cancel function is called.
+// A nil filter receives all events. Note: this only filters what FreeSWITCH already sends, see SubscribeEvents to also have
+// FreeSWITCH send the events.
+func (c *Conn) Subscribe(ctx context.Context, filter EventMatcher) (<-chan *Event, func()) {
+	return c.SubscribeWithOptions(ctx, filter, DefaultSubscribeOptions)
+}
+
+// SubscribeWithOptions - Same as Subscribe with control over the buffer size and how dropped events are reported
+func (c *Conn) SubscribeWithOptions(ctx context.Context, filter EventMatcher, opts SubscribeOptions) (<-chan *Event, func()) {
+	events, _, cancel := c.subscribe(ctx, filter, opts)
+	return events, cancel
+}
+
+// SubscribeEvents - Delivers the events described by sub into the returned buffered channel in the order they were received,
+// and adds sub to the server side subscription like AddSubscription does so FreeSWITCH sends them. ctx is only used to send
+// the subscription commands. The channel is closed when the returned cancel function is called, which also removes the
+// subscription, or when the connection closes.
+func (c *Conn) SubscribeEvents(ctx context.Context, sub EventSubscription, opts SubscribeOptions) (<-chan *Event, func(), error) {
+	c.subscriptionLock.Lock()
+	events, id, stop := c.subscribe(context.Background(), sub, opts)
+	err := c.addSubscription(ctx, id, sub)
+	c.subscriptionLock.Unlock()
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return events, func() {
+		stop()
+		ctx, cancel := context.WithTimeout(c.runningContext, 5*time.Second)
+		defer cancel()
+		// Fails when the subscription was already removed by an earlier call
+		_ = c.RemoveSubscription(ctx, id)
+	}, nil
+}
+
+// Registers the channel listener, returns its ID so it can be tracked as a managed subscription
+func (c *Conn) subscribe(ctx context.Context, filter EventMatcher, opts SubscribeOptions) (<-chan *Event, string, func()) {
+	if filter == nil {
+		filter = EventMatcherFunc(func(*Event) bool { return true })
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultSubscribeOptions.Buffer
+	}
+
+	events := make(chan *Event, opts.Buffer)
+	dropped := 0
+	// Called inline from the dispatch loop with the listener lock held, must never block
+	id := c.registerMatchListener(filter, func(event *Event) {
+		select {
+		case events <- event:
+		default:
+			dropped++
+			c.log(LevelWarn, "Subscription buffer full, dropped event", "event", event.GetName(), "dropped", dropped)
+			if opts.OnDrop != nil {
+				opts.OnDrop(event)
+			}
+		}
+	}, true)
+
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			// Once removed the dispatch loop can no longer send, so it is safe to close
+			c.RemoveMatchListener(id)
+			close(events)
+			close(done)
+		})
+	}
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			cancel()
+		case <-c.runningContext.Done():
+			cancel()
+		}
+	}()
+	return events, id, cancel
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConn_Subscribe(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events, stop := connection.Subscribe(ctx, MatchEventName("HEARTBEAT"))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": strconv.Itoa(i)}))
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_ANSWER"}))
+	}
+	// Events are delivered in order and only if accepted by the filter
+	for i := 0; i < 10; i++ {
+		select {
+		case event := <-events:
+			assert.Equal(t, strconv.Itoa(i), event.GetHeader("Event-Sequence"))
+		case <-ctx.Done():
+			t.Fatal("event not delivered")
+		}
+	}
+
+	stop()
+	_, ok := <-events
+	assert.False(t, ok)
+	// Safe to call more than once
+	stop()
+}
+
+func TestConn_Subscribe_Drops(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	dropped := make(chan *Event, 2)
+	events, _ := connection.SubscribeWithOptions(context.Background(), nil, SubscribeOptions{
+		Buffer: 1,
+		OnDrop: func(event *Event) {
+			dropped <- event
+		},
+	})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": strconv.Itoa(i)}))
+	}
+	for i := 1; i < 3; i++ {
+		select {
+		case event := <-dropped:
+			assert.Equal(t, strconv.Itoa(i), event.GetHeader("Event-Sequence"))
+		case <-time.After(5 * time.Second):
+			t.Fatal("drop not reported")
+		}
+	}
+	event := <-events
+	assert.Equal(t, "0", event.GetHeader("Event-Sequence"))
+
+	// Closing the connection closes the channel
+	connection.Close()
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestConn_SubscribeEvents(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events, stop, err := connection.SubscribeEvents(ctx, EventSubscription{Events: []string{"HEARTBEAT"}}, DefaultSubscribeOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"event plain HEARTBEAT"}, server.received())
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_ANSWER"}))
+		assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Event-Sequence": strconv.Itoa(i)}))
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-events:
+			assert.Equal(t, strconv.Itoa(i), event.GetHeader("Event-Sequence"))
+		case <-ctx.Done():
+			t.Fatal("event not delivered")
+		}
+	}
+
+	// Cancelling closes the channel and removes the server side subscription
+	stop()
+	_, ok := <-events
+	assert.False(t, ok)
+	assert.Equal(t, []string{"event plain HEARTBEAT", "nixevent plain HEARTBEAT"}, server.received())
+	stop()
+	assert.Len(t, server.received(), 2)
+}
@@ -0,0 +1,313 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/shuguocloud/eslgo/command"
+)
+
+// EventSubscription - Describes the events a listener needs FreeSWITCH to deliver. Used with AddSubscription so the
+// connection only asks FreeSWITCH for the events and channels the registered listeners actually want.
+type EventSubscription struct {
+	Events     []string // Event names to listen for, e.g. CHANNEL_ANSWER. Leave Events and Subclasses empty to listen for all events
+	Subclasses []string // CUSTOM event subclasses to listen for, e.g. sofia::register
+	UniqueIDs  []string // Only deliver events for these channel UUIDs. Empty means events for any channel
+	// Match UniqueIDs on the client only. The connection's "filter Unique-ID" commands are then never narrowed for this
+	// subscription, the UUIDs are only added to filters other subscriptions already need
+	ClientSideFilter bool
+}
+
+// Match - Reports if the event is one described by the subscription
+func (s EventSubscription) Match(event *Event) bool {
+	if len(s.UniqueIDs) > 0 && !containsString(s.UniqueIDs, event.GetHeader("Unique-ID")) {
+		return false
+	}
+	if len(s.Events) == 0 && len(s.Subclasses) == 0 {
+		return true
+	}
+	name := event.GetName()
+	if containsString(s.Events, name) {
+		return true
+	}
+	return name == "CUSTOM" && containsString(s.Subclasses, event.GetHeader("Event-Subclass"))
+}
+
+// Subscriptions limited to event names or subclasses are indexed by name
+func (s EventSubscription) eventNames() []string {
+	if len(s.Events) == 0 && len(s.Subclasses) == 0 {
+		return nil
+	}
+	names := append([]string{}, s.Events...)
+	if len(s.Subclasses) > 0 && !containsString(names, "CUSTOM") {
+		names = append(names, "CUSTOM")
+	}
+	return names
+}
+
+// subscriptionState - The server side event subscription and Unique-ID filters for a connection
+type subscriptionState struct {
+	all        bool
+	events     map[string]bool
+	subclasses map[string]bool
+	uniqueIDs  map[string]bool // nil when events are not filtered by channel
+}
+
+type managedSubscription struct {
+	subscription EventSubscription
+	listenerID   string
+}
+
+// AddSubscription - Registers a listener for the events described by sub and updates the server side "event" subscription
+// and "filter Unique-ID" commands to the minimal set needed by all registered subscriptions. Returns the subscription ID used to remove it.
+// EnableEvents and the myevents sent for OutboundOptions.MyEvents are tracked, every event then stays subscribed and the
+// Unique-ID filters are removed. Note: do not mix with manually sent event/filter commands on the same connection, they are not tracked.
+func (c *Conn) AddSubscription(ctx context.Context, sub EventSubscription, listener EventListener) (string, error) {
+	c.subscriptionLock.Lock()
+	defer c.subscriptionLock.Unlock()
+
+	id := c.RegisterMatchListener(sub, listener)
+	if err := c.addSubscription(ctx, id, sub); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Tracks the subscription of the registered listener and applies it, the listener is removed if that fails. Caller must hold subscriptionLock
+func (c *Conn) addSubscription(ctx context.Context, id string, sub EventSubscription) error {
+	c.subscriptions[id] = managedSubscription{
+		subscription: sub,
+		listenerID:   id,
+	}
+	if err := c.applySubscriptions(ctx); err != nil {
+		delete(c.subscriptions, id)
+		c.RemoveMatchListener(id)
+		return err
+	}
+	return nil
+}
+
+// RemoveSubscription - Removes the subscription with the ID returned from AddSubscription and shrinks the server side subscription and filters to match
+func (c *Conn) RemoveSubscription(ctx context.Context, id string) error {
+	c.subscriptionLock.Lock()
+	defer c.subscriptionLock.Unlock()
+
+	managed, ok := c.subscriptions[id]
+	if !ok {
+		return errors.New("no subscription with id " + id)
+	}
+	delete(c.subscriptions, id)
+	c.RemoveMatchListener(managed.listenerID)
+
+	return c.applySubscriptions(ctx)
+}
+
+// Computes the server side state needed to satisfy every registered subscription. Caller must hold subscriptionLock
+func (c *Conn) desiredSubscriptions() subscriptionState {
+	desired := subscriptionState{
+		events:     make(map[string]bool),
+		subclasses: make(map[string]bool),
+		uniqueIDs:  make(map[string]bool),
+	}
+	serverSide := false
+	clientSide := make(map[string]bool)
+	for _, managed := range c.subscriptions {
+		sub := managed.subscription
+		if len(sub.Events) == 0 && len(sub.Subclasses) == 0 {
+			desired.all = true
+		}
+		for _, name := range sub.Events {
+			desired.events[name] = true
+		}
+		for _, subclass := range sub.Subclasses {
+			desired.subclasses[subclass] = true
+		}
+		switch {
+		case len(sub.UniqueIDs) == 0:
+			// One unfiltered subscription means we cannot filter by channel at all
+			desired.uniqueIDs = nil
+		case sub.ClientSideFilter:
+			for _, uuid := range sub.UniqueIDs {
+				clientSide[uuid] = true
+			}
+		default:
+			serverSide = true
+			if desired.uniqueIDs != nil {
+				for _, uuid := range sub.UniqueIDs {
+					desired.uniqueIDs[uuid] = true
+				}
+			}
+		}
+	}
+	if c.allEvents {
+		// Every event was subscribed to with EnableEvents or myevents, it must not be narrowed
+		desired.all = true
+		desired.uniqueIDs = nil
+	}
+	if !serverSide {
+		desired.uniqueIDs = nil
+	}
+	if desired.uniqueIDs != nil {
+		// Filters are already needed, client side subscriptions must not lose the events of their channels
+		for uuid := range clientSide {
+			desired.uniqueIDs[uuid] = true
+		}
+	}
+	return desired
+}
+
+// Records that every event was subscribed to outside AddSubscription and removes the Unique-ID filters that would narrow it
+func (c *Conn) trackAllEvents(ctx context.Context) error {
+	c.subscriptionLock.Lock()
+	defer c.subscriptionLock.Unlock()
+	c.allEvents = true
+	c.appliedSubscription.all = true
+	return c.applySubscriptions(ctx)
+}
+
+// Sends the commands needed to move from the applied server side state to the desired one. The applied state is updated
+// after every command that succeeded, so it still matches the server when a later command fails. Caller must hold subscriptionLock
+func (c *Conn) applySubscriptions(ctx context.Context) error {
+	desired := c.desiredSubscriptions()
+	applied := &c.appliedSubscription
+
+	// Event subscriptions
+	if desired.all && !applied.all {
+		if err := c.sendSubscriptionCommand(ctx, command.Event{Format: "plain", Listen: []string{"all"}}); err != nil {
+			return err
+		}
+		applied.all = true
+	}
+	if !desired.all {
+		if applied.all {
+			if err := c.sendSubscriptionCommand(ctx, command.DisableEvents{}); err != nil {
+				return err
+			}
+			applied.all = false
+			applied.events = nil
+			applied.subclasses = nil
+		}
+
+		if applied.events == nil {
+			applied.events = make(map[string]bool)
+			applied.subclasses = make(map[string]bool)
+		}
+
+		addedEvents, addedSubclasses := difference(desired.events, applied.events), difference(desired.subclasses, applied.subclasses)
+		if added := eventList(addedEvents, addedSubclasses); len(added) > 0 {
+			if err := c.sendSubscriptionCommand(ctx, command.Event{Format: "plain", Listen: added}); err != nil {
+				return err
+			}
+			setKeys(applied.events, addedEvents, true)
+			setKeys(applied.subclasses, addedSubclasses, true)
+		}
+		removedEvents, removedSubclasses := difference(applied.events, desired.events), difference(applied.subclasses, desired.subclasses)
+		if removed := eventList(removedEvents, removedSubclasses); len(removed) > 0 {
+			if err := c.sendSubscriptionCommand(ctx, command.Event{Ignore: true, Format: "plain", Listen: removed}); err != nil {
+				return err
+			}
+			setKeys(applied.events, removedEvents, false)
+			setKeys(applied.subclasses, removedSubclasses, false)
+		}
+	}
+
+	// Unique-ID filters
+	if desired.uniqueIDs == nil {
+		if applied.uniqueIDs != nil {
+			if err := c.sendSubscriptionCommand(ctx, command.Filter{Delete: true, EventHeader: "Unique-ID"}); err != nil {
+				return err
+			}
+			applied.uniqueIDs = nil
+		}
+		return nil
+	}
+	for _, uuid := range difference(desired.uniqueIDs, applied.uniqueIDs) {
+		if err := c.sendSubscriptionCommand(ctx, command.Filter{EventHeader: "Unique-ID", FilterValue: uuid}); err != nil {
+			return err
+		}
+		if applied.uniqueIDs == nil {
+			applied.uniqueIDs = make(map[string]bool)
+		}
+		applied.uniqueIDs[uuid] = true
+	}
+	for _, uuid := range difference(applied.uniqueIDs, desired.uniqueIDs) {
+		if err := c.sendSubscriptionCommand(ctx, command.Filter{Delete: true, EventHeader: "Unique-ID", FilterValue: uuid}); err != nil {
+			return err
+		}
+		delete(applied.uniqueIDs, uuid)
+	}
+	return nil
+}
+
+func (c *Conn) sendSubscriptionCommand(ctx context.Context, cmd command.Command) error {
+	response, err := c.SendCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if !response.IsOk() {
+		return errors.New("subscription command failed: " + response.GetReply())
+	}
+	return nil
+}
+
+// Builds the argument list for the event/nixevent commands. FreeSWITCH reads every word after the CUSTOM keyword as a
+// subclass, so CUSTOM is listed once after the other events and followed by the subclasses
+func eventList(events, subclasses []string) []string {
+	var list []string
+	custom := len(subclasses) > 0
+	for _, name := range events {
+		if name == "CUSTOM" {
+			custom = true
+			continue
+		}
+		list = append(list, name)
+	}
+	if custom {
+		list = append(list, "CUSTOM")
+		list = append(list, subclasses...)
+	}
+	return list
+}
+
+// Sets the keys in the map, false deletes them
+func setKeys(m map[string]bool, keys []string, value bool) {
+	for _, key := range keys {
+		if value {
+			m[key] = true
+		} else {
+			delete(m, key)
+		}
+	}
+}
+
+// Returns the sorted keys in a that are not in b
+func difference(a, b map[string]bool) []string {
+	var keys []string
+	for key := range a {
+		if !b[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
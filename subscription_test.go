@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventSubscription_Match(t *testing.T) {
+	answer := &Event{Headers: map[string][]string{"Event-Name": {"CHANNEL_ANSWER"}, "Unique-Id": {"abc"}}}
+	register := &Event{Headers: map[string][]string{"Event-Name": {"CUSTOM"}, "Event-Subclass": {"sofia::register"}}}
+
+	assert.True(t, EventSubscription{}.Match(answer))
+	assert.True(t, EventSubscription{Events: []string{"CHANNEL_ANSWER"}}.Match(answer))
+	assert.False(t, EventSubscription{Events: []string{"CHANNEL_HANGUP"}}.Match(answer))
+	assert.True(t, EventSubscription{UniqueIDs: []string{"abc"}}.Match(answer))
+	assert.False(t, EventSubscription{UniqueIDs: []string{"def"}}.Match(answer))
+	assert.True(t, EventSubscription{Subclasses: []string{"sofia::register"}}.Match(register))
+	assert.False(t, EventSubscription{Subclasses: []string{"sofia::expire"}}.Match(register))
+}
+
+func TestConn_AddSubscription(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	answerID, err := connection.AddSubscription(ctx, EventSubscription{
+		Events:    []string{"CHANNEL_ANSWER"},
+		UniqueIDs: []string{"abc"},
+	}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"event plain CHANNEL_ANSWER",
+		"filter Unique-ID abc",
+	}, server.received())
+
+	registerID, err := connection.AddSubscription(ctx, EventSubscription{
+		Events:     []string{"CHANNEL_ANSWER", "CHANNEL_HANGUP"},
+		Subclasses: []string{"sofia::register"},
+	}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"event plain CHANNEL_HANGUP CUSTOM sofia::register",
+		"filter delete Unique-ID",
+	}, server.received()[2:])
+
+	assert.Nil(t, connection.RemoveSubscription(ctx, registerID))
+	assert.Equal(t, []string{
+		"nixevent plain CHANNEL_HANGUP CUSTOM sofia::register",
+		"filter Unique-ID abc",
+	}, server.received()[4:])
+
+	allID, err := connection.AddSubscription(ctx, EventSubscription{UniqueIDs: []string{"def"}}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"event plain all",
+		"filter Unique-ID def",
+	}, server.received()[6:])
+
+	assert.Nil(t, connection.RemoveSubscription(ctx, allID))
+	assert.Nil(t, connection.RemoveSubscription(ctx, answerID))
+	assert.Equal(t, []string{
+		"noevents",
+		"event plain CHANNEL_ANSWER",
+		"filter delete Unique-ID def",
+		"nixevent plain CHANNEL_ANSWER",
+		"filter delete Unique-ID",
+	}, server.received()[8:])
+	assert.NotNil(t, connection.RemoveSubscription(ctx, answerID))
+}
+
+func TestConn_AddSubscription_ClientSideFilter(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Alone, a client side subscription never filters the connection by channel
+	clientID, err := connection.AddSubscription(ctx, EventSubscription{
+		Events:           []string{"CHANNEL_ANSWER"},
+		UniqueIDs:        []string{"abc"},
+		ClientSideFilter: true,
+	}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"event plain CHANNEL_ANSWER"}, server.received())
+
+	// Once filters are needed by another subscription, its channel is added so it keeps receiving its events
+	serverID, err := connection.AddSubscription(ctx, EventSubscription{Events: []string{"CHANNEL_ANSWER"}, UniqueIDs: []string{"def"}}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"filter Unique-ID abc", "filter Unique-ID def"}, server.received()[1:])
+
+	assert.Nil(t, connection.RemoveSubscription(ctx, serverID))
+	assert.Equal(t, []string{"filter delete Unique-ID"}, server.received()[3:])
+	assert.Nil(t, connection.RemoveSubscription(ctx, clientID))
+	assert.Equal(t, []string{"nixevent plain CHANNEL_ANSWER"}, server.received()[4:])
+}
+
+func TestConn_AddSubscription_Custom(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// CUSTOM is listed once and last, the words after it are subclasses
+	_, err := connection.AddSubscription(ctx, EventSubscription{
+		Events:     []string{"CUSTOM", "DTMF"},
+		Subclasses: []string{"sofia::register"},
+	}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"event plain DTMF CUSTOM sofia::register"}, server.received())
+}
+
+func TestConn_AddSubscription_Failure(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, func(cmd string) string {
+		if strings.HasPrefix(cmd, "filter") {
+			return "Content-Type: command/reply\r\nReply-Text: -ERR invalid syntax\r\n\r\n"
+		}
+		return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+	})
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := connection.AddSubscription(ctx, EventSubscription{Events: []string{"CHANNEL_ANSWER"}, UniqueIDs: []string{"abc"}}, func(event *Event) {})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"event plain CHANNEL_ANSWER", "filter Unique-ID abc"}, server.received())
+
+	// The event command succeeded, so CHANNEL_ANSWER is known to be subscribed and is not sent again
+	id, err := connection.AddSubscription(ctx, EventSubscription{Events: []string{"CHANNEL_ANSWER", "CHANNEL_HANGUP"}}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"event plain CHANNEL_HANGUP"}, server.received()[2:])
+	assert.Nil(t, connection.RemoveSubscription(ctx, id))
+	assert.Equal(t, []string{"nixevent plain CHANNEL_ANSWER CHANNEL_HANGUP"}, server.received()[3:])
+}
+
+func TestConn_AddSubscription_EnableEvents(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := connection.AddSubscription(ctx, EventSubscription{Events: []string{"CHANNEL_ANSWER"}, UniqueIDs: []string{"abc"}}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Nil(t, connection.EnableEvents(ctx))
+	assert.Equal(t, []string{"event plain CHANNEL_ANSWER", "filter Unique-ID abc", "event plain all", "filter delete Unique-ID"}, server.received())
+
+	// Every event stays subscribed, subscriptions neither add nor remove events or filters anymore
+	id, err := connection.AddSubscription(ctx, EventSubscription{Events: []string{"CHANNEL_HANGUP"}, UniqueIDs: []string{"def"}}, func(event *Event) {})
+	assert.Nil(t, err)
+	assert.Nil(t, connection.RemoveSubscription(ctx, id))
+	assert.Len(t, server.received(), 4)
+}
+
+func TestConn_AddSubscription_Dispatch(t *testing.T) {
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	server, connection := newFakeServer(false, opts, nil)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan *Event, 2)
+	_, err := connection.AddSubscription(ctx, EventSubscription{Events: []string{"CHANNEL_ANSWER"}}, func(event *Event) {
+		received <- event
+	})
+	assert.Nil(t, err)
+
+	// Events that do not match are never delivered even if the server sends them
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_HANGUP"}))
+	assert.Nil(t, server.sendEvent(map[string]string{"Event-Name": "CHANNEL_ANSWER"}))
+	select {
+	case event := <-received:
+		assert.Equal(t, "CHANNEL_ANSWER", event.GetName())
+	case <-ctx.Done():
+		t.Fatal("event not delivered")
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Tracer - Creates spans for the commands and events on a connection. Implement it to bridge to a tracing SDK such as OpenTelemetry,
+// the library does not depend on one. See InMemoryTracer for a simple implementation.
+type Tracer interface {
+	// StartSpan - Starts a new span, a child of the span in ctx unless opts.Root is set. Returns a context containing the new span.
+	StartSpan(ctx context.Context, name string, opts SpanOptions) (context.Context, Span)
+}
+
+// SpanOptions - Additional information for a new span
+type SpanOptions struct {
+	Attributes map[string]string // Initial attributes of the span
+	Links      []Span            // Spans that caused this one without being its parent, e.g. the command that triggered an event
+	Root       bool              // Start a new trace instead of continuing the one in the context
+}
+
+// Span - A single traced operation started by a Tracer
+type Span interface {
+	SetAttribute(key, value string)
+	End(err error)
+}
+
+// Span attribute keys set by the connection
+const (
+	SpanAttributeCommand     = "esl.command"
+	SpanAttributeUUID        = "esl.uuid"
+	SpanAttributeReply       = "esl.reply"
+	SpanAttributeEvent       = "esl.event"
+	SpanAttributeChannelUUID = "esl.channel_uuid"
+	SpanAttributeJobUUID     = "esl.job_uuid"
+	SpanAttributeAppUUID     = "esl.application_uuid"
+)
+
+// Limits how many command spans are remembered for linking events, the oldest are forgotten first
+const maxTraceLinks = 4096
+
+// Starts the span for a command if tracing is enabled
+func (c *Conn) startCommandSpan(ctx context.Context, verb, message string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+	attributes := map[string]string{SpanAttributeCommand: verb}
+	if uuid := commandUUID(message); uuid != "" {
+		attributes[SpanAttributeUUID] = uuid
+	}
+	return c.tracer.StartSpan(ctx, "esl.command "+verb, SpanOptions{Attributes: attributes})
+}
+
+// Ends the span for a command, it was already remembered by linkCommandSpan when the reply arrived
+func (c *Conn) endCommandSpan(span Span, message string, response *RawResponse, err error) {
+	if span == nil {
+		return
+	}
+	if response != nil {
+		reply := response.GetReply()
+		span.SetAttribute(SpanAttributeReply, reply)
+		if err == nil && strings.HasPrefix(reply, "-ERR") {
+			err = errors.New(reply)
+		}
+		if jobUUID := response.GetHeader("Job-UUID"); jobUUID != "" {
+			span.SetAttribute(SpanAttributeJobUUID, jobUUID)
+		}
+	}
+	if appUUID := messageHeader(message, "Event-UUID"); appUUID != "" && err == nil {
+		span.SetAttribute(SpanAttributeAppUUID, appUUID)
+	}
+	span.End(err)
+}
+
+// Remembers the span of a command so events caused by it can link back to it. Called from the receive loop when the
+// reply is handed to the command, a BACKGROUND_JOB sent right after the reply is received only after that
+func (c *Conn) linkCommandSpan(span Span, message string, response *RawResponse) {
+	if span == nil {
+		return
+	}
+	if jobUUID := response.GetHeader("Job-UUID"); jobUUID != "" {
+		c.rememberTraceLink(jobUUID, span)
+	}
+	if appUUID := messageHeader(message, "Event-UUID"); appUUID != "" && !strings.HasPrefix(response.GetReply(), "-ERR") {
+		c.rememberTraceLink(appUUID, span)
+	}
+}
+
+func (c *Conn) rememberTraceLink(uuid string, span Span) {
+	c.traceLock.Lock()
+	defer c.traceLock.Unlock()
+	if _, ok := c.traceLinks[uuid]; !ok {
+		c.traceLinkOrder = append(c.traceLinkOrder, uuid)
+	}
+	c.traceLinks[uuid] = span
+	for len(c.traceLinkOrder) > maxTraceLinks {
+		delete(c.traceLinks, c.traceLinkOrder[0])
+		c.traceLinkOrder = c.traceLinkOrder[1:]
+	}
+}
+
+func (c *Conn) forgetTraceLink(uuid string) {
+	delete(c.traceLinks, uuid)
+	for i, linked := range c.traceLinkOrder {
+		if linked == uuid {
+			c.traceLinkOrder = append(c.traceLinkOrder[:i], c.traceLinkOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+// Records a span for a received event, linked to the command that triggered it through the Job-UUID or Application-UUID headers.
+// Events for the channel of an outbound session become children of the session span.
+func (c *Conn) traceEvent(event *Event) {
+	if c.tracer == nil {
+		return
+	}
+	attributes := map[string]string{SpanAttributeEvent: event.GetName()}
+	var links []Span
+
+	c.traceLock.Lock()
+	if jobUUID := event.GetHeader("Job-UUID"); jobUUID != "" {
+		attributes[SpanAttributeJobUUID] = jobUUID
+		if span, ok := c.traceLinks[jobUUID]; ok {
+			links = append(links, span)
+			if event.GetName() == "BACKGROUND_JOB" {
+				c.forgetTraceLink(jobUUID)
+			}
+		}
+	}
+	if appUUID := event.GetHeader("Application-UUID"); appUUID != "" {
+		attributes[SpanAttributeAppUUID] = appUUID
+		if span, ok := c.traceLinks[appUUID]; ok {
+			links = append(links, span)
+			if event.GetName() == "CHANNEL_EXECUTE_COMPLETE" {
+				c.forgetTraceLink(appUUID)
+			}
+		}
+	}
+	ctx := c.runningContext
+	root := true
+	if channelUUID := event.GetHeader("Unique-ID"); channelUUID != "" {
+		attributes[SpanAttributeChannelUUID] = channelUUID
+		if channelUUID == c.sessionUUID && c.sessionContext != nil {
+			ctx = c.sessionContext
+			root = false
+		}
+	}
+	c.traceLock.Unlock()
+
+	_, span := c.tracer.StartSpan(ctx, "esl.event "+event.GetName(), SpanOptions{
+		Attributes: attributes,
+		Links:      links,
+		Root:       root,
+	})
+	span.End(nil)
+}
+
+// Starts the root span of an outbound session, returns the context to hand to the OutboundHandler
+func (c *Conn) startSessionSpan(channelUUID string) (context.Context, Span) {
+	if c.tracer == nil {
+		return c.runningContext, nil
+	}
+	ctx, span := c.tracer.StartSpan(c.runningContext, "esl.session", SpanOptions{
+		Attributes: map[string]string{SpanAttributeChannelUUID: channelUUID},
+		Root:       true,
+	})
+	c.traceLock.Lock()
+	c.sessionUUID = channelUUID
+	c.sessionContext = ctx
+	c.traceLock.Unlock()
+	return ctx, span
+}
+
+// Returns the channel UUID a command targets if it has one, e.g. sendmsg <uuid> or api uuid_kill <uuid>
+func commandUUID(message string) string {
+	firstLine := message
+	if index := strings.IndexAny(message, "\r\n"); index >= 0 {
+		firstLine = message[:index]
+	}
+	fields := strings.Fields(firstLine)
+	switch {
+	case len(fields) >= 2 && fields[0] == "sendmsg":
+		return fields[1]
+	case len(fields) >= 3 && fields[0] == "myevents":
+		return fields[2]
+	case len(fields) >= 3 && (fields[0] == "api" || fields[0] == "bgapi") && strings.HasPrefix(fields[1], "uuid_"):
+		return fields[2]
+	}
+	return ""
+}
+
+// Returns the value of a header in a command message, header names are matched case insensitively
+func messageHeader(message, header string) string {
+	headers := message
+	if index := strings.Index(message, "\r\n\r\n"); index >= 0 {
+		headers = message[:index]
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), header) {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// InMemoryTracer - A Tracer that keeps every span in memory, useful for tests and debugging
+type InMemoryTracer struct {
+	lock    sync.Mutex
+	counter uint64
+	spans   []*inMemorySpan
+}
+
+// RecordedSpan - A copy of a span recorded by an InMemoryTracer
+type RecordedSpan struct {
+	ID         uint64
+	TraceID    uint64
+	ParentID   uint64 // Zero for root spans
+	Name       string
+	Attributes map[string]string
+	Links      []uint64 // IDs of the linked spans
+	Start      time.Time
+	End        time.Time
+	Ended      bool
+	Err        error
+}
+
+type inMemorySpan struct {
+	tracer *InMemoryTracer
+	record RecordedSpan
+}
+
+type inMemorySpanKey struct{}
+
+// NewInMemoryTracer - Creates an empty InMemoryTracer, set it as Options.Tracer to start recording
+func NewInMemoryTracer() *InMemoryTracer {
+	return &InMemoryTracer{}
+}
+
+func (t *InMemoryTracer) StartSpan(ctx context.Context, name string, opts SpanOptions) (context.Context, Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.counter++
+	span := &inMemorySpan{
+		tracer: t,
+		record: RecordedSpan{
+			ID:         t.counter,
+			TraceID:    t.counter,
+			Name:       name,
+			Attributes: make(map[string]string, len(opts.Attributes)),
+			Start:      time.Now(),
+		},
+	}
+	if parent, ok := ctx.Value(inMemorySpanKey{}).(*inMemorySpan); ok && !opts.Root {
+		span.record.ParentID = parent.record.ID
+		span.record.TraceID = parent.record.TraceID
+	}
+	for key, value := range opts.Attributes {
+		span.record.Attributes[key] = value
+	}
+	for _, link := range opts.Links {
+		if linked, ok := link.(*inMemorySpan); ok {
+			span.record.Links = append(span.record.Links, linked.record.ID)
+		}
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, inMemorySpanKey{}, span), span
+}
+
+// Spans - Returns a copy of every span recorded so far in the order they were started
+func (t *InMemoryTracer) Spans() []RecordedSpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	spans := make([]RecordedSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = span.record
+		spans[i].Attributes = make(map[string]string, len(span.record.Attributes))
+		for key, value := range span.record.Attributes {
+			spans[i].Attributes[key] = value
+		}
+		spans[i].Links = append([]uint64(nil), span.record.Links...)
+	}
+	return spans
+}
+
+// Reset - Forgets every recorded span
+func (t *InMemoryTracer) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.spans = nil
+}
+
+func (s *inMemorySpan) SetAttribute(key, value string) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.record.Attributes[key] = value
+}
+
+func (s *inMemorySpan) End(err error) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	if s.record.Ended {
+		return
+	}
+	s.record.End = time.Now()
+	s.record.Ended = true
+	s.record.Err = err
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2020 Opensmarty
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ *
+ * Contributor(s):
+ * Opensmarty  <opensmarty@163.com>
+ */
+package eslgo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuguocloud/eslgo/command"
+	"github.com/shuguocloud/eslgo/command/call"
+	"github.com/stretchr/testify/assert"
+)
+
+func tracingReply(cmd string) string {
+	switch {
+	case strings.HasPrefix(cmd, "bgapi"):
+		return "Content-Type: command/reply\r\nReply-Text: +OK Job-UUID: job-1\r\nJob-UUID: job-1\r\n\r\n"
+	case strings.HasPrefix(cmd, "connect"):
+		return "Content-Type: command/reply\r\nReply-Text: +OK\r\nUnique-ID: channel-1\r\n\r\n"
+	}
+	return "Content-Type: command/reply\r\nReply-Text: +OK\r\n\r\n"
+}
+
+// Sends an event and waits until it has been dispatched, which is after its span was recorded
+func sendTracedEvent(t *testing.T, server *fakeServer, connection *Conn, headers map[string]string) {
+	events := connection.Once(context.Background(), MatchEventName(headers["Event-Name"]))
+	assert.Nil(t, server.sendEvent(headers))
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not dispatched")
+	}
+}
+
+func findSpan(spans []RecordedSpan, name string) RecordedSpan {
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return RecordedSpan{}
+}
+
+func TestConn_Tracing(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	opts.Tracer = tracer
+	server, connection := newFakeServer(false, opts, tracingReply)
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := connection.SendCommand(ctx, command.API{Command: "uuid_answer", Arguments: "channel-1", Background: true})
+	assert.Nil(t, err)
+	_, err = connection.SendCommand(ctx, &call.Execute{UUID: "channel-1", AppName: "playback", AppArgs: "test.wav", AppUUID: "app-1"})
+	assert.Nil(t, err)
+	sendTracedEvent(t, server, connection, map[string]string{"Event-Name": "BACKGROUND_JOB", "Job-UUID": "job-1"})
+	sendTracedEvent(t, server, connection, map[string]string{"Event-Name": "CHANNEL_EXECUTE_COMPLETE", "Unique-ID": "channel-1", "Application-UUID": "app-1"})
+
+	spans := tracer.Spans()
+	bgapi := findSpan(spans, "esl.command bgapi")
+	assert.True(t, bgapi.Ended)
+	assert.Equal(t, "bgapi", bgapi.Attributes[SpanAttributeCommand])
+	assert.Equal(t, "channel-1", bgapi.Attributes[SpanAttributeUUID])
+	assert.Equal(t, "+OK Job-UUID: job-1", bgapi.Attributes[SpanAttributeReply])
+	assert.Equal(t, "job-1", bgapi.Attributes[SpanAttributeJobUUID])
+
+	sendmsg := findSpan(spans, "esl.command sendmsg")
+	assert.Equal(t, "channel-1", sendmsg.Attributes[SpanAttributeUUID])
+	assert.Equal(t, "app-1", sendmsg.Attributes[SpanAttributeAppUUID])
+
+	job := findSpan(spans, "esl.event BACKGROUND_JOB")
+	assert.Equal(t, []uint64{bgapi.ID}, job.Links)
+	complete := findSpan(spans, "esl.event CHANNEL_EXECUTE_COMPLETE")
+	assert.Equal(t, []uint64{sendmsg.ID}, complete.Links)
+	assert.Equal(t, "channel-1", complete.Attributes[SpanAttributeChannelUUID])
+
+	// Completed jobs and applications are forgotten
+	assert.Empty(t, connection.traceLinks)
+}
+
+// Holds the caller of a command back until the event was dispatched, like a caller that is scheduled late
+type slowCallerObserver struct {
+	NilObserver
+	dispatched <-chan *Event
+}
+
+func (o slowCallerObserver) CommandSent(string, time.Duration, error) {
+	select {
+	case <-o.dispatched:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestConn_Tracing_FastJob(t *testing.T) {
+	job := "Event-Name: BACKGROUND_JOB\nJob-UUID: job-1\n\n"
+	tracer := NewInMemoryTracer()
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	opts.Tracer = tracer
+	dispatched := make(chan *Event, 1)
+	opts.Observer = slowCallerObserver{dispatched: dispatched}
+	// The job finishes so fast that its event is sent together with the reply
+	server, connection := newFakeServer(false, opts, func(cmd string) string {
+		return tracingReply(cmd) + "Content-Length: " + strconv.Itoa(len(job)) + "\r\nContent-Type: text/event-plain\r\n\r\n" + job
+	})
+	defer server.close()
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connection.RegisterMatchListener(MatchEventName("BACKGROUND_JOB"), func(event *Event) {
+		dispatched <- event
+	})
+	_, err := connection.SendCommand(ctx, command.API{Command: "status", Background: true})
+	assert.Nil(t, err)
+
+	spans := tracer.Spans()
+	bgapi := findSpan(spans, "esl.command bgapi")
+	assert.Equal(t, []uint64{bgapi.ID}, findSpan(spans, "esl.event BACKGROUND_JOB").Links)
+}
+
+func TestConn_Tracing_OutboundSession(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	opts := DefaultOptions
+	opts.Logger = NilLogger{}
+	opts.Tracer = tracer
+	server, connection := newFakeServer(true, opts, tracingReply)
+	defer server.close()
+
+	done := make(chan struct{})
+	go connection.outboundHandle(func(ctx context.Context, conn *Conn, response *RawResponse) {
+		_, err := conn.SendCommand(ctx, command.MyEvents{Format: "plain"})
+		assert.Nil(t, err)
+		sendTracedEvent(t, server, conn, map[string]string{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "channel-1"})
+	}, OutboundOptions{ConnectTimeout: 5 * time.Second})
+	go func() {
+		<-connection.runningContext.Done()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not finish")
+	}
+
+	assert.Eventually(t, func() bool {
+		return findSpan(tracer.Spans(), "esl.session").Ended
+	}, 5*time.Second, 10*time.Millisecond)
+	spans := tracer.Spans()
+	session := findSpan(spans, "esl.session")
+	assert.Equal(t, uint64(0), session.ParentID)
+	assert.Equal(t, "channel-1", session.Attributes[SpanAttributeChannelUUID])
+	assert.Equal(t, session.ID, findSpan(spans, "esl.command myevents").ParentID)
+	answer := findSpan(spans, "esl.event CHANNEL_ANSWER")
+	assert.Equal(t, session.ID, answer.ParentID)
+	assert.Equal(t, session.TraceID, answer.TraceID)
+}
@@ -11,6 +11,7 @@
 package eslgo
 
 import (
+	"crypto/rand"
 	"fmt"
 	"strings"
 )
@@ -39,3 +40,13 @@ func BuildVars(format string, vars map[string]string) string {
 	}
 	return fmt.Sprintf(format, builder.String())
 }
+
+// NewUUID - Generates a random version 4 UUID, e.g. to send as the Event-UUID of an executed app so its
+// CHANNEL_EXECUTE_COMPLETE event can be told apart, or as the UUID of a channel to originate
+func NewUUID() string {
+	var data [16]byte
+	_, _ = rand.Read(data[:])
+	data[6] = (data[6] & 0x0f) | 0x40
+	data[8] = (data[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:])
+}
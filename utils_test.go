@@ -35,3 +35,9 @@ func Test_BuildVars(t *testing.T) {
 	assert.True(t, strings.HasPrefix(vars, "{"))
 	assert.True(t, strings.HasSuffix(vars, "}"))
 }
+
+func Test_NewUUID(t *testing.T) {
+	uuid := NewUUID()
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, uuid)
+	assert.NotEqual(t, uuid, NewUUID())
+}